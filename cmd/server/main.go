@@ -1,21 +1,49 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"dhi-oss-usage/internal/api"
+	"dhi-oss-usage/internal/bootstrap"
 	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/diagnostics"
+	"dhi-oss-usage/internal/ecosystem"
 	"dhi-oss-usage/internal/github"
-
-	"github.com/robfig/cron/v3"
+	"dhi-oss-usage/internal/health"
+	"dhi-oss-usage/internal/metrics"
+	"dhi-oss-usage/internal/scheduler"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diagnose" {
+		runDiagnose(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "publish-bootstrap" {
+		runPublishBootstrap(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "smoke" {
+		runSmoke(os.Args[2:])
+		return
+	}
+
+	// Keep recent log lines in memory for diagnostics bundles
+	logRing := diagnostics.NewLogRing(1000)
+	log.SetOutput(io.MultiWriter(os.Stderr, logRing))
+
 	// Get port from env or default to 8000
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -34,10 +62,14 @@ func main() {
 		log.Println("WARNING: GITHUB_TOKEN not set, refresh will not work")
 	}
 
-	// Get refresh schedule (cron syntax, empty = disabled)
-	refreshSchedule := os.Getenv("REFRESH_SCHEDULE")
+	// Get refresh schedule (cron syntax, "disabled" turns it off). REFRESH_SCHEDULE
+	// is the older name and still honored.
+	refreshSchedule := os.Getenv("DHI_REFRESH_SCHEDULE")
+	if refreshSchedule == "" {
+		refreshSchedule = os.Getenv("REFRESH_SCHEDULE")
+	}
 	if refreshSchedule == "" {
-		refreshSchedule = "0 3 * * *" // Default: 3 AM daily
+		refreshSchedule = scheduler.DefaultSchedule
 	}
 	if strings.ToLower(refreshSchedule) == "disabled" {
 		refreshSchedule = ""
@@ -56,15 +88,117 @@ func main() {
 	}
 	log.Println("Database initialized")
 
+	// Seed an empty database from a published dataset instead of waiting for a full refresh
+	if url := os.Getenv("BOOTSTRAP_URL"); url != "" {
+		bootstrapIfEmpty(database, url, os.Getenv("BOOTSTRAP_SHA256"))
+	}
+
 	// Create GitHub client
-	ghClient := github.NewClient(ghToken)
+	ghOpts := []github.Option{github.WithETagStore(database)}
+	if v := os.Getenv("GITHUB_BASE_URL"); v != "" {
+		ghOpts = append(ghOpts, github.WithBaseURL(v))
+	}
+	if v := os.Getenv("REGISTRIES"); v != "" {
+		ghOpts = append(ghOpts, github.WithRegistries(strings.Split(v, ",")...))
+	}
+	if v := os.Getenv("GITHUB_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid GITHUB_CONCURRENCY %q: must be a positive integer", v)
+		}
+		ghOpts = append(ghOpts, github.WithConcurrency(n))
+	}
+	ghClient := github.NewClient(ghToken, ghOpts...)
 
 	// Create API
-	apiHandler := api.New(database, ghClient)
+	var apiOpts []api.Option
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		apiOpts = append(apiOpts, api.WithCORS(strings.Split(v, ",")))
+	}
+	if v := os.Getenv("API_KEYS"); v != "" {
+		apiOpts = append(apiOpts, api.WithAPIKeys(strings.Split(v, ",")))
+	}
+	cacheSize := defaultCacheSize
+	if v := os.Getenv("API_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid API_CACHE_SIZE %q: must be a non-negative integer", v)
+		}
+		cacheSize = n
+	}
+	apiOpts = append(apiOpts, api.WithCache(cacheSize))
+	apiHandler := api.New(database, ghClient, apiOpts...)
+	apiHandler.SetTokenConfigured(ghToken != "")
+	apiHandler.SetDebug(os.Getenv("DEBUG") == "true")
+	apiHandler.SetRequireAPIKey(os.Getenv("API_REQUIRE_KEY") == "true")
+	if spec := os.Getenv("LANGUAGE_ECOSYSTEMS"); spec != "" {
+		mapping, err := ecosystem.Parse(spec)
+		if err != nil {
+			log.Fatalf("Invalid LANGUAGE_ECOSYSTEMS: %v", err)
+		}
+		apiHandler.SetEcosystems(mapping)
+	}
+	if v := os.Getenv("SNAPSHOT_MIN_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid SNAPSHOT_MIN_INTERVAL %q: must be a duration like 30m or 0", v)
+		}
+		apiHandler.SetSnapshotInterval(d)
+	}
+	if v := os.Getenv("WEBHOOK_URL"); v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid WEBHOOK_URL %q: must be an http or https URL", v)
+		}
+		apiHandler.SetWebhook(v, os.Getenv("WEBHOOK_SECRET"))
+	}
+	if os.Getenv("POPULAR_MIN_STARS") != "" || os.Getenv("NOTABLE_MIN_STARS") != "" {
+		t := db.DefaultStarThresholds
+		for name, n := range map[string]*int{"POPULAR_MIN_STARS": &t.Popular, "NOTABLE_MIN_STARS": &t.Notable} {
+			if v := os.Getenv(name); v != "" {
+				i, err := strconv.Atoi(v)
+				if err != nil {
+					log.Fatalf("Invalid %s %q: must be an integer", name, v)
+				}
+				*n = i
+			}
+		}
+		if err := t.Validate(); err != nil {
+			log.Fatalf("Invalid POPULAR_MIN_STARS/NOTABLE_MIN_STARS: %v", err)
+		}
+		apiHandler.SetStarThresholds(t)
+	}
+	if spec := os.Getenv("HEALTH_WEIGHTS"); spec != "" {
+		rules, err := health.Parse(spec)
+		if err != nil {
+			log.Fatalf("Invalid HEALTH_WEIGHTS: %v", err)
+		}
+		apiHandler.SetHealthRules(rules)
+	}
+	apiHandler.SetDiagnosticsOptions(diagnostics.Options{
+		Config:    effectiveConfig(),
+		Logs:      logRing,
+		RateLimit: func() interface{} { return ghClient.RateLimitStatus() },
+	})
+
+	if err := apiHandler.SeedSearchQueries(); err != nil {
+		log.Fatalf("Failed to store search queries: %v", err)
+	}
+
+	// Jobs left pending or running by the previous process will never finish
+	apiHandler.CleanupRefreshJobs(true)
+	apiHandler.UpdateProjectMetrics()
+	apiHandler.UpdateRefreshMetrics()
 
-	// Setup scheduler
+	// Setup scheduler; it may catch up a missed run right away
 	if refreshSchedule != "" {
-		setupScheduler(apiHandler, refreshSchedule)
+		sched, err := scheduler.New(refreshSchedule, apiHandler, database)
+		if err != nil {
+			log.Fatalf("Invalid DHI_REFRESH_SCHEDULE: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sched.Start(ctx)
+		apiHandler.SetNextRefreshFunc(sched.Next)
 	} else {
 		log.Println("Scheduled refresh disabled")
 	}
@@ -72,6 +206,12 @@ func main() {
 	// Check if data is stale and trigger immediate refresh if needed
 	checkAndRefreshStaleData(apiHandler)
 
+	// Snapshot once a day regardless of refreshes so the history has no gaps
+	go runDailySnapshots(apiHandler, time.Hour)
+
+	// Remove pending jobs that were never started
+	go runJobCleanup(apiHandler, time.Hour)
+
 	// Setup routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
@@ -79,6 +219,21 @@ func main() {
 	// Register API routes
 	apiHandler.RegisterRoutes(mux)
 
+	// Serve metrics on their own port when METRICS_ADDR is set, e.g. ":9090", so
+	// they needn't be exposed with the API
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Printf("Metrics listening on %s", addr)
+			if err := http.ListenAndServe(addr, metricsMux); err != nil {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	} else {
+		mux.Handle("/metrics", metrics.Handler())
+	}
+
 	// Serve static files
 	staticDir := os.Getenv("STATIC_DIR")
 	if staticDir == "" {
@@ -92,33 +247,138 @@ func main() {
 	}
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+// defaultCacheSize is how many API query results are cached when API_CACHE_SIZE is unset
+const defaultCacheSize = 256
+
+// configEnvVars lists the environment variables that make up the effective config
+var configEnvVars = []string{"PORT", "DB_PATH", "GITHUB_TOKEN", "DHI_REFRESH_SCHEDULE", "REFRESH_SCHEDULE", "STATIC_DIR", "GITHUB_CONCURRENCY", "LANGUAGE_ECOSYSTEMS", "DEBUG", "REGISTRIES", "CORS_ORIGINS", "HEALTH_WEIGHTS", "GITHUB_BASE_URL", "API_KEYS", "API_REQUIRE_KEY", "BOOTSTRAP_URL", "BOOTSTRAP_SHA256", "METRICS_ADDR", "SNAPSHOT_MIN_INTERVAL", "WEBHOOK_URL", "WEBHOOK_SECRET", "API_CACHE_SIZE", "POPULAR_MIN_STARS", "NOTABLE_MIN_STARS"}
+
+// effectiveConfig returns the configuration environment. Secrets are redacted
+// when the config is written to a diagnostics bundle.
+func effectiveConfig() map[string]string {
+	config := make(map[string]string, len(configEnvVars))
+	for _, k := range configEnvVars {
+		config[k] = os.Getenv(k)
+	}
+	return config
 }
 
-func setupScheduler(apiHandler *api.API, schedule string) {
-	c := cron.New()
-	_, err := c.AddFunc(schedule, func() {
-		log.Printf("Scheduled refresh triggered (schedule: %s)", schedule)
-		apiHandler.TriggerRefresh("scheduled")
+// runDiagnose implements the "diagnose" subcommand, writing a diagnostics bundle to a file
+func runDiagnose(args []string) {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	out := fs.String("out", "bundle.tar.gz", "output file for the diagnostics bundle")
+	includeData := fs.Bool("include-data", false, "include raw project rows")
+	fs.Parse(args)
+
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "dhi-oss-usage.db"
+	}
+	database, err := db.Open(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *out, err)
+	}
+	defer f.Close()
+
+	err = diagnostics.WriteBundle(f, database, diagnostics.Options{
+		Config:      effectiveConfig(),
+		IncludeData: *includeData,
 	})
 	if err != nil {
-		log.Printf("ERROR: Failed to setup scheduler with schedule '%s': %v", schedule, err)
+		log.Fatalf("Failed to write diagnostics bundle: %v", err)
+	}
+	log.Printf("Wrote diagnostics bundle to %s", *out)
+}
+
+// runPublishBootstrap implements the "publish-bootstrap" subcommand, writing a dataset
+// archive for new deployments plus a sha256sum-style checksum file next to it
+func runPublishBootstrap(args []string) {
+	fs := flag.NewFlagSet("publish-bootstrap", flag.ExitOnError)
+	out := fs.String("out", "dataset.json.gz", "output file for the dataset archive")
+	fs.Parse(args)
+
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "dhi-oss-usage.db"
+	}
+	database, err := db.Open(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *out, err)
+	}
+	sum, err := bootstrap.Publish(f, database, time.Now())
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Fatalf("Failed to write dataset archive: %v", err)
+	}
+
+	checksum := fmt.Sprintf("%s  %s\n", sum, filepath.Base(*out))
+	if err := os.WriteFile(*out+".sha256", []byte(checksum), 0o644); err != nil {
+		log.Fatalf("Failed to write checksum: %v", err)
+	}
+	log.Printf("Wrote dataset archive to %s (sha256 %s)", *out, sum)
+}
+
+// bootstrapIfEmpty imports the dataset at url when no projects have been stored yet.
+// Failures are logged and leave the database empty, so the startup refresh runs as usual.
+func bootstrapIfEmpty(database *db.DB, url, sha256 string) {
+	hasProjects, err := database.HasProjects()
+	if err != nil {
+		log.Printf("Bootstrap skipped: %v", err)
+		return
+	}
+	if hasProjects {
 		return
 	}
-	c.Start()
-	log.Printf("Scheduler started: refresh at '%s'", schedule)
 
-	// Set function to get next scheduled refresh time
-	apiHandler.SetNextRefreshFunc(func() *time.Time {
-		entries := c.Entries()
-		if len(entries) > 0 {
-			next := entries[0].Next
-			return &next
-		}
-		return nil
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	archive, err := bootstrap.Fetch(ctx, url, sha256)
+	if err != nil {
+		log.Printf("Bootstrap failed: %v", err)
+		return
+	}
+	n, err := bootstrap.Import(database, archive)
+	if err != nil {
+		log.Printf("Bootstrap failed: %v", err)
+		return
+	}
+	log.Printf("Bootstrapped %d projects from %s", n, url)
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// runDailySnapshots checks every interval whether today's snapshot still needs recording
+func runDailySnapshots(apiHandler *api.API, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		apiHandler.RecordDailySnapshot(now)
+	}
+}
+
+func runJobCleanup(apiHandler *api.API, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		apiHandler.CleanupRefreshJobs(false)
+	}
 }
 
 func checkAndRefreshStaleData(apiHandler *api.API) {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"dhi-oss-usage/internal/api"
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/pkg/tracker"
+)
+
+// smokeResult is the machine-readable outcome of the "smoke" subcommand, written to stdout
+type smokeResult struct {
+	OK            bool   `json:"ok"`
+	Query         string `json:"query"`
+	JobID         int64  `json:"job_id,omitempty"`
+	Projects      int    `json:"projects"`
+	RequestsUsed  int    `json:"requests_used"`
+	RequestBudget int    `json:"request_budget"`
+	DurationMS    int64  `json:"duration_ms"`
+	DeadlineMS    int64  `json:"deadline_ms"`
+	Error         string `json:"error,omitempty"`
+}
+
+// runSmoke implements the "smoke" subcommand: discovery for one page of one query
+// against GitHub, with details for a few repos, into an in-memory database. It
+// fails if the run errors, finds nothing, overruns the deadline or would exceed the
+// request budget, so CI can check the real pipeline without spending real quota.
+func runSmoke(args []string) {
+	if !smokeCommand(args, os.Stdout) {
+		os.Exit(1)
+	}
+}
+
+// smokeCommand runs the smoke subcommand, writes its result to stdout as JSON
+// and reports whether it passed
+func smokeCommand(args []string, stdout io.Writer) bool {
+	fs := flag.NewFlagSet("smoke", flag.ExitOnError)
+	queryName := fs.String("query", "", "name of the search query to run (default: the first configured query)")
+	maxRepos := fs.Int("max-repos", 5, "most repos to fetch details for")
+	budget := fs.Int("budget", 10, "most GitHub requests the run may make")
+	timeout := fs.Duration("timeout", time.Minute, "deadline for the whole run")
+	fs.Parse(args)
+
+	ghToken := os.Getenv("GITHUB_TOKEN")
+	if ghToken == "" && os.Getenv("GITHUB_BASE_URL") == "" {
+		log.Fatal("GITHUB_TOKEN not set")
+	}
+
+	var ghOpts []github.Option
+	if v := os.Getenv("GITHUB_BASE_URL"); v != "" {
+		ghOpts = append(ghOpts, github.WithBaseURL(v))
+	}
+	if v := os.Getenv("REGISTRIES"); v != "" {
+		ghOpts = append(ghOpts, github.WithRegistries(strings.Split(v, ",")...))
+	}
+	name := *queryName
+	if name == "" {
+		name = github.NewClient(ghToken, ghOpts...).SearchQueries()[0].Name
+	}
+	ghClient := github.NewClient(ghToken, append(ghOpts,
+		github.WithSearchOptions(github.SearchOptions{Queries: []string{name}, MaxPages: 1, MaxRepos: *maxRepos}),
+		github.WithRequestBudget(*budget),
+		// A retry would only spend budget and time the deadline doesn't allow for
+		github.WithRetry(1, 0),
+	)...)
+
+	res := smokeResult{Query: name, RequestBudget: *budget, DeadlineMS: timeout.Milliseconds()}
+	err := smoke(ghClient, name, *timeout, &res)
+	res.RequestsUsed = ghClient.RequestsUsed()
+	if err == nil && ghClient.BudgetExceeded() {
+		err = fmt.Errorf("%w: %d requests", github.ErrBudgetExceeded, *budget)
+	}
+	if err != nil {
+		res.Error = github.Describe(err)
+	}
+	res.OK = err == nil
+
+	json.NewEncoder(stdout).Encode(res)
+	return res.OK
+}
+
+// smoke runs discovery into a fresh in-memory database and fills in res
+func smoke(ghClient *github.Client, name string, timeout time.Duration, res *smokeResult) error {
+	if len(ghClient.SearchQueries()) == 0 {
+		return fmt.Errorf("no search query named %q", name)
+	}
+
+	database, err := db.Open(":memory:")
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	// Every connection to :memory: is a separate database
+	database.SetMaxOpenConns(1)
+	if err := database.Migrate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	started := time.Now()
+	jobID, out, err := api.New(database, ghClient).RunRefreshStages(ctx, "smoke", tracker.ScopeDiscover)
+	res.DurationMS = time.Since(started).Milliseconds()
+	res.JobID = jobID
+	if out != nil {
+		res.Projects = len(out.Projects)
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("deadline of %s exceeded", timeout)
+	case err != nil:
+		return err
+	case res.Projects == 0:
+		return errors.New("no projects found")
+	}
+	return nil
+}
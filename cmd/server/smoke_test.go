@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeGitHub serves code search results for repos and their REST details. GraphQL
+// is unavailable, so details take one request per repo.
+func fakeGitHub(t *testing.T, repos []string, requests *atomic.Int64) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch {
+		case r.URL.Path == "/api/v3/search/code":
+			type item struct {
+				Path       string `json:"path"`
+				HTMLURL    string `json:"html_url"`
+				Repository struct {
+					FullName string `json:"full_name"`
+					HTMLURL  string `json:"html_url"`
+				} `json:"repository"`
+			}
+			items := make([]item, len(repos))
+			for i, name := range repos {
+				items[i].Path = "Dockerfile"
+				items[i].HTMLURL = "https://github.com/" + name + "/blob/main/Dockerfile"
+				items[i].Repository.FullName = name
+				items[i].Repository.HTMLURL = "https://github.com/" + name
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": len(items), "items": items})
+		case strings.HasPrefix(r.URL.Path, "/api/v3/repos/"):
+			name := strings.TrimPrefix(r.URL.Path, "/api/v3/repos/")
+			fmt.Fprintf(w, `{"full_name":%q,"html_url":"https://github.com/%s","stargazers_count":3,"language":"Go"}`, name, name)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("GITHUB_TOKEN", "test-token")
+	t.Setenv("GITHUB_BASE_URL", srv.URL)
+	t.Setenv("REGISTRIES", "")
+}
+
+func TestSmokeCommand(t *testing.T) {
+	repos := []string{"acme/a", "acme/b", "acme/c"}
+	tests := []struct {
+		name     string
+		repos    []string
+		args     []string
+		ok       bool
+		projects int
+		requests int // one search page, one failed GraphQL batch and a REST call per repo
+		errMatch string
+	}{
+		{"within budget", repos, []string{"-max-repos", "2", "-budget", "10"}, true, 2, 4, ""},
+		{"exact budget", repos, []string{"-max-repos", "2", "-budget", "4"}, true, 2, 4, ""},
+		{"budget exceeded", repos, []string{"-max-repos", "2", "-budget", "3"}, false, 1, 3, "budget"},
+		{"nothing found", nil, []string{"-budget", "10"}, false, 0, 1, "no projects found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served atomic.Int64
+			fakeGitHub(t, tt.repos, &served)
+
+			var out bytes.Buffer
+			ok := smokeCommand(tt.args, &out)
+			var res smokeResult
+			if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+				t.Fatalf("output %q is not a result: %v", out.String(), err)
+			}
+			if ok != tt.ok || res.OK != tt.ok {
+				t.Errorf("passed = %v (result ok %v), want %v; error %q", ok, res.OK, tt.ok, res.Error)
+			}
+			if res.Projects != tt.projects {
+				t.Errorf("projects = %d, want %d", res.Projects, tt.projects)
+			}
+			// Requests refused by the budget are neither sent nor counted
+			if res.RequestsUsed != tt.requests || int(served.Load()) != tt.requests {
+				t.Errorf("requests_used = %d with %d served, want %d", res.RequestsUsed, served.Load(), tt.requests)
+			}
+			if !strings.Contains(res.Error, tt.errMatch) {
+				t.Errorf("error %q, want it to mention %q", res.Error, tt.errMatch)
+			}
+			if res.Query == "" || res.JobID == 0 {
+				t.Errorf("result without query or job: %+v", res)
+			}
+		})
+	}
+}
@@ -2,74 +2,333 @@ package api
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/diagnostics"
+	"dhi-oss-usage/internal/ecosystem"
 	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/internal/health"
+	"dhi-oss-usage/internal/webhook"
 )
 
 type API struct {
 	db             *db.DB
-	ghClient       *github.Client
+	ghClient       github.GitHubClient
 	refreshMu      sync.Mutex
 	refreshRunning bool
-	nextRefreshFn  func() *time.Time // function to get next scheduled refresh time
+	refreshJobID   int64              // job being run, 0 between runs; guarded by refreshMu
+	refreshCancel  context.CancelFunc // cancels the running refresh; nil when none is running
+	progress       *progressHub       // refresh progress for /api/refresh/progress subscribers
+	nextRefreshFn  func() *time.Time  // function to get next scheduled refresh time
+	diagOpts       diagnostics.Options
+	ecosystems     *ecosystem.Mapping
+	healthRules    *health.Rules
+	snapshotGap    time.Duration // least time between post-refresh snapshots
+	starThresholds db.StarThresholds
+	webhook        *webhook.Sender // notified of newly found projects; nil when not configured
+	hasToken       bool
+	debug          bool            // include error details in responses; off in production
+	corsOrigins    map[string]bool // origins allowed cross-origin access; empty disables CORS
+	apiKeys        []string        // admin keys; with none and no stored admin keys, mutating requests are open
+	requireKey     bool            // reject anonymous callers on every route, reads included
+	tierLimiters   map[Tier]*rateLimiter
+	now            func() time.Time // clock for refresh cooldowns and job cleanup
+	cache          *queryCache      // project lists and stats; nil when caching is off
 }
 
-func New(database *db.DB, ghClient *github.Client) *API {
-	return &API{
-		db:       database,
-		ghClient: ghClient,
+func New(database *db.DB, ghClient github.GitHubClient, opts ...Option) *API {
+	a := &API{
+		db:             database,
+		ghClient:       ghClient,
+		ecosystems:     ecosystem.Default(),
+		healthRules:    health.Default(),
+		snapshotGap:    defaultSnapshotGap,
+		starThresholds: db.DefaultStarThresholds,
+		progress:       newProgressHub(),
+		tierLimiters:   make(map[Tier]*rateLimiter, len(tierRateLimits)),
+		now:            time.Now,
 	}
+	for tier, limit := range tierRateLimits {
+		a.tierLimiters[tier] = newRateLimiter(limit, tierRateWindow)
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
-// RegisterRoutes adds API routes to the mux
 // SetNextRefreshFunc sets a function that returns the next scheduled refresh time
 func (a *API) SetNextRefreshFunc(fn func() *time.Time) {
 	a.nextRefreshFn = fn
 }
 
+// SetDiagnosticsOptions sets the config and log buffer included in diagnostics bundles
+func (a *API) SetDiagnosticsOptions(opts diagnostics.Options) {
+	a.diagOpts = opts
+}
+
+// SetTokenConfigured records whether a GitHub token was provided, for the health check
+func (a *API) SetTokenConfigured(ok bool) {
+	a.hasToken = ok
+}
+
+// SetHealthRules sets the factor weights used to grade projects during a refresh
+func (a *API) SetHealthRules(r *health.Rules) {
+	a.healthRules = r
+}
+
+// SetDebug controls whether error responses include the underlying error
+func (a *API) SetDebug(debug bool) {
+	a.debug = debug
+}
+
+// SetRequireAPIKey controls whether every API request, reads included, needs a
+// key of any tier. Probes on /healthz are never asked for one.
+func (a *API) SetRequireAPIKey(require bool) {
+	a.requireKey = require
+}
+
+// errorBody is the JSON body of every API error response
+type errorBody struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`             // machine-readable, from errorCode
+	Detail string `json:"detail,omitempty"` // underlying error, in debug mode only
+}
+
+// errorCode names an HTTP status for clients, e.g. "bad_request" for 400 or
+// "internal_server_error" for 500
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// apiError writes a JSON error response. The detail error is only exposed in debug mode.
+func (a *API) apiError(w http.ResponseWriter, code int, msg string, detail error) {
+	body := errorBody{Error: msg, Code: errorCode(code)}
+	if a.debug && detail != nil {
+		body.Detail = detail.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// RegisterRoutes adds API routes to the mux. Request metrics, CORS, tier and gzip
+// middleware wrap every route; routes that change state additionally require an admin key.
 func (a *API) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/api/projects", a.handleProjects)
-	mux.HandleFunc("/api/projects/new", a.handleNewProjects)
-	mux.HandleFunc("/api/stats", a.handleStats)
-	mux.HandleFunc("/api/source-types", a.handleSourceTypes)
-	mux.HandleFunc("/api/refresh", a.handleRefresh)
-	mux.HandleFunc("/api/refresh/status", a.handleRefreshStatus)
-	mux.HandleFunc("/api/history", a.handleHistory)
+	// Probes bypass the middleware so they are never rate limited or compressed
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	a.handle(mux, "/api/health", a.handleHealth)
+	a.handleMutating(mux, "/api/projects", a.handleProjects)
+	a.handle(mux, "/api/projects.csv", a.handleProjectsCSV)
+	a.handle(mux, "/api/projects/export", a.handleProjectsExport)
+	a.handle(mux, "/api/projects/new", a.handleNewProjects)
+	a.handle(mux, "/api/projects/trending", a.handleTrendingProjects)
+	a.handleMutating(mux, "/api/projects/{owner}/{repo}", a.handleProject)
+	a.handle(mux, "/api/projects/{owner}/{repo}/file-changes", a.handleFileChanges)
+	a.handleMutating(mux, "/api/projects/{id}", a.handleProjectByID)
+	// Routes by project id that add a segment live outside /api/projects/, where
+	// they would shadow repos named e.g. owner/similar or owner/notes
+	a.handle(mux, "/api/similar-projects/{id}", a.handleSimilarProjects)
+	a.handleMutating(mux, "/api/project-notes/{id}", a.handleProjectNotes)
+	a.handleMutating(mux, "/api/notes/{id}", a.handleNote)
+	a.handle(mux, "/api/stats", a.handleStats)
+	a.handle(mux, "/api/stats/trends", a.handleStatsTrends)
+	a.handle(mux, "/api/stats/growth", a.handleStatsGrowth)
+	a.handle(mux, "/api/stats/languages", a.handleStatsLanguages)
+	a.handle(mux, "/api/source-types", a.handleSourceTypes)
+	a.handle(mux, "/api/languages", a.handleLanguages)
+	a.handle(mux, "/api/languages/summary", a.handleLanguageSummary)
+	a.handle(mux, "/api/topics", a.handleTopics)
+	a.handle(mux, "/api/images", a.handleImages)
+	a.handle(mux, "/api/ecosystems", a.handleEcosystems)
+	a.handle(mux, "/api/snapshots", a.handleSnapshots)
+	a.handle(mux, "/api/badge/{kind}", a.handleBadge)
+	a.handleMutating(mux, "/api/refresh", a.handleRefresh)
+	a.handleMutating(mux, "/api/refresh/cancel", a.handleRefreshCancel)
+	a.handle(mux, "/api/refresh/status", a.handleRefreshStatus)
+	a.handle(mux, "/api/refresh/progress", a.handleRefreshProgress)
+	a.handle(mux, "/api/refresh/stream", a.handleRefreshProgress)
+	a.handle(mux, "/api/refresh/history", a.handleRefreshHistory)
+	a.handleMutating(mux, "/api/refresh/jobs", a.handleRefreshJobs)
+	a.handle(mux, "/api/refresh/{job_id}/diff", a.handleRefreshDiff)
+	a.handleAdmin(mux, "/api/refresh/{job_id}/dry-run", a.handleDryRunResult)
+	a.handle(mux, "/api/refresh/{job_id}/query-stats", a.handleSearchQueryStats)
+	a.handle(mux, "/api/history", a.handleHistory)
+	a.handle(mux, "/api/batch", a.handleBatch)
+	a.handleAdmin(mux, "/api/admin/diagnostics", a.handleDiagnostics)
+	a.handleAdmin(mux, "/api/admin/coverage-drift", a.handleCoverageDrift)
+	a.handleAdmin(mux, "/api/admin/ecosystems", a.handleEcosystemMapping)
+	a.handleAdmin(mux, "/api/admin/exclusions", a.handleExclusions)
+	a.handleAdmin(mux, "/api/admin/exclusions/{owner}/{repo}", a.handleExclusion)
+	a.handleMutating(mux, "/api/search-queries", a.handleSearchQueries)
+	a.handleAdmin(mux, "/api/search-queries/{id}", a.handleSearchQuery)
+	a.handleAdmin(mux, "/api/keys", a.handleKeys)
+	a.handleAdmin(mux, "/api/keys/{id}", a.handleKey)
+	// Unknown API paths would otherwise reach the static file server's text 404
+	a.handle(mux, "/api/", a.handleNotFound)
+}
+
+// handleNotFound answers requests for API paths that don't exist
+func (a *API) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	a.apiError(w, http.StatusNotFound, "Not found", nil)
+}
+
+// handle registers a route wrapped in the API middleware, outermost first
+func (a *API) handle(mux *http.ServeMux, pattern string, fn http.HandlerFunc) {
+	mux.Handle(pattern, metricsMiddleware(pattern, a.corsMiddleware(a.tierMiddleware(a.gzipMiddleware(fn)))))
+}
+
+// handleMutating registers a route whose non-GET methods require an admin key
+func (a *API) handleMutating(mux *http.ServeMux, pattern string, fn http.HandlerFunc) {
+	mux.Handle(pattern, metricsMiddleware(pattern, a.corsMiddleware(a.tierMiddleware(a.gzipMiddleware(a.authMiddleware(fn))))))
+}
+
+// handleAdmin registers a route whose every method requires an admin key
+func (a *API) handleAdmin(mux *http.ServeMux, pattern string, fn http.HandlerFunc) {
+	mux.Handle(pattern, metricsMiddleware(pattern, a.corsMiddleware(a.tierMiddleware(a.gzipMiddleware(a.adminMiddleware(fn))))))
 }
 
-// handleProjects returns list of projects with filtering/sorting
+// handleHealth reports whether the server and its dependencies are usable.
+// It responds 503 when the database is unreachable or no GitHub token is set.
+func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	a.refreshMu.Lock()
+	isRunning := a.refreshRunning
+	a.refreshMu.Unlock()
+
+	status := http.StatusOK
+	resp := map[string]interface{}{
+		"status":          "ok",
+		"db":              "ok",
+		"github_token":    "configured",
+		"refresh_running": isRunning,
+	}
+	if !a.hasToken {
+		status = http.StatusServiceUnavailable
+		resp["status"] = "degraded"
+		resp["github_token"] = "missing"
+	}
+	if err := a.db.Ping(); err != nil {
+		log.Printf("Health check: database unreachable: %v", err)
+		status = http.StatusServiceUnavailable
+		resp["status"] = "degraded"
+		resp["db"] = "error"
+		resp["error"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleHealthz is a liveness/readiness probe: 200 when the database answers a
+// ping, 503 otherwise. Unlike /api/health it ignores the GitHub token and doesn't
+// log, so it can be polled every few seconds.
+func (a *API) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	status := http.StatusOK
+	resp := map[string]string{"status": "ok"}
+	if err := a.db.Ping(); err != nil {
+		status = http.StatusServiceUnavailable
+		resp = map[string]string{"status": "error", "error": err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleProjects returns list of projects with filtering/sorting, or with
+// format=csv or format=ndjson the streamed export. An Accept header of
+// application/x-ndjson also asks for NDJSON. POST adds a submitted project.
 func (a *API) handleProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		a.handleSubmitProject(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); {
+	case format == "csv":
+		a.handleProjectsCSV(w, r)
+		return
+	case format == "ndjson", format == "" && strings.Contains(r.Header.Get("Accept"), "application/x-ndjson"):
+		a.handleProjectsNDJSON(w, r)
 		return
 	}
+	a.serveOp(w, r, a.opProjects)
+}
 
-	q := r.URL.Query()
+// opProjects lists projects matching the filter described by the query parameters
+func (a *API) opProjects(q url.Values) (interface{}, int, error) {
+	filter := parseProjectFilter(q)
+	if err := filter.Validate(); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	projects, err := a.listProjects(filter)
+	if err != nil {
+		log.Printf("Error listing projects: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	a.setEcosystems(projects)
+	return projects, http.StatusOK, nil
+}
 
+// parseProjectFilter builds a project filter from /api/projects query parameters
+func parseProjectFilter(q url.Values) db.ProjectFilter {
 	filter := db.ProjectFilter{
 		Search:     q.Get("search"),
 		SourceType: q.Get("source_type"),
+		Language:   q.Get("language"),
+		Registry:   q.Get("registry"),
+		Grade:      q.Get("health_grade"),
+		Topic:      q.Get("topic"),
 		SortBy:     q.Get("sort"),
 		SortOrder:  q.Get("order"),
+
+		IncludeDeleted: q.Get("include_deleted") == "true",
+	}
+	switch q.Get("active") {
+	case "false":
+		filter.Active = db.InactiveOnly
+	case "all":
+		filter.Active = db.AnyActivity
 	}
 
 	if minStars := q.Get("min_stars"); minStars != "" {
 		if v, err := strconv.Atoi(minStars); err == nil {
-			filter.MinStars = v
+			filter.MinStars = &v
 		}
 	}
 	if maxStars := q.Get("max_stars"); maxStars != "" {
 		if v, err := strconv.Atoi(maxStars); err == nil {
-			filter.MaxStars = v
+			filter.MaxStars = &v
 		}
 	}
 	if limit := q.Get("limit"); limit != "" {
@@ -82,48 +341,258 @@ func (a *API) handleProjects(w http.ResponseWriter, r *http.Request) {
 			filter.Offset = v
 		}
 	}
+	return filter
+}
+
+// handleProjectsExport serves /api/projects/export, where format is csv, the
+// default, or ndjson
+func (a *API) handleProjectsExport(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("format") {
+	case "", "csv":
+		a.handleProjectsCSV(w, r)
+	case "ndjson":
+		a.handleProjectsNDJSON(w, r)
+	default:
+		a.apiError(w, http.StatusBadRequest, "Invalid 'format' parameter. Use 'csv' or 'ndjson'", nil)
+	}
+}
+
+// ndjsonFlushRows is how many NDJSON rows are written between flushes
+const ndjsonFlushRows = 100
+
+// handleProjectsNDJSON streams projects matching the /api/projects filters as
+// newline-delimited JSON, one project per line, row by row from the database
+// cursor. It stops reading once the client goes away.
+func (a *API) handleProjectsNDJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	filter := parseProjectFilter(r.URL.Query())
+	if err := filter.Validate(); err != nil {
+		a.apiError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
 
-	projects, err := a.db.ListProjects(filter)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	ctx := r.Context()
+	tier := requestCaller(r).tier
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	exported := 0
+	err := a.db.EachProject(filter, func(p *db.Project) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.Ecosystem = a.ecosystems.Of(p.PrimaryLanguage)
+		if err := enc.Encode(visibleTo(tier, p)); err != nil {
+			return err
+		}
+		exported++
+		if exported%ndjsonFlushRows == 0 && flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error listing projects: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		// Headers are already sent, so all we can do is log
+		log.Printf("Stopped exporting projects NDJSON after %d projects: %v", exported, err)
 		return
 	}
+	log.Printf("Exported %d projects as NDJSON for %s", exported, requestCaller(r).name)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projects)
+// handleProjectsCSV streams projects matching the /api/projects filters as CSV,
+// row by row from the database cursor
+func (a *API) handleProjectsCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	filter := parseProjectFilter(r.URL.Query())
+	if err := filter.Validate(); err != nil {
+		a.apiError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dhi-projects-%s.csv"`, time.Now().UTC().Format("2006-01-02")))
+
+	exported := 0
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"repo_full_name", "github_url", "stars", "primary_language", "source_type", "registry", "first_seen_at", "description"})
+	err := a.db.EachProject(filter, func(p *db.Project) error {
+		exported++
+		return cw.Write([]string{
+			p.RepoFullName,
+			p.GitHubURL,
+			strconv.Itoa(p.Stars),
+			p.PrimaryLanguage,
+			p.SourceType,
+			p.Registry,
+			p.FirstSeenAt.UTC().Format(time.RFC3339),
+			p.Description,
+		})
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		// Headers are already sent, so all we can do is log
+		log.Printf("Error exporting projects CSV: %v", err)
+		return
+	}
+	log.Printf("Exported %d projects as CSV for %s", exported, requestCaller(r).name)
 }
 
-// handleSourceTypes returns list of distinct source types
+// handleSourceTypes returns distinct source types with project counts.
+// ?counts=false returns the plain list of names instead.
 func (a *API) handleSourceTypes(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
+	a.serveOp(w, r, a.opSourceTypes)
+}
+
+func (a *API) opSourceTypes(q url.Values) (interface{}, int, error) {
+	if q.Get("counts") == "false" {
+		types, err := a.db.GetSourceTypes()
+		if err != nil {
+			log.Printf("Error getting source types: %v", err)
+			return nil, http.StatusInternalServerError, errInternal
+		}
+		return types, http.StatusOK, nil
+	}
 
-	types, err := a.db.GetSourceTypes()
+	counts, err := a.db.GetSourceTypeCounts()
 	if err != nil {
-		log.Printf("Error getting source types: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		log.Printf("Error getting source type counts: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	return counts, http.StatusOK, nil
+}
+
+// handleLanguages returns project counts per primary language
+func (a *API) handleLanguages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
+	a.serveOp(w, r, a.opLanguages)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(types)
+func (a *API) opLanguages(q url.Values) (interface{}, int, error) {
+	counts, err := a.db.GetLanguageCounts()
+	if err != nil {
+		log.Printf("Error getting language counts: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	return counts, http.StatusOK, nil
+}
+
+// handleLanguageSummary returns project count and star aggregates per primary language
+func (a *API) handleLanguageSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opLanguageSummary)
+}
+
+func (a *API) opLanguageSummary(q url.Values) (interface{}, int, error) {
+	summary, err := a.db.GetLanguageSummary()
+	if err != nil {
+		log.Printf("Error getting language summary: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	return summary, http.StatusOK, nil
+}
+
+// handleStatsLanguages returns the project count and stars per primary language
+// among active projects
+func (a *API) handleStatsLanguages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opStatsLanguages)
+}
+
+func (a *API) opStatsLanguages(q url.Values) (interface{}, int, error) {
+	breakdown, err := a.db.GetLanguageBreakdown()
+	if err != nil {
+		log.Printf("Error getting language breakdown: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	return breakdown, http.StatusOK, nil
+}
+
+// handleTopics returns project counts per GitHub topic
+func (a *API) handleTopics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opTopics)
+}
+
+func (a *API) opTopics(q url.Values) (interface{}, int, error) {
+	counts, err := a.db.GetTopicCounts()
+	if err != nil {
+		log.Printf("Error getting topic counts: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	if counts == nil {
+		counts = []db.TopicCount{}
+	}
+	return counts, http.StatusOK, nil
+}
+
+// handleImages returns how many projects use each registry image
+func (a *API) handleImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opImages)
+}
+
+func (a *API) opImages(q url.Values) (interface{}, int, error) {
+	counts, err := a.db.GetImageCounts()
+	if err != nil {
+		log.Printf("Error getting image counts: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	if counts == nil {
+		counts = []db.ImageCount{}
+	}
+	return counts, http.StatusOK, nil
 }
 
 // handleStats returns summary statistics
 func (a *API) handleStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
+	a.serveOp(w, r, a.opStats)
+}
 
-	total, totalStars, popular, notable, err := a.db.GetStats()
+func (a *API) opStats(q url.Values) (interface{}, int, error) {
+	by, err := parseBreakdown(q, "ecosystem")
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	totals, err := a.getStats()
 	if err != nil {
 		log.Printf("Error getting stats: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return nil, http.StatusInternalServerError, errInternal
 	}
 
 	// Get count of new projects this week (current calendar week, Monday-Sunday)
@@ -134,195 +603,183 @@ func (a *API) handleStats(w http.ResponseWriter, r *http.Request) {
 		newThisWeek = 0 // Don't fail the whole request
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{
-		"total_projects":  total,
-		"total_stars":     totalStars,
-		"popular_count":   popular,
-		"notable_count":   notable,
-		"new_this_week":   newThisWeek,
-	})
+	stats := map[string]interface{}{
+		"total_projects":    totals.total,
+		"total_stars":       totals.stars,
+		"popular_count":     totals.popular,
+		"notable_count":     totals.notable,
+		"popular_min_stars": a.starThresholds.Popular,
+		"notable_min_stars": a.starThresholds.Notable,
+		"new_this_week":     newThisWeek,
+	}
+
+	grades, err := a.db.GetHealthGradeCounts()
+	if err != nil {
+		log.Printf("Error getting health grade counts: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	stats["health_grades"] = health.Distribution(grades)
+
+	if by == "ecosystem" {
+		counts, err := a.db.GetLanguageCounts()
+		if err != nil {
+			log.Printf("Error getting language counts: %v", err)
+			return nil, http.StatusInternalServerError, errInternal
+		}
+		stats["by_ecosystem"] = a.groupLanguageCounts(counts)
+	}
+	return stats, http.StatusOK, nil
 }
 
-// handleRefresh triggers an async refresh
+// handleRefresh triggers an async refresh, or with dry_run=true one that only
+// reports what it would save. It responds 202 with the job ID once the refresh is
+// started, 409 with the running job's ID and started_at while another refresh
+// runs, and 429 when the previous job is too recent.
 func (a *API) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	// Check if refresh is already running
-	a.refreshMu.Lock()
-	if a.refreshRunning {
-		a.refreshMu.Unlock()
+	// Proxies may replay a POST after a 502, so quick repeats are refused unless forced
+	minInterval := minRefreshInterval
+	if r.URL.Query().Get("force") == "true" {
+		minInterval = 0
+	}
+
+	source, message := "manual", "Refresh started"
+	if r.URL.Query().Get("dry_run") == "true" {
+		source, message = db.RefreshSourceDryRun, "Dry run started"
+	}
+
+	jobID, err := a.startRefresh(source, minInterval)
+	var tooSoon *refreshTooSoonError
+	switch {
+	case errors.Is(err, errRefreshRunning):
+		a.writeRefreshRunning(w)
+		return
+	case errors.As(err, &tooSoon):
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(tooSoon.retryAfter.Seconds()+0.5)))
+		w.WriteHeader(http.StatusTooManyRequests)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
-			"message": "Refresh already in progress",
+			"message": tooSoon.Error(),
 		})
 		return
-	}
-	a.refreshRunning = true
-	a.refreshMu.Unlock()
-
-	// Create job record
-	jobID, err := a.db.CreateRefreshJob()
-	if err != nil {
-		log.Printf("Error creating refresh job: %v", err)
-		a.refreshMu.Lock()
-		a.refreshRunning = false
-		a.refreshMu.Unlock()
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	case err != nil:
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 
-	// Start async refresh
-	go a.runRefresh(jobID, "manual")
-
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"job_id":  jobID,
-		"message": "Refresh started",
+		"message": message,
 	})
 }
 
-func (a *API) runRefresh(jobID int64, source string) {
-	defer func() {
-		a.refreshMu.Lock()
-		a.refreshRunning = false
-		a.refreshMu.Unlock()
-	}()
-
-	log.Printf("Starting refresh job %d (source: %s)", jobID, source)
-
-	if err := a.db.StartRefreshJob(jobID); err != nil {
-		log.Printf("Error starting job: %v", err)
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-
-	projects, err := a.ghClient.FetchAllProjects(ctx, nil)
-	if err != nil {
-		log.Printf("Error fetching projects: %v", err)
-		a.db.FailRefreshJob(jobID, err.Error())
-		return
+// writeRefreshRunning answers a refresh trigger that lost to a running refresh with
+// 409 and the running job's ID and start time. Both are left out while the
+// running refresh hasn't created its job row yet.
+func (a *API) writeRefreshRunning(w http.ResponseWriter) {
+	body := map[string]interface{}{
+		"success": false,
+		"message": "Refresh already in progress",
 	}
-
-	// Upsert all projects
-	for _, p := range projects {
-		dbProject := &db.Project{
-			RepoFullName:    p.RepoFullName,
-			GitHubURL:       p.GitHubURL,
-			Stars:           p.Stars,
-			Description:     p.Description,
-			PrimaryLanguage: p.PrimaryLanguage,
-			DockerfilePath:  p.DockerfilePath,
-			FileURL:         p.FileURL,
-			SourceType:      p.SourceType,
-		}
-		if err := a.db.UpsertProject(dbProject); err != nil {
-			log.Printf("Error upserting project %s: %v", p.RepoFullName, err)
+	a.refreshMu.Lock()
+	jobID := a.refreshJobID
+	a.refreshMu.Unlock()
+	if jobID != 0 {
+		job, err := a.db.GetRefreshJob(jobID)
+		if err != nil {
+			log.Printf("Error getting running refresh job %d: %v", jobID, err)
+		} else if job != nil {
+			body["job_id"] = job.ID
+			body["started_at"] = job.StartedAt
 		}
 	}
 
-	if err := a.db.CompleteRefreshJob(jobID, len(projects)); err != nil {
-		log.Printf("Error completing job: %v", err)
-	}
-
-	// Fetch adoption dates for projects that don't have them
-	a.fetchAdoptionDates(ctx)
-
-	// Record snapshot for historical tracking
-	if err := a.db.RecordSnapshot(); err != nil {
-		log.Printf("Error recording snapshot: %v", err)
-	} else {
-		log.Printf("Recorded snapshot after refresh")
-	}
-
-	log.Printf("Refresh job %d completed (source: %s): %d projects", jobID, source, len(projects))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(body)
 }
 
-// fetchAdoptionDates fetches adoption dates for projects that don't have them
-func (a *API) fetchAdoptionDates(ctx context.Context) {
-	projects, err := a.db.GetProjectsWithoutAdoptionDate()
-	if err != nil {
-		log.Printf("Error getting projects without adoption date: %v", err)
+// handleRefreshCancel stops the running refresh and marks its job cancelled with the
+// error "cancelled by user". Like handleRefresh, it answers a conflict with
+// success false rather than an error body, with status 409.
+func (a *API) handleRefreshCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	if len(projects) == 0 {
-		log.Printf("All projects have adoption dates")
+	a.refreshMu.Lock()
+	if !a.refreshRunning || a.refreshCancel == nil {
+		a.refreshMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "no refresh running",
+		})
 		return
 	}
+	// The run releases refreshRunning itself once it has unwound
+	jobID, cancel := a.refreshJobID, a.refreshCancel
+	a.refreshCancel = nil
+	a.refreshMu.Unlock()
 
-	log.Printf("Fetching adoption dates for %d projects...", len(projects))
-
-	for i, p := range projects {
-		select {
-		case <-ctx.Done():
-			log.Printf("Context cancelled, stopping adoption date fetch")
-			return
-		default:
-		}
-
-		log.Printf("Fetching adoption info for %s (%d/%d)", p.RepoFullName, i+1, len(projects))
-
-		adoptionInfo, err := a.ghClient.GetFileFirstCommit(ctx, p.RepoFullName, p.DockerfilePath)
-		if err != nil {
-			log.Printf("Error getting adoption info for %s: %v", p.RepoFullName, err)
-			// If rate limited, wait and retry
-			if strings.Contains(err.Error(), "rate limited") {
-				log.Printf("Rate limited, waiting 60s...")
-				time.Sleep(60 * time.Second)
-				adoptionInfo, err = a.ghClient.GetFileFirstCommit(ctx, p.RepoFullName, p.DockerfilePath)
-				if err != nil {
-					log.Printf("Retry failed for %s: %v", p.RepoFullName, err)
-					continue
-				}
-			} else {
-				continue
-			}
-		}
-
-		if err := a.db.UpdateProjectAdoption(p.ID, adoptionInfo.Date, adoptionInfo.CommitURL); err != nil {
-			log.Printf("Error updating adoption info for %s: %v", p.RepoFullName, err)
-		} else {
-			log.Printf("Set adoption for %s: %s (%s)", p.RepoFullName, adoptionInfo.Date.Format("2006-01-02"), adoptionInfo.CommitURL)
-		}
-
-		// Rate limit: commits API is part of the 5000/hr limit
-		time.Sleep(500 * time.Millisecond)
+	// Mark the job before stopping it, so the run's error can't record it as failed
+	err := a.db.CancelRefreshJob(jobID, cancelledByUser)
+	cancel()
+	if err != nil {
+		log.Printf("Error cancelling refresh job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
 	}
+	log.Printf("Refresh job %d cancelled", jobID)
 
-	log.Printf("Finished fetching adoption dates")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"job_id":  jobID,
+		"message": "Refresh cancelled",
+	})
 }
 
 // TriggerRefresh starts a refresh if one isn't already running.
-// Returns true if a refresh was started, false if one was already running.
-// This is used by the scheduler for automated refreshes.
+// Returns true if a refresh was started, false if one was already running or
+// its job couldn't be created. This is used by the scheduler for automated refreshes.
 func (a *API) TriggerRefresh(source string) bool {
-	a.refreshMu.Lock()
-	if a.refreshRunning {
-		a.refreshMu.Unlock()
-		log.Printf("Skipping %s refresh: already running", source)
+	if _, err := a.startRefresh(source, 0); err != nil {
+		if errors.Is(err, errRefreshRunning) {
+			log.Printf("Skipping %s refresh: already running", source)
+		}
 		return false
 	}
-	a.refreshRunning = true
-	a.refreshMu.Unlock()
+	return true
+}
 
-	jobID, err := a.db.CreateRefreshJob()
+// RecordDailySnapshot records a snapshot unless one was already taken today, so the
+// history has a point per day even when refreshes are infrequent or failing.
+// It's meant to be driven by a ticker; it returns true if a snapshot was recorded.
+func (a *API) RecordDailySnapshot(now time.Time) bool {
+	exists, err := a.db.SnapshotExistsForDay(now)
 	if err != nil {
-		log.Printf("Error creating refresh job for %s refresh: %v", source, err)
-		a.refreshMu.Lock()
-		a.refreshRunning = false
-		a.refreshMu.Unlock()
+		log.Printf("Error checking for today's snapshot: %v", err)
 		return false
 	}
-
-	go a.runRefresh(jobID, source)
+	if exists {
+		return false
+	}
+	if err := a.db.RecordSnapshot(a.starThresholds); err != nil {
+		log.Printf("Error recording daily snapshot: %v", err)
+		return false
+	}
+	log.Printf("Recorded daily snapshot for %s", now.UTC().Format("2006-01-02"))
 	return true
 }
 
@@ -336,42 +793,75 @@ func (a *API) GetLastRefreshTime() *time.Time {
 	return job.CompletedAt
 }
 
+// historyDeltaSnapshots is how many snapshots /api/history?deltas=true returns
+const historyDeltaSnapshots = 30
+
 // handleHistory returns adoption history by date
 func (a *API) handleHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
+	a.serveOp(w, r, a.opHistory)
+}
 
+func (a *API) opHistory(q url.Values) (interface{}, int, error) {
 	days := 14 // default to 2 weeks
-	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+	if daysStr := q.Get("days"); daysStr != "" {
 		if v, err := strconv.Atoi(daysStr); err == nil && v > 0 {
 			days = v
 		}
 	}
 
+	by, err := parseBreakdown(q, "ecosystem")
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
 	adoptions, err := a.db.GetAdoptionByDate(days)
 	if err != nil {
 		log.Printf("Error getting adoption history: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return nil, http.StatusInternalServerError, errInternal
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	history := map[string]interface{}{
 		"adoptions": adoptions,
-	})
+	}
+	if by == "ecosystem" {
+		byEcosystem, err := a.adoptionByEcosystem(days)
+		if err != nil {
+			log.Printf("Error getting adoption history by ecosystem: %v", err)
+			return nil, http.StatusInternalServerError, errInternal
+		}
+		history["by_ecosystem"] = byEcosystem
+	}
+	if q.Get("deltas") == "true" {
+		deltas, err := a.db.GetSnapshotDeltas(historyDeltaSnapshots)
+		if err != nil {
+			log.Printf("Error getting snapshot deltas: %v", err)
+			return nil, http.StatusInternalServerError, errInternal
+		}
+		if deltas == nil {
+			deltas = []db.SnapshotDelta{}
+		}
+		history["snapshot_deltas"] = deltas
+	}
+	return history, http.StatusOK, nil
 }
 
-// handleNewProjects returns projects adopted within a time period
+// handleNewProjects returns projects adopted within a time period, optionally
+// only those with min_stars or more
 func (a *API) handleNewProjects(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
+	a.serveOp(w, r, a.opNewProjects)
+}
 
+func (a *API) opNewProjects(q url.Values) (interface{}, int, error) {
 	// Parse 'since' parameter (e.g., "7d", "30d", "1w", "thisweek")
-	sinceStr := r.URL.Query().Get("since")
+	sinceStr := q.Get("since")
 	if sinceStr == "" {
 		sinceStr = "thisweek" // default to current calendar week
 	}
@@ -382,20 +872,68 @@ func (a *API) handleNewProjects(w http.ResponseWriter, r *http.Request) {
 	} else {
 		duration, err := parseDuration(sinceStr)
 		if err != nil {
-			http.Error(w, "Invalid 'since' parameter. Use 'thisweek', '7d', '1w', '30d'", http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'since' parameter. Use 'thisweek', '7d', '1w', '30d'")
 		}
 		since = time.Now().Add(-duration)
 	}
-	projects, err := a.db.GetNewProjectsSince(since)
+	minStars := 0
+	if v := q.Get("min_stars"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'min_stars' parameter. Use a non-negative number")
+		}
+		minStars = n
+	}
+
+	projects, err := a.db.GetNewProjectsSince(since, minStars)
 	if err != nil {
 		log.Printf("Error getting new projects: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	a.setEcosystems(projects)
+	return newProjects{Since: since, Count: len(projects), Projects: projects}, http.StatusOK, nil
+}
+
+// newProjects is the /api/projects/new response
+type newProjects struct {
+	Since    time.Time    `json:"since"`
+	Count    int          `json:"count"`
+	Projects []db.Project `json:"projects"`
+}
+
+// handleTrendingProjects returns the projects that gained the most stars for
+// their size within a time period
+func (a *API) handleTrendingProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
+	a.serveOp(w, r, a.opTrendingProjects)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projects)
+func (a *API) opTrendingProjects(q url.Values) (interface{}, int, error) {
+	period := 7 * 24 * time.Hour
+	if v := q.Get("since"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'since' parameter. Use '7d', '1w', '30d'")
+		}
+		period = d
+	}
+	limit := 20
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+
+	projects, err := a.db.GetTrendingProjects(limit, time.Now().Add(-period))
+	if err != nil {
+		log.Printf("Error getting trending projects: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	for i := range projects {
+		projects[i].Ecosystem = a.ecosystems.Of(projects[i].PrimaryLanguage)
+	}
+	return projects, http.StatusOK, nil
 }
 
 // parseDuration parses a duration string like "7d", "1w", "30d"
@@ -436,10 +974,469 @@ func parseDuration(s string) (time.Duration, error) {
 	}
 }
 
+// handleRefreshHistory returns past refresh jobs, most recent first
+func (a *API) handleRefreshHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opRefreshHistory)
+}
+
+func (a *API) opRefreshHistory(q url.Values) (interface{}, int, error) {
+	limit, offset := jobPage(q)
+	jobs, _, err := a.db.ListRefreshJobs(limit, offset)
+	if err != nil {
+		log.Printf("Error listing refresh jobs: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	return jobs, http.StatusOK, nil
+}
+
+// jobPage returns the limit (default 20, at most 100) and offset of a page of refresh jobs
+func jobPage(q url.Values) (limit, offset int) {
+	limit = 20
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+	return limit, offset
+}
+
+// handleRefreshJobs lists refresh jobs a page at a time with the total count (GET),
+// optionally only those with ?status=, or deletes finished jobs created before ?before=<RFC3339> with their diffs (DELETE)
+func (a *API) handleRefreshJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.serveOp(w, r, a.opRefreshJobs)
+	case http.MethodDelete:
+		before, err := time.Parse(time.RFC3339, r.URL.Query().Get("before"))
+		if err != nil {
+			a.apiError(w, http.StatusBadRequest, "before must be an RFC3339 timestamp", err)
+			return
+		}
+		n, err := a.db.DeleteRefreshJobsBefore(before)
+		if err != nil {
+			log.Printf("Error deleting refresh jobs before %s: %v", before.Format(time.RFC3339), err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		log.Printf("Deleted %d refresh jobs created before %s", n, before.Format(time.RFC3339))
+		a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"deleted": n,
+		})
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+func (a *API) opRefreshJobs(q url.Values) (interface{}, int, error) {
+	limit, offset := jobPage(q)
+	status := q.Get("status")
+	if status != "" && !slices.Contains(db.RefreshJobStatuses, status) {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'status' parameter. Use '%s'", strings.Join(db.RefreshJobStatuses, "', '"))
+	}
+	jobs, total, err := a.db.ListRefreshJobsByStatus(status, limit, offset)
+	if err != nil {
+		log.Printf("Error listing refresh jobs: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	if jobs == nil {
+		jobs = []db.RefreshJob{}
+	}
+	return map[string]interface{}{
+		"jobs":   jobs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	}, http.StatusOK, nil
+}
+
+// handleRefreshDiff returns the repos added and removed by a refresh job
+func (a *API) handleRefreshDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	jobID, err := strconv.ParseInt(r.PathValue("job_id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	job, err := a.db.GetRefreshJob(jobID)
+	if err != nil {
+		log.Printf("Error getting refresh job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if job == nil {
+		a.apiError(w, http.StatusNotFound, "Refresh job not found", nil)
+		return
+	}
+
+	diff, err := a.db.GetRefreshDiff(jobID)
+	if err != nil {
+		log.Printf("Error getting refresh diff for job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// handleSearchQueryStats returns how each search query fared in a refresh job:
+// the repos it matched and first found, the pages it took and how long
+func (a *API) handleSearchQueryStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	jobID, err := strconv.ParseInt(r.PathValue("job_id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	job, err := a.db.GetRefreshJob(jobID)
+	if err != nil {
+		log.Printf("Error getting refresh job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if job == nil {
+		a.apiError(w, http.StatusNotFound, "Refresh job not found", nil)
+		return
+	}
+
+	stats, err := a.db.GetSearchQueryStats(jobID)
+	if err != nil {
+		log.Printf("Error getting search query stats for job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"job_id":  jobID,
+		"queries": stats,
+	})
+}
+
+// handleDryRunResult returns what a dry-run job would have saved: the projects
+// found, graded, and the repos it would add, remove and soft-delete. Like the
+// trigger it's for admins, since the projects aren't published. It responds 404
+// for jobs that aren't dry runs and 202 with the job while the run is going.
+func (a *API) handleDryRunResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	jobID, err := strconv.ParseInt(r.PathValue("job_id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	job, err := a.db.GetRefreshJob(jobID)
+	if err != nil {
+		log.Printf("Error getting refresh job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if job == nil || job.Source != db.RefreshSourceDryRun {
+		a.apiError(w, http.StatusNotFound, "Dry run job not found", nil)
+		return
+	}
+
+	result, err := a.db.GetRefreshJobDryRunResult(jobID)
+	if err != nil {
+		log.Printf("Error getting dry run result for job %d: %v", jobID, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result == nil {
+		// Still running, or it failed or was cancelled before finding anything
+		if job.Status == "pending" || job.Status == "running" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"job": job})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":    job,
+		"result": json.RawMessage(result),
+	})
+}
+
+// projectDetail is a project with what the list leaves out: the search queries
+// that found it and the last refresh job that did
+type projectDetail struct {
+	db.Project
+	LastSeenJobID *int64          `json:"last_seen_job_id"` // null until a refresh records its query matches
+	QueryMatches  []db.QueryMatch `json:"query_matches"`
+}
+
+// handleProject returns one project by its full repo name, or removes it on DELETE
+func (a *API) handleProject(w http.ResponseWriter, r *http.Request) {
+	repo := r.PathValue("owner") + "/" + r.PathValue("repo")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		a.deleteProject(w, r, repo)
+		return
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	project, err := a.db.GetProjectByRepoName(repo)
+	if err != nil {
+		log.Printf("Error getting project %s: %v", repo, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if project == nil {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+	matches, err := a.db.GetQueryMatches(repo)
+	if err != nil {
+		log.Printf("Error getting query matches for %s: %v", repo, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+
+	detail := projectDetail{Project: *project, QueryMatches: matches}
+	detail.Ecosystem = a.ecosystems.Of(project.PrimaryLanguage)
+	if len(matches) > 0 {
+		detail.LastSeenJobID = &matches[0].LastJobID
+	}
+	a.writeJSON(w, r, http.StatusOK, detail)
+}
+
+// deleteProject removes a false positive and keeps refreshes from adding it back
+func (a *API) deleteProject(w http.ResponseWriter, r *http.Request, repo string) {
+	deleted, err := a.db.DeleteProject(repo)
+	if err != nil {
+		log.Printf("Error deleting project %s: %v", repo, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !deleted {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+	a.cache.purge()
+	log.Printf("Deleted and suppressed project %s for %s", repo, requestCaller(r).name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleProjectByID corrects a project's metadata on PATCH, or removes it on DELETE
+func (a *API) handleProjectByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid project id", err)
+		return
+	}
+	switch r.Method {
+	case http.MethodPatch:
+		a.handlePatchProject(w, r, id)
+	case http.MethodDelete:
+		a.handleDeleteProject(w, r, id)
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// projectPatch is the body of PATCH /api/projects/{id}. Fields left out are
+// unchanged; unknown fields are ignored.
+type projectPatch struct {
+	Description     *string `json:"description"`
+	PrimaryLanguage *string `json:"primary_language"`
+	Stars           *int    `json:"stars"`
+	SourceType      *string `json:"source_type"`
+
+	// Identity fields, only decoded to reject them
+	RepoFullName *string `json:"repo_full_name"`
+	GitHubURL    *string `json:"github_url"`
+}
+
+// handlePatchProject corrects scraped metadata of a tracked project. Refreshes
+// overwrite the fields again for projects the searches find.
+func (a *API) handlePatchProject(w http.ResponseWriter, r *http.Request, id int64) {
+	var req projectPatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if req.RepoFullName != nil || req.GitHubURL != nil {
+		a.apiError(w, http.StatusBadRequest, "repo_full_name and github_url can't be changed", nil)
+		return
+	}
+	if req.Description == nil && req.PrimaryLanguage == nil && req.Stars == nil && req.SourceType == nil {
+		a.apiError(w, http.StatusBadRequest, "Nothing to change: set description, primary_language, stars or source_type", nil)
+		return
+	}
+	if req.Stars != nil && *req.Stars < 0 {
+		a.apiError(w, http.StatusBadRequest, "stars must not be negative", nil)
+		return
+	}
+	if req.SourceType != nil && strings.TrimSpace(*req.SourceType) == "" {
+		a.apiError(w, http.StatusBadRequest, "source_type must not be empty", nil)
+		return
+	}
+
+	found, err := a.db.PatchProject(id, db.ProjectPatch{
+		Description:     req.Description,
+		PrimaryLanguage: req.PrimaryLanguage,
+		Stars:           req.Stars,
+		SourceType:      req.SourceType,
+	})
+	if err != nil {
+		log.Printf("Error patching project %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !found {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+	a.cache.purge()
+	log.Printf("Patched project %d for %s", id, requestCaller(r).name)
+
+	p, err := a.db.GetProjectByID(id)
+	if err != nil || p == nil {
+		log.Printf("Error reading back patched project %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	p.Ecosystem = a.ecosystems.Of(p.PrimaryLanguage)
+	a.writeJSON(w, r, http.StatusOK, p)
+}
+
+// handleDeleteProject removes a project by id. By default it is only soft-deleted,
+// so a refresh that finds the repo again restores it; with ?permanent=true it and
+// its history are removed for good, and the repo is excluded so refreshes don't
+// bring it back. Unlike other mutating requests, both need an admin key even
+// while none are configured.
+func (a *API) handleDeleteProject(w http.ResponseWriter, r *http.Request, id int64) {
+	a.adminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("permanent") == "true" {
+			a.hardDeleteProject(w, r, id)
+			return
+		}
+		repo, err := a.db.GetProjectRepoName(id)
+		if err != nil {
+			log.Printf("Error looking up project %d: %v", id, err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		if repo == "" {
+			a.apiError(w, http.StatusNotFound, "Project not found", nil)
+			return
+		}
+		if err := a.db.SoftDeleteProject(id); err != nil {
+			log.Printf("Error deleting project %d: %v", id, err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		a.cache.purge()
+		log.Printf("Soft-deleted project %s for %s", repo, requestCaller(r).name)
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r)
+}
+
+// hardDeleteProject permanently deletes a project for DELETE /api/projects/{id}?permanent=true
+func (a *API) hardDeleteProject(w http.ResponseWriter, r *http.Request, id int64) {
+	deleted, err := a.db.HardDeleteProject(id)
+	if errors.Is(err, db.ErrProjectReferenced) {
+		a.apiError(w, http.StatusConflict, "Project is referenced by refresh diffs", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Error permanently deleting project %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !deleted {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+	a.cache.purge()
+	log.Printf("Permanently deleted project %d for %s", id, requestCaller(r).name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFileChanges returns the recorded changes to a popular project's matched
+// file, newest first. Diffs and paths are only shown to partners.
+func (a *API) handleFileChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	repo := r.PathValue("owner") + "/" + r.PathValue("repo")
+	changes, err := a.db.GetFileChanges(repo)
+	if err != nil {
+		log.Printf("Error getting file changes for %s: %v", repo, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if changes == nil {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+
+	a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"repo_full_name": repo,
+		"changes":        changes,
+	})
+}
+
+// handleSimilarProjects returns projects with the same language as a project and
+// a similar star count
+func (a *API) handleSimilarProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid project ID", err)
+		return
+	}
+	limit := 5
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 50)
+	}
+
+	projects, err := a.db.GetSimilarProjects(id, limit)
+	if err != nil {
+		log.Printf("Error getting projects similar to %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if projects == nil {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+	a.setEcosystems(projects)
+	a.writeJSON(w, r, http.StatusOK, projects)
+}
+
 // handleRefreshStatus returns the current refresh status
 func (a *API) handleRefreshStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
@@ -450,7 +1447,7 @@ func (a *API) handleRefreshStatus(w http.ResponseWriter, r *http.Request) {
 	job, err := a.db.GetLatestRefreshJob()
 	if err != nil {
 		log.Printf("Error getting refresh status: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 
@@ -472,3 +1469,41 @@ func (a *API) handleRefreshStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// handleDiagnostics streams a support bundle (tar.gz) of config, build info,
+// recent jobs, database stats and logs. ?include_data=true adds project rows.
+func (a *API) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	opts := a.diagOpts
+	opts.IncludeData = r.URL.Query().Get("include_data") == "true"
+
+	filename := fmt.Sprintf("dhi-oss-usage-diagnostics-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := diagnostics.WriteBundle(w, a.db, opts); err != nil {
+		// Headers are already sent, so all we can do is log
+		log.Printf("Error writing diagnostics bundle: %v", err)
+	}
+}
+
+// handleCoverageDrift lists projects whose matching search queries shrank, with per-query totals
+func (a *API) handleCoverageDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	drift, err := a.db.GetCoverageDrift()
+	if err != nil {
+		log.Printf("Error getting coverage drift: %v", err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drift)
+}
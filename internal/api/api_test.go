@@ -0,0 +1,344 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/internal/github/mock"
+)
+
+// newTestAPI returns an API over a fresh database and a mux with its routes registered
+func newTestAPI(t *testing.T, client github.GitHubClient, opts ...Option) (*API, *http.ServeMux) {
+	t.Helper()
+	d, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if client == nil {
+		client = &mock.MockClient{}
+	}
+	a := New(d, client, opts...)
+	mux := http.NewServeMux()
+	a.RegisterRoutes(mux)
+	return a, mux
+}
+
+// do serves a request with an optional body and headers given as name, value pairs
+func do(t *testing.T, h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// addProject stores a project and returns its id
+func addProject(t *testing.T, a *API, p db.Project) int64 {
+	t.Helper()
+	if p.GitHubURL == "" {
+		p.GitHubURL = "https://github.com/" + p.RepoFullName
+	}
+	if p.SourceType == "" {
+		p.SourceType = "Dockerfile"
+	}
+	if err := a.db.UpsertProject(&p); err != nil {
+		t.Fatalf("UpsertProject: %v", err)
+	}
+	id, err := a.db.GetProjectID(p.RepoFullName)
+	if err != nil {
+		t.Fatalf("GetProjectID: %v", err)
+	}
+	return id
+}
+
+func TestHealth(t *testing.T) {
+	health := func(mux http.Handler) (int, map[string]interface{}) {
+		t.Helper()
+		w := do(t, mux, http.MethodGet, "/api/health", "")
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("body %s: %v", w.Body, err)
+		}
+		return w.Code, body
+	}
+
+	a, mux := newTestAPI(t, nil)
+	a.SetTokenConfigured(true)
+	code, body := health(mux)
+	want := map[string]interface{}{"status": "ok", "db": "ok", "github_token": "configured", "refresh_running": false}
+	if code != http.StatusOK || !reflect.DeepEqual(body, want) {
+		t.Errorf("healthy: status %d, body %v", code, body)
+	}
+
+	a.SetTokenConfigured(false)
+	if code, body := health(mux); code != http.StatusServiceUnavailable || body["status"] != "degraded" || body["github_token"] != "missing" {
+		t.Errorf("without token: status %d, body %v", code, body)
+	}
+
+	// A closed database stands in for one that can't be reached
+	a.SetTokenConfigured(true)
+	a.db.Close()
+	code, body = health(mux)
+	if code != http.StatusServiceUnavailable || body["status"] != "degraded" || body["db"] != "error" || body["error"] == "" {
+		t.Errorf("database down: status %d, body %v", code, body)
+	}
+}
+
+func TestPatchProject(t *testing.T) {
+	a, mux := newTestAPI(t, nil)
+	id := addProject(t, a, db.Project{RepoFullName: "acme/app", Description: "old", PrimaryLanguage: "Go", Stars: 10})
+	target := fmt.Sprintf("/api/projects/%d", id)
+
+	tests := []struct {
+		name, body string
+		code       int
+	}{
+		{"partial", `{"description":"new"}`, http.StatusOK},
+		{"unknown fields ignored", `{"stars":12,"homepage":"https://example.com"}`, http.StatusOK},
+		{"repo_full_name", `{"repo_full_name":"acme/other","stars":99}`, http.StatusBadRequest},
+		{"github_url", `{"github_url":"https://github.com/acme/other"}`, http.StatusBadRequest},
+		{"nothing to change", `{"homepage":"https://example.com"}`, http.StatusBadRequest},
+		{"negative stars", `{"stars":-1}`, http.StatusBadRequest},
+		{"empty source_type", `{"source_type":" "}`, http.StatusBadRequest},
+		{"invalid JSON", `{"stars":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := do(t, mux, http.MethodPatch, target, tt.body); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d; body %s", tt.name, w.Code, tt.code, w.Body)
+		}
+	}
+
+	// Only the accepted patches landed, each leaving the other fields alone
+	p, err := a.db.GetProjectByID(id)
+	if err != nil || p == nil {
+		t.Fatalf("GetProjectByID: %+v, %v", p, err)
+	}
+	if p.Description != "new" || p.Stars != 12 || p.PrimaryLanguage != "Go" || p.RepoFullName != "acme/app" || p.SourceType != "Dockerfile" {
+		t.Errorf("project after patches: %+v", p)
+	}
+
+	w := do(t, mux, http.MethodPatch, target, `{"primary_language":"Rust"}`)
+	var got struct {
+		PrimaryLanguage string `json:"primary_language"`
+		Description     string `json:"description"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK || got.PrimaryLanguage != "Rust" || got.Description != "new" {
+		t.Errorf("PATCH response: status %d, body %s", w.Code, w.Body)
+	}
+
+	if w := do(t, mux, http.MethodPatch, "/api/projects/999", `{"stars":1}`); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH unknown project: status %d, want 404", w.Code)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name   string
+		debug  bool
+		code   int
+		msg    string
+		detail error
+		want   string
+	}{
+		{"plain", false, http.StatusNotFound, "Project not found", nil,
+			`{"error":"Project not found","code":"not_found"}`},
+		{"detail hidden", false, http.StatusInternalServerError, "Internal server error", errors.New("disk I/O error"),
+			`{"error":"Internal server error","code":"internal_server_error"}`},
+		{"detail in debug", true, http.StatusInternalServerError, "Internal server error", errors.New("disk I/O error"),
+			`{"error":"Internal server error","code":"internal_server_error","detail":"disk I/O error"}`},
+		{"no detail in debug", true, http.StatusBadRequest, "Invalid project id", nil,
+			`{"error":"Invalid project id","code":"bad_request"}`},
+		{"rate limited", false, http.StatusTooManyRequests, "Rate limit exceeded", nil,
+			`{"error":"Rate limit exceeded","code":"too_many_requests"}`},
+		{"nonstandard status", false, 599, "Upstream failed", nil,
+			`{"error":"Upstream failed","code":"error"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &API{debug: tt.debug}
+			w := httptest.NewRecorder()
+			a.apiError(w, tt.code, tt.msg, tt.detail)
+			if w.Code != tt.code {
+				t.Errorf("status %d, want %d", w.Code, tt.code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", ct)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorResponsesShareShape(t *testing.T) {
+	_, mux := newTestAPI(t, nil)
+	tests := []struct {
+		method, target string
+		code           int
+		errCode        string
+	}{
+		{http.MethodGet, "/api/refresh/abc/diff", http.StatusBadRequest, "bad_request"},
+		{http.MethodGet, "/api/refresh/999/diff", http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/project-notes/abc", http.StatusBadRequest, "bad_request"},
+		{http.MethodPut, "/api/stats", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/api/projects/new?since=yesterday", http.StatusBadRequest, "bad_request"},
+		{http.MethodGet, "/api/keys", http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, tt := range tests {
+		w := do(t, mux, tt.method, tt.target, "")
+		if w.Code != tt.code {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.code)
+			continue
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s: body %q is not an error object: %v", tt.method, tt.target, w.Body, err)
+			continue
+		}
+		if msg, _ := body["error"].(string); len(body) != 2 || msg == "" || body["code"] != tt.errCode {
+			t.Errorf("%s %s: body %s, want {\"error\":...,\"code\":%q}", tt.method, tt.target, w.Body, tt.errCode)
+		}
+	}
+}
+
+func TestSimilarProjects(t *testing.T) {
+	a, mux := newTestAPI(t, nil)
+	id := addProject(t, a, db.Project{RepoFullName: "acme/app", PrimaryLanguage: "Go", Stars: 100})
+	addProject(t, a, db.Project{RepoFullName: "acme/close", PrimaryLanguage: "Go", Stars: 120})
+	addProject(t, a, db.Project{RepoFullName: "acme/far", PrimaryLanguage: "Go", Stars: 1000})
+	addProject(t, a, db.Project{RepoFullName: "acme/similar", PrimaryLanguage: "Rust", Stars: 100})
+
+	w := do(t, mux, http.MethodGet, fmt.Sprintf("/api/similar-projects/%d", id), "")
+	var similar []struct {
+		RepoFullName string `json:"repo_full_name"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &similar); err != nil || len(similar) != 1 || similar[0].RepoFullName != "acme/close" {
+		t.Errorf("similar projects: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, mux, http.MethodGet, "/api/similar-projects/999", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown project: status %d, want 404", w.Code)
+	}
+
+	// A repo whose name matched the old /api/projects/{id}/similar route is reachable
+	w = do(t, mux, http.MethodGet, "/api/projects/acme/similar", "")
+	var p struct {
+		RepoFullName string `json:"repo_full_name"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.RepoFullName != "acme/similar" {
+		t.Errorf("GET /api/projects/acme/similar: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestDeleteProjectByIDRequiresAdminKey(t *testing.T) {
+	// With no key configured, other mutating requests are open; deletes by id are not
+	a, mux := newTestAPI(t, nil)
+	id := addProject(t, a, db.Project{RepoFullName: "acme/app"})
+	for _, target := range []string{"/api/projects/%d", "/api/projects/%d?permanent=true"} {
+		if w := do(t, mux, http.MethodDelete, fmt.Sprintf(target, id), ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("DELETE %s without keys configured: status %d, want 401", target, w.Code)
+		}
+	}
+	var deleted bool
+	if err := a.db.QueryRow(`SELECT is_deleted FROM projects WHERE id = ?`, id).Scan(&deleted); err != nil || deleted {
+		t.Fatalf("project changed by rejected deletes: deleted %v, %v", deleted, err)
+	}
+
+	a, mux = newTestAPI(t, nil, WithAPIKey("admin-key"))
+	soft := addProject(t, a, db.Project{RepoFullName: "acme/soft"})
+	hard := addProject(t, a, db.Project{RepoFullName: "acme/hard"})
+	if w := do(t, mux, http.MethodDelete, fmt.Sprintf("/api/projects/%d", soft), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE without key: status %d, want 401", w.Code)
+	}
+	if w := do(t, mux, http.MethodDelete, fmt.Sprintf("/api/projects/%d", soft), "", "X-API-Key", "admin-key"); w.Code != http.StatusNoContent {
+		t.Errorf("soft DELETE: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, mux, http.MethodDelete, fmt.Sprintf("/api/projects/%d?permanent=true", hard), "", "X-API-Key", "admin-key"); w.Code != http.StatusNoContent {
+		t.Errorf("permanent DELETE: status %d, body %s", w.Code, w.Body)
+	}
+	if name, err := a.db.GetProjectRepoName(hard); err != nil || name != "" {
+		t.Errorf("permanently deleted project still stored: %q, %v", name, err)
+	}
+	var softDeleted bool
+	if err := a.db.QueryRow(`SELECT is_deleted FROM projects WHERE id = ?`, soft).Scan(&softDeleted); err != nil || !softDeleted {
+		t.Errorf("soft-deleted project: is_deleted %v, %v", softDeleted, err)
+	}
+	for repo, want := range map[string]bool{"acme/soft": false, "acme/hard": true} {
+		if excluded, err := a.db.IsSuppressed(repo); err != nil || excluded != want {
+			t.Errorf("%s excluded = %v, %v; want %v", repo, excluded, err, want)
+		}
+	}
+
+	for _, target := range []string{"/api/projects/999", "/api/projects/999?permanent=true"} {
+		if w := do(t, mux, http.MethodDelete, target, "", "X-API-Key", "admin-key"); w.Code != http.StatusNotFound {
+			t.Errorf("DELETE %s: status %d, want 404", target, w.Code)
+		}
+	}
+
+	// A project that refresh diffs mention can't be removed for good
+	diffed := addProject(t, a, db.Project{RepoFullName: "acme/diffed"})
+	jobID, err := a.db.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.RecordRefreshDiff(jobID, []string{"acme/diffed"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(t, mux, http.MethodDelete, fmt.Sprintf("/api/projects/%d?permanent=true", diffed), "", "X-API-Key", "admin-key"); w.Code != http.StatusConflict {
+		t.Errorf("permanent DELETE of diffed project: status %d, want 409", w.Code)
+	}
+	if name, err := a.db.GetProjectRepoName(diffed); err != nil || name != "acme/diffed" {
+		t.Errorf("diffed project after refused delete: %q, %v", name, err)
+	}
+}
+
+func TestProjectNotes(t *testing.T) {
+	a, mux := newTestAPI(t, nil)
+	id := addProject(t, a, db.Project{RepoFullName: "acme/app"})
+	addProject(t, a, db.Project{RepoFullName: "acme/notes"})
+	target := fmt.Sprintf("/api/project-notes/%d", id)
+
+	w := do(t, mux, http.MethodPost, target, `{"note":"added by hand","author":"sam"}`)
+	var note db.ProjectNote
+	if err := json.Unmarshal(w.Body.Bytes(), &note); err != nil || w.Code != http.StatusCreated || note.ProjectID != id || note.CreatedBy != "sam" {
+		t.Fatalf("POST %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	w = do(t, mux, http.MethodGet, target, "")
+	var notes []db.ProjectNote
+	if err := json.Unmarshal(w.Body.Bytes(), &notes); err != nil || len(notes) != 1 || notes[0].Note != "added by hand" {
+		t.Errorf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	if w := do(t, mux, http.MethodPost, "/api/project-notes/999", `{"note":"x"}`); w.Code != http.StatusNotFound {
+		t.Errorf("note on unknown project: status %d, want 404", w.Code)
+	}
+
+	// A repo whose name matched the old /api/projects/{id}/notes route is reachable
+	w = do(t, mux, http.MethodGet, "/api/projects/acme/notes", "")
+	var p struct {
+		RepoFullName string `json:"repo_full_name"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.RepoFullName != "acme/notes" {
+		t.Errorf("GET /api/projects/acme/notes: status %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// WithAPIKey adds an admin key, required on mutating requests such as POST /api/refresh
+func WithAPIKey(key string) Option {
+	return WithAPIKeys([]string{key})
+}
+
+// WithAPIKeys adds admin keys, one of which is required on mutating requests.
+// Accepting several keys lets a new key be rolled out before the old one is revoked.
+func WithAPIKeys(keys []string) Option {
+	return func(a *API) {
+		for _, k := range keys {
+			if k = strings.TrimSpace(k); k != "" {
+				a.apiKeys = append(a.apiKeys, k)
+			}
+		}
+	}
+}
+
+// authMiddleware requires an admin key on mutating requests, answering 401 without
+// a key and 403 for a lower-tier key. GET, HEAD and OPTIONS requests stay public, as
+// does everything while no admin keys exist. It relies on tierMiddleware having
+// resolved the caller. Keys are sent as "Authorization: Bearer <key>" or "X-API-Key".
+func (a *API) authMiddleware(next http.Handler) http.Handler {
+	admin := a.adminMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !a.adminKeysConfigured() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !requestCaller(r).tier.atLeast(TierAdmin) {
+			log.Printf("Rejected %s %s from %s: admin key required", r.Method, r.URL.Path, clientIP(r))
+		}
+		admin.ServeHTTP(w, r)
+	})
+}
+
+// adminKeysConfigured reports whether any admin key exists, from WithAPIKeys or stored
+func (a *API) adminKeysConfigured() bool {
+	if len(a.apiKeys) > 0 {
+		return true
+	}
+	stored, err := a.db.HasAPIKeys(string(TierAdmin))
+	if err != nil {
+		// Fail closed: a database error must not open mutating endpoints
+		log.Printf("Error checking for admin API keys: %v", err)
+		return true
+	}
+	return stored
+}
+
+// requestAPIKey returns the API key sent with a request, or "" if there is none
+func requestAPIKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// validAPIKey compares key against every configured key in constant time
+func (a *API) validAPIKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	valid := false
+	for _, k := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// badgeMaxAge is how long shields.io and browsers may reuse a badge, in seconds.
+// Shields won't cache for less than 300.
+const badgeMaxAge = 300
+
+// shieldsBadge is the shields.io endpoint badge schema, see https://shields.io/badges/endpoint-badge
+type shieldsBadge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	CacheSeconds  int    `json:"cacheSeconds"`
+}
+
+// handleBadge serves a shields.io endpoint badge for projects, stars or
+// new-this-week, from the database only
+func (a *API) handleBadge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var label string
+	var count int
+	var err error
+	switch kind := r.PathValue("kind"); kind {
+	case "projects", "stars":
+		var stats projectStats
+		stats, err = a.getStats()
+		label, count = "DHI adopters", stats.total
+		if kind == "stars" {
+			label, count = "DHI adopter stars", stats.stars
+		}
+	case "new-this-week":
+		label = "new DHI adopters this week"
+		count, err = a.db.GetNewProjectsCount(startOfWeek(time.Now()))
+	default:
+		a.apiError(w, http.StatusNotFound, "Unknown badge: use projects, stars or new-this-week", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting %s badge: %v", r.PathValue("kind"), err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(badgeMaxAge))
+	a.writeJSON(w, r, http.StatusOK, shieldsBadge{
+		SchemaVersion: 1,
+		Label:         label,
+		Message:       strconv.Itoa(count),
+		Color:         "blue",
+		CacheSeconds:  badgeMaxAge,
+	})
+}
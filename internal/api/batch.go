@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxBatchRequests = 10               // max sub-requests in a single batch
+	batchDeadline    = 10 * time.Second // shared deadline for all sub-requests
+)
+
+var errInternal = errors.New("Internal server error")
+
+// readOp computes the response body for a read-only endpoint from its query parameters.
+// The returned error message is safe to show to clients.
+type readOp func(q url.Values) (interface{}, int, error)
+
+// serveOp runs a read op for an HTTP request and writes the result as JSON
+func (a *API) serveOp(w http.ResponseWriter, r *http.Request, op readOp) {
+	body, status, err := op(r.URL.Query())
+	if err != nil {
+		a.apiError(w, status, err.Error(), nil)
+		return
+	}
+	a.writeJSON(w, r, status, body)
+}
+
+// readOps returns the read operations that can be used inside a batch, keyed by name
+func (a *API) readOps() map[string]readOp {
+	return map[string]readOp{
+		"projects":         a.opProjects,
+		"projects_new":     a.opNewProjects,
+		"trending":         a.opTrendingProjects,
+		"stats":            a.opStats,
+		"stats_growth":     a.opStatsGrowth,
+		"source_types":     a.opSourceTypes,
+		"languages":        a.opLanguages,
+		"language_stats":   a.opStatsLanguages,
+		"language_summary": a.opLanguageSummary,
+		"topics":           a.opTopics,
+		"images":           a.opImages,
+		"ecosystems":       a.opEcosystems,
+		"snapshots":        a.opSnapshots,
+		"history":          a.opHistory,
+		"refresh_jobs":     a.opRefreshHistory,
+	}
+}
+
+// BatchSubRequest is a single named read operation inside a batch
+type BatchSubRequest struct {
+	Op     string            `json:"op"`
+	Params map[string]string `json:"params"`
+}
+
+// BatchResult is the outcome of a single sub-request
+type BatchResult struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body"`
+}
+
+// handleBatch executes several named read operations concurrently in one round trip
+func (a *API) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var req struct {
+		Requests map[string]BatchSubRequest `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if len(req.Requests) == 0 {
+		a.apiError(w, http.StatusBadRequest, "No requests given", nil)
+		return
+	}
+	if len(req.Requests) > maxBatchRequests {
+		a.apiError(w, http.StatusBadRequest, fmt.Sprintf("Too many requests in batch (max %d)", maxBatchRequests), nil)
+		return
+	}
+
+	// The batch counts as all of its component requests against the caller's tier
+	// limit; tierMiddleware already charged one
+	c := requestCaller(r)
+	if limiter := a.tierLimiters[c.tier]; limiter != nil && !limiter.allow(c.rateLimitKey(r), len(req.Requests)-1) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(tierRateWindow.Seconds())))
+		a.apiError(w, http.StatusTooManyRequests, "Rate limit exceeded", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), batchDeadline)
+	defer cancel()
+
+	ops := a.readOps()
+	results := make(map[string]BatchResult, len(req.Requests))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, sub := range req.Requests {
+		wg.Add(1)
+		go func(name string, sub BatchSubRequest) {
+			defer wg.Done()
+			res := runSubRequest(ctx, ops, sub)
+			mu.Lock()
+			results[name] = res
+			mu.Unlock()
+		}(name, sub)
+	}
+	wg.Wait()
+
+	a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
+}
+
+// runSubRequest executes one sub-request, isolating its failure from the rest of the batch
+func runSubRequest(ctx context.Context, ops map[string]readOp, sub BatchSubRequest) BatchResult {
+	if sub.Op == "batch" {
+		return errorResult(http.StatusBadRequest, "Nested batch requests are not allowed")
+	}
+	op, ok := ops[sub.Op]
+	if !ok {
+		return errorResult(http.StatusBadRequest, fmt.Sprintf("Unknown op: %q", sub.Op))
+	}
+
+	q := url.Values{}
+	for k, v := range sub.Params {
+		q.Set(k, v)
+	}
+
+	done := make(chan BatchResult, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- errorResult(http.StatusInternalServerError, errInternal.Error())
+			}
+		}()
+		body, status, err := op(q)
+		if err != nil {
+			done <- errorResult(status, err.Error())
+			return
+		}
+		done <- BatchResult{Status: status, Body: body}
+	}()
+
+	select {
+	case res := <-done:
+		return res
+	case <-ctx.Done():
+		return errorResult(http.StatusGatewayTimeout, "Batch deadline exceeded")
+	}
+}
+
+func errorResult(status int, msg string) BatchResult {
+	return BatchResult{Status: status, Body: errorBody{Error: msg, Code: errorCode(status)}}
+}
+
+// rateLimiter is a fixed-window limiter keyed by client
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// allow reports whether key may spend n more requests in the current window
+func (l *rateLimiter) allow(key string, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	win, ok := l.windows[key]
+	if !ok || now.Sub(win.start) >= l.window {
+		// Drop expired windows so the map doesn't grow without bound
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		win = &rateWindow{start: now}
+		l.windows[key] = win
+	}
+
+	if win.count+n > l.limit {
+		return false
+	}
+	win.count += n
+	return true
+}
+
+// clientIP returns the remote IP of the request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/metrics"
+)
+
+// WithCache keeps up to maxEntries project lists and stats in memory between
+// refreshes. Zero or less leaves caching off.
+func WithCache(maxEntries int) Option {
+	return func(a *API) {
+		if maxEntries > 0 {
+			a.cache = newQueryCache(maxEntries)
+		} else {
+			a.cache = nil
+		}
+	}
+}
+
+// queryCache is an LRU cache of database query results. A nil cache caches nothing.
+type queryCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+	gen     uint64 // bumped by purge, so loads begun before it aren't stored
+}
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+}
+
+func newQueryCache(maxEntries int) *queryCache {
+	return &queryCache{max: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// fetch returns the cached value for key, or calls load and caches what it returns
+func (c *queryCache) fetch(key string, load func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		metrics.CacheRequests.WithLabelValues(metrics.CacheHit).Inc()
+		return el.Value.(*cacheEntry).value, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	metrics.CacheRequests.WithLabelValues(metrics.CacheMiss).Inc()
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return v, nil
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).value = v
+		c.order.MoveToFront(el)
+		return v, nil
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: v})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return v, nil
+}
+
+// purge empties the cache, after the data behind it has changed
+func (c *queryCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.gen++
+	c.mu.Unlock()
+}
+
+// listProjects is db.ListProjects through the cache. Callers get their own copy
+// of the slice, so setting fields on its projects leaves the cached one alone.
+func (a *API) listProjects(filter db.ProjectFilter) ([]db.Project, error) {
+	// JSON spells out every field and follows the pointers, which %v doesn't
+	key, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	v, err := a.cache.fetch("projects:"+string(key), func() (interface{}, error) {
+		return a.db.ListProjects(filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]db.Project{}, v.([]db.Project)...), nil
+}
+
+// projectStats are the totals returned by db.GetStats
+type projectStats struct {
+	total, stars, popular, notable int
+}
+
+// getStats is db.GetStats through the cache
+func (a *API) getStats() (projectStats, error) {
+	t := a.starThresholds
+	key := fmt.Sprintf("stats:%d:%d", t.Popular, t.Notable)
+	v, err := a.cache.fetch(key, func() (interface{}, error) {
+		var s projectStats
+		var err error
+		s.total, s.stars, s.popular, s.notable, err = a.db.GetStats(t)
+		return s, err
+	})
+	if err != nil {
+		return projectStats{}, err
+	}
+	return v.(projectStats), nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/internal/github/mock"
+)
+
+// fetchCounting fetches key, reporting whether load ran
+func fetchCounting(t *testing.T, c *queryCache, key string) bool {
+	t.Helper()
+	loaded := false
+	v, err := c.fetch(key, func() (interface{}, error) {
+		loaded = true
+		return key, nil
+	})
+	if err != nil || v != key {
+		t.Fatalf("fetch(%s) = %v, %v", key, v, err)
+	}
+	return loaded
+}
+
+func TestQueryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newQueryCache(2)
+	fetchCounting(t, c, "a")
+	fetchCounting(t, c, "b")
+	if fetchCounting(t, c, "a") {
+		t.Error("a loaded again while cached")
+	}
+	// a was used last, so c pushes out b
+	fetchCounting(t, c, "c")
+	if fetchCounting(t, c, "a") {
+		t.Error("a evicted although it was used more recently than b")
+	}
+	if !fetchCounting(t, c, "b") {
+		t.Error("b still cached past the limit")
+	}
+	if len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Errorf("%d entries, %d in order; want 2", len(c.entries), c.order.Len())
+	}
+}
+
+func TestQueryCachePurge(t *testing.T) {
+	c := newQueryCache(10)
+	fetchCounting(t, c, "a")
+	c.purge()
+	if !fetchCounting(t, c, "a") {
+		t.Error("a still cached after purge")
+	}
+
+	// A load that began before a purge may have read the old data, so it isn't kept
+	if _, err := c.fetch("b", func() (interface{}, error) {
+		c.purge()
+		return "b", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !fetchCounting(t, c, "b") {
+		t.Error("value loaded across a purge was cached")
+	}
+
+	var nilCache *queryCache
+	nilCache.purge()
+	if !fetchCounting(t, nilCache, "a") || !fetchCounting(t, nilCache, "a") {
+		t.Error("nil cache returned a cached value")
+	}
+}
+
+func TestRefreshPurgesCache(t *testing.T) {
+	client := &mock.MockClient{Projects: []github.Project{{
+		RepoFullName:   "acme/app",
+		GitHubURL:      "https://github.com/acme/app",
+		Stars:          42,
+		SourceType:     "Dockerfile",
+		Registry:       "dhi.io",
+		MatchedQueries: []string{"Dockerfile"},
+	}}}
+	a, mux := newTestAPI(t, client, WithCache(10))
+	totalProjects := func() int {
+		t.Helper()
+		w := do(t, mux, http.MethodGet, "/api/stats", "")
+		var stats struct {
+			TotalProjects int `json:"total_projects"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("GET /api/stats: status %d, body %s", w.Code, w.Body)
+		}
+		return stats.TotalProjects
+	}
+
+	if n := totalProjects(); n != 0 {
+		t.Fatalf("total_projects = %d before any refresh", n)
+	}
+	// Writing behind the API's back leaves the cached totals in place
+	addProject(t, a, db.Project{RepoFullName: "acme/direct"})
+	if n := totalProjects(); n != 0 {
+		t.Fatalf("total_projects = %d, want the cached 0", n)
+	}
+
+	if _, err := a.startRefresh("manual", 0); err != nil {
+		t.Fatalf("startRefresh: %v", err)
+	}
+	waitForRefresh(t, a)
+	total, _, _, _, err := a.db.GetStats(a.starThresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total == 0 {
+		t.Fatal("refresh saved no projects")
+	}
+	if n := totalProjects(); n != total {
+		t.Errorf("total_projects = %d after refresh, want %d from the database", n, total)
+	}
+}
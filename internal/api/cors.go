@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowMethods = "GET, POST, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders = "Content-Type, Authorization, X-API-Key"
+	// corsExposeHeaders are response headers scripts may read beyond the safelisted
+	// ones, so cross-origin clients can back off and name downloads
+	corsExposeHeaders = "Retry-After, X-RateLimit-Limit, Content-Disposition"
+	corsMaxAge        = "600" // seconds browsers may cache a preflight result
+)
+
+// Option configures an API at construction time
+type Option func(*API)
+
+// WithCORS allows browser requests from the given origins, e.g. "https://dash.example.com".
+// "*" allows any origin. With no origins, cross-origin requests are left to the browser's
+// same-origin policy.
+func WithCORS(allowedOrigins []string) Option {
+	return func(a *API) {
+		a.corsOrigins = make(map[string]bool, len(allowedOrigins))
+		for _, o := range allowedOrigins {
+			if o = strings.TrimSpace(o); o != "" {
+				a.corsOrigins[o] = true
+			}
+		}
+	}
+}
+
+// corsMiddleware sets CORS headers for allowed origins and answers preflight requests.
+// Requests from an origin that isn't allowed are rejected with 400 when origins are configured.
+func (a *API) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(a.corsOrigins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !a.corsOrigins[origin] && !a.corsOrigins["*"] {
+			a.apiError(w, http.StatusBadRequest, "Origin not allowed", nil)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	_, mux := newTestAPI(t, nil, WithCORS([]string{"https://dash.example.com", " https://other.example.com "}))
+
+	t.Run("allowed origin", func(t *testing.T) {
+		w := do(t, mux, http.MethodGet, "/api/stats", "", "Origin", "https://dash.example.com")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, body %s", w.Code, w.Body)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+			t.Errorf("Access-Control-Allow-Origin %q", got)
+		}
+		if got := w.Header().Get("Access-Control-Expose-Headers"); got != corsExposeHeaders {
+			t.Errorf("Access-Control-Expose-Headers %q", got)
+		}
+		if got := w.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("Vary %q, want Origin", got)
+		}
+	})
+
+	t.Run("trimmed origin", func(t *testing.T) {
+		w := do(t, mux, http.MethodGet, "/api/stats", "", "Origin", "https://other.example.com")
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://other.example.com" {
+			t.Errorf("status %d, Access-Control-Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		w := do(t, mux, http.MethodGet, "/api/stats", "", "Origin", "https://evil.example.com")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin %q for a disallowed origin", got)
+		}
+	})
+
+	t.Run("no origin", func(t *testing.T) {
+		w := do(t, mux, http.MethodGet, "/api/stats", "")
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("status %d, Access-Control-Allow-Origin %q; same-origin requests pass untouched",
+				w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		w := do(t, mux, http.MethodOptions, "/api/refresh", "",
+			"Origin", "https://dash.example.com",
+			"Access-Control-Request-Method", "POST",
+			"Access-Control-Request-Headers", "Authorization")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, body %s", w.Code, w.Body)
+		}
+		for header, want := range map[string]string{
+			"Access-Control-Allow-Origin":  "https://dash.example.com",
+			"Access-Control-Allow-Methods": corsAllowMethods,
+			"Access-Control-Allow-Headers": corsAllowHeaders,
+			"Access-Control-Max-Age":       corsMaxAge,
+		} {
+			if got := w.Header().Get(header); got != want {
+				t.Errorf("%s %q, want %q", header, got, want)
+			}
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("preflight body %q, want none", w.Body)
+		}
+	})
+
+	t.Run("preflight from disallowed origin", func(t *testing.T) {
+		w := do(t, mux, http.MethodOptions, "/api/refresh", "",
+			"Origin", "https://evil.example.com",
+			"Access-Control-Request-Method", "POST")
+		if w.Code != http.StatusBadRequest || w.Header().Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("status %d, Access-Control-Allow-Methods %q; want 400 without CORS headers",
+				w.Code, w.Header().Get("Access-Control-Allow-Methods"))
+		}
+	})
+}
+
+func TestCORSWildcard(t *testing.T) {
+	_, mux := newTestAPI(t, nil, WithCORS([]string{"*"}))
+	w := do(t, mux, http.MethodGet, "/api/stats", "", "Origin", "https://anywhere.example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://anywhere.example.com" {
+		t.Errorf("status %d, Access-Control-Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	_, mux := newTestAPI(t, nil)
+	w := do(t, mux, http.MethodGet, "/api/stats", "", "Origin", "https://dash.example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("status %d, Access-Control-Allow-Origin %q; without origins configured CORS stays off",
+			w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
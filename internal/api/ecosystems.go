@@ -0,0 +1,351 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/ecosystem"
+)
+
+// SetEcosystems sets the language→ecosystem mapping used for reporting
+func (a *API) SetEcosystems(m *ecosystem.Mapping) {
+	a.ecosystems = m
+}
+
+// setEcosystems fills in the computed Ecosystem field of each project
+func (a *API) setEcosystems(projects []db.Project) {
+	for i := range projects {
+		projects[i].Ecosystem = a.ecosystems.Of(projects[i].PrimaryLanguage)
+	}
+}
+
+// groupLanguageCounts sums per-language project counts into ecosystems
+func (a *API) groupLanguageCounts(counts []db.LanguageCount) []ecosystem.Count {
+	byLanguage := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byLanguage[c.Language] += c.Count
+	}
+	return a.ecosystems.Group(byLanguage)
+}
+
+// parseBreakdown validates the ?by= dimension; "" means no breakdown
+func parseBreakdown(q url.Values, allowed ...string) (string, error) {
+	by := q.Get("by")
+	if by == "" {
+		return "", nil
+	}
+	for _, a := range allowed {
+		if by == a {
+			return by, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid 'by' parameter. Use '%s'", strings.Join(allowed, "' or '"))
+}
+
+// handleEcosystems returns project counts per ecosystem
+func (a *API) handleEcosystems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opEcosystems)
+}
+
+func (a *API) opEcosystems(q url.Values) (interface{}, int, error) {
+	counts, err := a.db.GetLanguageCounts()
+	if err != nil {
+		log.Printf("Error getting language counts: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	return a.groupLanguageCounts(counts), http.StatusOK, nil
+}
+
+// ecosystemAdoption is the number of projects in an ecosystem that adopted DHI on a date
+type ecosystemAdoption struct {
+	Date      string `json:"date"`
+	Ecosystem string `json:"ecosystem"`
+	Count     int    `json:"count"`
+}
+
+// adoptionByEcosystem returns daily adoption counts per ecosystem for the last days days
+func (a *API) adoptionByEcosystem(days int) ([]ecosystemAdoption, error) {
+	rows, err := a.db.GetAdoptionByDateAndLanguage(days)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ date, eco string }
+	totals := make(map[key]int)
+	for _, r := range rows {
+		totals[key{r.Date, a.ecosystems.Of(r.Language)}] += r.Count
+	}
+	out := make([]ecosystemAdoption, 0, len(totals))
+	for k, n := range totals {
+		out = append(out, ecosystemAdoption{Date: k.date, Ecosystem: k.eco, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Date != out[j].Date {
+			return out[i].Date < out[j].Date
+		}
+		return out[i].Ecosystem < out[j].Ecosystem
+	})
+	return out, nil
+}
+
+// snapshotView is a snapshot with an optional language or ecosystem breakdown
+type snapshotView struct {
+	db.RefreshSnapshot
+	Breakdown interface{} `json:"breakdown,omitempty"`
+}
+
+// handleSnapshots returns the snapshots recorded after each refresh, most recent
+// first, optionally within a time range or only the last of each day or week
+func (a *API) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opSnapshots)
+}
+
+func (a *API) opSnapshots(q url.Values) (interface{}, int, error) {
+	filter := db.SnapshotFilter{Limit: 30}
+	var err error
+	if filter.Since, err = parseTimeParam(q, "from"); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if filter.Until, err = parseTimeParam(q, "to"); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		// A range should come back whole unless a limit is asked for
+		filter.Limit = 365
+	}
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		filter.Limit = min(v, 365)
+	}
+	switch g := q.Get("granularity"); g {
+	case "", "daily", "weekly":
+		filter.Granularity = g
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'granularity' parameter. Use 'daily' or 'weekly'")
+	}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'order' parameter. Use 'asc' or 'desc'")
+	}
+	by, err := parseBreakdown(q, "language", "ecosystem")
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	snapshots, err := a.db.GetSnapshots(filter)
+	if err != nil {
+		log.Printf("Error getting snapshots: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+
+	var languages map[int64][]db.LanguageCount
+	if by != "" {
+		ids := make([]int64, len(snapshots))
+		for i, s := range snapshots {
+			ids[i] = s.ID
+		}
+		languages, err = a.db.GetSnapshotLanguages(ids)
+		if err != nil {
+			log.Printf("Error getting snapshot languages: %v", err)
+			return nil, http.StatusInternalServerError, errInternal
+		}
+	}
+
+	views := make([]snapshotView, 0, len(snapshots))
+	for _, s := range snapshots {
+		v := snapshotView{RefreshSnapshot: s}
+		switch by {
+		case "language":
+			v.Breakdown = languages[s.ID]
+		case "ecosystem":
+			v.Breakdown = a.groupLanguageCounts(languages[s.ID])
+		}
+		views = append(views, v)
+	}
+	return views, http.StatusOK, nil
+}
+
+// parseTimeParam parses an optional time parameter given as RFC3339 or as a
+// duration before now like "7d"; the zero time means it wasn't given
+func parseTimeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := parseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("Invalid '%s' parameter. Use an RFC3339 time or '7d', '4w', '24h'", name)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// handleStatsTrends returns how the snapshot totals changed between consecutive
+// snapshots within a window
+func (a *API) handleStatsTrends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opStatsTrends)
+}
+
+func (a *API) opStatsTrends(q url.Values) (interface{}, int, error) {
+	window := 30 * 24 * time.Hour
+	if v := q.Get("window"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'window' parameter. Use '7d', '4w', '30d'")
+		}
+		window = d
+	}
+
+	trends, err := a.db.GetSnapshotTrends(time.Now().Add(-window))
+	if err != nil {
+		log.Printf("Error getting snapshot trends: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+	if trends == nil {
+		trends = []db.SnapshotDelta{}
+	}
+	return trends, http.StatusOK, nil
+}
+
+// defaultGrowthWindows are the windows /api/stats/growth compares when none are given
+var defaultGrowthWindows = []string{"7d", "30d", "90d"}
+
+// metricChange is how a snapshot total moved over a window
+type metricChange struct {
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Change  int      `json:"change"`  // negative when the total decreased
+	Percent *float64 `json:"percent"` // of From, to one decimal; null when From is 0
+}
+
+func newMetricChange(from, to int) *metricChange {
+	c := &metricChange{From: from, To: to, Change: to - from}
+	if from != 0 {
+		pct := math.Round(float64(c.Change)/float64(from)*1000) / 10
+		c.Percent = &pct
+	}
+	return c
+}
+
+// growthWindow compares the latest snapshot with the closest one at or before the
+// window start. Without such a baseline every change is null.
+type growthWindow struct {
+	Window        string              `json:"window"`
+	Since         time.Time           `json:"since"`
+	Baseline      *db.RefreshSnapshot `json:"baseline"`
+	TotalProjects *metricChange       `json:"total_projects"`
+	TotalStars    *metricChange       `json:"total_stars"`
+	PopularCount  *metricChange       `json:"popular_count"`
+	NotableCount  *metricChange       `json:"notable_count"`
+}
+
+// handleStatsGrowth returns how the snapshot totals changed over each of several
+// windows ending at the latest snapshot
+func (a *API) handleStatsGrowth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	a.serveOp(w, r, a.opStatsGrowth)
+}
+
+func (a *API) opStatsGrowth(q url.Values) (interface{}, int, error) {
+	windows := defaultGrowthWindows
+	if v := q.Get("windows"); v != "" {
+		windows = strings.Split(strings.ReplaceAll(v, " ", ""), ",")
+	}
+	durations := make([]time.Duration, len(windows))
+	for i, w := range windows {
+		d, err := parseDuration(w)
+		if err != nil || d <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid 'windows' parameter. Use a comma-separated list like '7d,30d,90d'")
+		}
+		durations[i] = d
+	}
+
+	latest, err := a.db.GetSnapshots(db.SnapshotFilter{Limit: 1})
+	if err != nil {
+		log.Printf("Error getting latest snapshot: %v", err)
+		return nil, http.StatusInternalServerError, errInternal
+	}
+
+	now := time.Now()
+	growth := make([]growthWindow, len(windows))
+	for i, d := range durations {
+		g := growthWindow{Window: windows[i], Since: now.Add(-d).UTC().Truncate(time.Second)}
+		baseline, err := a.db.GetSnapshots(db.SnapshotFilter{Until: g.Since, Limit: 1})
+		if err != nil {
+			log.Printf("Error getting snapshot before %s: %v", g.Since, err)
+			return nil, http.StatusInternalServerError, errInternal
+		}
+		if len(latest) > 0 && len(baseline) > 0 {
+			from, to := baseline[0], latest[0]
+			g.Baseline = &from
+			g.TotalProjects = newMetricChange(from.TotalProjects, to.TotalProjects)
+			g.TotalStars = newMetricChange(from.TotalStars, to.TotalStars)
+			g.PopularCount = newMetricChange(from.PopularCount, to.PopularCount)
+			g.NotableCount = newMetricChange(from.NotableCount, to.NotableCount)
+		}
+		growth[i] = g
+	}
+
+	body := map[string]interface{}{"latest": nil, "windows": growth}
+	if len(latest) > 0 {
+		body["latest"] = latest[0]
+	}
+	return body, http.StatusOK, nil
+}
+
+// handleEcosystemMapping shows the effective language→ecosystem mapping and the
+// languages present in the data that fall into Other because they aren't mapped
+func (a *API) handleEcosystemMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	counts, err := a.db.GetLanguageCounts()
+	if err != nil {
+		log.Printf("Error getting language counts: %v", err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	unmapped := []db.LanguageCount{}
+	for _, c := range counts {
+		if !a.ecosystems.Mapped(c.Language) {
+			unmapped = append(unmapped, c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mapping":  a.ecosystems.Languages(),
+		"fallback": ecosystem.Other,
+		"unmapped": unmapped,
+	})
+}
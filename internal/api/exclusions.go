@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// handleExclusions lists the repos kept out of the tracker, or excludes one on
+// POST. Excluding a tracked project removes it from listings and stats at once.
+func (a *API) handleExclusions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		exclusions, err := a.db.ListExclusions()
+		if err != nil {
+			log.Printf("Error listing exclusions: %v", err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		a.writeJSON(w, r, http.StatusOK, exclusions)
+
+	case http.MethodPost:
+		var req struct {
+			RepoFullName string `json:"repo_full_name"`
+			Reason       string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		req.RepoFullName, req.Reason = strings.TrimSpace(req.RepoFullName), strings.TrimSpace(req.Reason)
+		if !repoNamePattern.MatchString(req.RepoFullName) {
+			a.apiError(w, http.StatusBadRequest, "repo_full_name must look like owner/name", nil)
+			return
+		}
+
+		removed, err := a.db.ExcludeRepo(req.RepoFullName, req.Reason)
+		if err != nil {
+			log.Printf("Error excluding %s: %v", req.RepoFullName, err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		if removed {
+			a.cache.purge()
+		}
+		log.Printf("Excluded %s for %s (removed project: %v)", req.RepoFullName, requestCaller(r).name, removed)
+		a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"success":         true,
+			"repo_full_name":  req.RepoFullName,
+			"removed_project": removed,
+		})
+
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// handleExclusion lets refreshes track an excluded repo again
+// (DELETE /api/admin/exclusions/{owner}/{repo})
+func (a *API) handleExclusion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	repo := r.PathValue("owner") + "/" + r.PathValue("repo")
+	removed, err := a.db.RemoveExclusion(repo)
+	if err != nil {
+		log.Printf("Error removing exclusion of %s: %v", repo, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !removed {
+		a.apiError(w, http.StatusNotFound, "Exclusion not found", nil)
+		return
+	}
+	log.Printf("Removed exclusion of %s for %s", repo, requestCaller(r).name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest response body worth compressing
+const gzipMinSize = 1024
+
+// incompressibleTypes are content type prefixes that are already compressed or streamed
+var incompressibleTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"image/",
+	"audio/",
+	"video/",
+	"text/event-stream",
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip. Bodies under
+// gzipMinSize and already-compressed content types are sent as is.
+func (a *API) gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			v, err := strconv.ParseFloat(q, 64)
+			return err == nil && v > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// compressing it is worthwhile, then either streams through gzip or passes it on
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	started bool // headers have been sent
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.started {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends buffered data so streaming responses aren't held back
+func (g *gzipResponseWriter) Flush() {
+	if !g.started {
+		if g.status == 0 {
+			g.status = http.StatusOK
+		}
+		g.start(true)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start sends the headers and buffered body, compressing if allowed and the
+// content type is worth it
+func (g *gzipResponseWriter) start(compress bool) error {
+	g.started = true
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if g.gz != nil {
+		_, err := g.gz.Write(buf)
+		return err
+	}
+	_, err := g.ResponseWriter.Write(buf)
+	return err
+}
+
+// close finishes the response, sending small bodies uncompressed
+func (g *gzipResponseWriter) close() {
+	if !g.started && g.status != 0 {
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"dhi-oss-usage/internal/metrics"
+)
+
+// metricsMiddleware observes how long requests to a route take. Routes are
+// labelled by their mux pattern rather than the path, so /api/projects/{owner}/{repo}
+// is one series however many repos are looked up.
+func metricsMiddleware(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		metrics.HTTPRequestDuration.WithLabelValues(pattern, r.Method, strconv.Itoa(sw.status)).
+			Observe(time.Since(started).Seconds())
+	})
+}
+
+// statusWriter records the status code a handler responds with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush passes flushes through, so progress streams aren't held back
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
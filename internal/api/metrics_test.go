@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/internal/github/mock"
+	"dhi-oss-usage/internal/metrics"
+)
+
+// scrapeMetrics returns the exposition of the metrics handler, as sample values
+// keyed by name and labels, and the metric names with a TYPE line
+func scrapeMetrics(t *testing.T) (samples map[string]float64, types map[string]bool) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/metrics: status %d", w.Code)
+	}
+	samples, types = make(map[string]float64), make(map[string]bool)
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			types[strings.Fields(rest)[0]] = true
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		samples[line[:i]] = v
+	}
+	return samples, types
+}
+
+func TestMetricsAfterRefresh(t *testing.T) {
+	client := &mock.MockClient{Projects: []github.Project{
+		{RepoFullName: "acme/a", GitHubURL: "https://github.com/acme/a", Stars: 10, SourceType: "Dockerfile", Registry: "dhi.io"},
+		{RepoFullName: "acme/b", GitHubURL: "https://github.com/acme/b", Stars: 5, SourceType: "Dockerfile", Registry: "dhi.io"},
+	}}
+	a, mux := newTestAPI(t, client)
+
+	// Counters are process-wide, so compare against a scrape taken before the run
+	before, _ := scrapeMetrics(t)
+	if w := do(t, mux, http.MethodPost, "/api/refresh", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("POST /api/refresh: status %d, body %s", w.Code, w.Body)
+	}
+	waitForRefresh(t, a)
+	do(t, mux, http.MethodGet, "/api/stats", "")
+	after, types := scrapeMetrics(t)
+
+	for _, name := range []string{
+		"dhi_projects_total",
+		"dhi_total_stars",
+		"dhi_refresh_duration_seconds",
+		"dhi_refresh_jobs_total",
+		"dhi_last_successful_refresh_timestamp_seconds",
+		"dhi_http_request_duration_seconds",
+	} {
+		if !types[name] {
+			t.Errorf("%s missing from the exposition", name)
+		}
+	}
+
+	for sample, want := range map[string]float64{
+		"dhi_projects_total": 2,
+		"dhi_total_stars":    15,
+	} {
+		if got := after[sample]; got != want {
+			t.Errorf("%s = %v, want %v", sample, got, want)
+		}
+	}
+	for sample, delta := range map[string]float64{
+		`dhi_refresh_jobs_total{result="completed"}`:                                             1,
+		"dhi_refresh_duration_seconds_count":                                                     1,
+		`dhi_http_request_duration_seconds_count{code="202",method="POST",route="/api/refresh"}`: 1,
+		`dhi_http_request_duration_seconds_count{code="200",method="GET",route="/api/stats"}`:    1,
+	} {
+		if got := after[sample] - before[sample]; got != delta {
+			t.Errorf("%s went up by %v, want %v", sample, got, delta)
+		}
+	}
+	if after["dhi_last_successful_refresh_timestamp_seconds"] == 0 {
+		t.Error("dhi_last_successful_refresh_timestamp_seconds not set")
+	}
+}
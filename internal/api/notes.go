@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Longest note and author name accepted, in bytes
+const (
+	maxNoteLength   = 4000
+	maxAuthorLength = 100
+)
+
+// handleProjectNotes lists a project's notes, or adds one on POST. The author
+// defaults to the name of the caller's API key.
+func (a *API) handleProjectNotes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid project id", err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	p, err := a.db.GetProjectByID(id)
+	if err != nil {
+		log.Printf("Error looking up project %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if p == nil {
+		a.apiError(w, http.StatusNotFound, "Project not found", nil)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		notes, err := a.db.ListNotes(id)
+		if err != nil {
+			log.Printf("Error listing notes of project %d: %v", id, err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		a.writeJSON(w, r, http.StatusOK, notes)
+		return
+	}
+
+	var req struct {
+		Note   string `json:"note"`
+		Author string `json:"author"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	req.Note, req.Author = strings.TrimSpace(req.Note), strings.TrimSpace(req.Author)
+	if req.Note == "" {
+		a.apiError(w, http.StatusBadRequest, "note is required", nil)
+		return
+	}
+	if len(req.Note) > maxNoteLength || len(req.Author) > maxAuthorLength {
+		a.apiError(w, http.StatusBadRequest, fmt.Sprintf("note must be at most %d characters and author at most %d", maxNoteLength, maxAuthorLength), nil)
+		return
+	}
+	if req.Author == "" {
+		req.Author = requestCaller(r).name
+	}
+
+	note, err := a.db.CreateNote(id, req.Note, req.Author)
+	if err != nil {
+		log.Printf("Error adding note to project %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	a.cache.purge()
+	log.Printf("Added note %d to project %s for %s", note.ID, p.RepoFullName, requestCaller(r).name)
+	a.writeJSON(w, r, http.StatusCreated, note)
+}
+
+// handleNote removes a note on DELETE
+func (a *API) handleNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid note id", err)
+		return
+	}
+
+	deleted, err := a.db.DeleteNote(id)
+	if err != nil {
+		log.Printf("Error deleting note %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !deleted {
+		a.apiError(w, http.StatusNotFound, "Note not found", nil)
+		return
+	}
+	a.cache.purge()
+	log.Printf("Deleted note %d for %s", id, requestCaller(r).name)
+	w.WriteHeader(http.StatusNoContent)
+}
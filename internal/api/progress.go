@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/pkg/tracker"
+)
+
+// progressBuffer is how many events a slow subscriber may fall behind before
+// further events are dropped for it
+const progressBuffer = 64
+
+// ProgressEvent is a refresh progress update sent to /api/refresh/stream subscribers
+type ProgressEvent struct {
+	Type       string `json:"type"` // query_done, progress, completed, failed, cancelled, idle
+	JobID      int64  `json:"job_id,omitempty"`
+	Query      string `json:"query,omitempty"`       // query_done
+	ReposFound int    `json:"repos_found,omitempty"` // query_done: unique repos found so far
+	Stage      string `json:"stage,omitempty"`       // progress
+	Current    int    `json:"current,omitempty"`     // progress
+	Count      int    `json:"count,omitempty"`       // progress: total items in the stage
+	Total      int    `json:"total,omitempty"`       // completed: projects found
+	Error      string `json:"error,omitempty"`       // failed, cancelled
+}
+
+// terminal reports whether no more events follow for the job
+func (e ProgressEvent) terminal() bool {
+	return e.Type == "completed" || e.Type == "failed" || e.Type == "cancelled"
+}
+
+// progressHub fans refresh progress out to subscribers. Publishing never blocks,
+// so a slow client can't hold up the refresh.
+type progressHub struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{subs: make(map[chan string]struct{})}
+}
+
+func (h *progressHub) subscribe() chan string {
+	ch := make(chan string, progressBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *progressHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish sends an event to every subscriber that has room for it
+func (h *progressHub) publish(e ProgressEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- string(data):
+		default:
+		}
+	}
+}
+
+// publishRefreshEvent converts tracker progress into subscriber events
+func (a *API) publishRefreshEvent(jobID int64, e tracker.Event) {
+	if e.Kind != tracker.EventProgress {
+		return
+	}
+	if query, ok := strings.CutPrefix(e.Stage, github.QueryDoneStage); ok {
+		a.progress.publish(ProgressEvent{Type: "query_done", JobID: jobID, Query: query, ReposFound: e.Current})
+		return
+	}
+	a.progress.publish(ProgressEvent{Type: "progress", JobID: jobID, Stage: e.Stage, Current: e.Current, Count: e.Total})
+}
+
+// handleRefreshProgress streams progress of the running refresh as Server-Sent Events
+// until the refresh ends or the client disconnects. With no refresh running it sends
+// a single idle event.
+func (a *API) handleRefreshProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		a.apiError(w, http.StatusInternalServerError, "Streaming not supported", nil)
+		return
+	}
+
+	// Subscribe before checking the flag so no event between the two is missed
+	events := a.progress.subscribe()
+	defer a.progress.unsubscribe(events)
+
+	a.refreshMu.Lock()
+	isRunning := a.refreshRunning
+	a.refreshMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if !isRunning {
+		fmt.Fprint(w, "data: {\"type\":\"idle\"}\n\n")
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-events:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+
+			var e ProgressEvent
+			if json.Unmarshal([]byte(data), &e) == nil && e.terminal() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,924 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/internal/health"
+	"dhi-oss-usage/internal/metrics"
+	"dhi-oss-usage/pkg/tracker"
+)
+
+// cancelledByUser is the error message of jobs stopped with POST /api/refresh/cancel
+const cancelledByUser = "cancelled by user"
+
+// minRefreshInterval is how soon after the previous job a manual refresh is refused
+// without force=true
+const minRefreshInterval = 30 * time.Second
+
+// orphanedJobAge is how long a job may stay pending before the cleanup removes it
+const orphanedJobAge = 10 * time.Minute
+
+// refreshTimeout bounds a whole refresh; a job still running after it was lost
+const refreshTimeout = 10 * time.Minute
+
+// defaultSnapshotGap is the least time between snapshots recorded after refreshes,
+// unless SetSnapshotInterval changes it
+const defaultSnapshotGap = time.Hour
+
+// SetSnapshotInterval sets the least time between snapshots recorded after
+// refreshes. A snapshot identical to the previous one is never recorded; 0 keeps
+// only that check. The daily snapshot isn't affected.
+func (a *API) SetSnapshotInterval(d time.Duration) {
+	a.snapshotGap = d
+}
+
+// SetStarThresholds sets the stars from which projects count as popular and
+// notable in stats and new snapshots. Snapshots already recorded keep the counts
+// made under the thresholds of their time, so history may jump where they change.
+func (a *API) SetStarThresholds(t db.StarThresholds) {
+	a.starThresholds = t
+}
+
+// errRefreshRunning is returned by startRefresh while another refresh holds the lock
+var errRefreshRunning = errors.New("refresh already in progress")
+
+// refreshTooSoonError is returned by startRefresh when the previous job is too recent
+type refreshTooSoonError struct {
+	since, retryAfter time.Duration
+}
+
+func (e *refreshTooSoonError) Error() string {
+	return fmt.Sprintf("Previous refresh was triggered %s ago; retry in %s or pass force=true",
+		e.since.Round(time.Second), e.retryAfter.Round(time.Second))
+}
+
+// startRefresh takes the refresh lock, creates a job and runs it in the background.
+// The job row is only created once the lock is held, so a rejected trigger leaves
+// no pending job behind. With minInterval set, it refuses to start within
+// minInterval of the previous job's creation.
+func (a *API) startRefresh(source string, minInterval time.Duration) (int64, error) {
+	a.refreshMu.Lock()
+	if a.refreshRunning {
+		a.refreshMu.Unlock()
+		return 0, errRefreshRunning
+	}
+	a.refreshRunning = true
+	a.refreshMu.Unlock()
+
+	release := func() {
+		a.refreshMu.Lock()
+		a.refreshRunning = false
+		a.refreshMu.Unlock()
+	}
+
+	if minInterval > 0 {
+		last, err := a.db.GetLatestRefreshJob()
+		if err != nil {
+			release()
+			log.Printf("Error checking last refresh job for %s refresh: %v", source, err)
+			return 0, err
+		}
+		if last != nil {
+			if since := a.now().Sub(last.CreatedAt); since < minInterval {
+				release()
+				log.Printf("Refusing %s refresh: job %d was created %s ago", source, last.ID, since.Round(time.Second))
+				return 0, &refreshTooSoonError{since: since, retryAfter: minInterval - since}
+			}
+		}
+	}
+
+	jobID, err := a.db.CreateRefreshJob(source)
+	if err != nil {
+		release()
+		log.Printf("Error creating refresh job for %s refresh: %v", source, err)
+		return 0, err
+	}
+
+	// The job is cancellable from here on; runRefresh releases the lock when it returns
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	a.refreshMu.Lock()
+	a.refreshJobID = jobID
+	a.refreshCancel = cancel
+	a.refreshMu.Unlock()
+
+	go a.runRefresh(ctx, cancel, jobID, source)
+	return jobID, nil
+}
+
+// CleanupRefreshJobs settles jobs that will never finish: pending jobs older than
+// orphanedJobAge are removed and running jobs older than refreshTimeout are marked
+// failed, other than the current one. With startup set, nothing can be running
+// yet, so every pending job is removed and every running job, left behind by a
+// crash or restart, is marked failed.
+func (a *API) CleanupRefreshJobs(startup bool) {
+	a.refreshMu.Lock()
+	current := a.refreshJobID
+	a.refreshMu.Unlock()
+
+	age, runningAge, msg := orphanedJobAge, refreshTimeout, "lost track of running job"
+	if startup {
+		age, runningAge, msg = 0, 0, "process restarted"
+	}
+	now := a.now()
+	if n, err := a.db.FailStaleRunningJobs(now.Add(-runningAge), current, msg); err != nil {
+		log.Printf("Error failing stale running refresh jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d stale running refresh jobs failed (%s)", n, msg)
+	}
+
+	n, err := a.db.DeleteOrphanedRefreshJobs(now.Add(-age), current)
+	if err != nil {
+		log.Printf("Error removing orphaned refresh jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Removed %d orphaned pending refresh jobs", n)
+	}
+}
+
+// runRefresh runs a job started by startRefresh. It holds the refresh lock until
+// it returns, even once cancelled, so no other refresh overlaps its unwinding.
+func (a *API) runRefresh(ctx context.Context, cancel context.CancelFunc, jobID int64, source string) {
+	defer cancel()
+	defer func() {
+		a.refreshMu.Lock()
+		a.refreshRunning = false
+		a.refreshJobID = 0
+		a.refreshCancel = nil
+		a.refreshMu.Unlock()
+	}()
+
+	log.Printf("Starting refresh job %d (source: %s)", jobID, source)
+
+	if err := a.db.StartRefreshJob(jobID); err != nil {
+		log.Printf("Error starting job: %v", err)
+		return
+	}
+
+	started := time.Now()
+	defer a.recordRefreshUsage(jobID, started, a.requestsUsed())
+
+	progress := &jobProgress{db: a.db, jobID: jobID}
+	if source == db.RefreshSourceDryRun {
+		a.runDryRun(ctx, jobID, progress)
+		return
+	}
+	// Whatever the run saved, including enrichment after completion, must not be
+	// hidden behind cached results
+	defer a.cache.purge()
+	t := a.newTracker(a.jobStore(jobID), jobID, progress)
+
+	etagsBefore := a.conditionalStats()
+	res, err := t.RunPartial(ctx, tracker.ScopeDiscover)
+	metrics.RefreshDuration.Observe(time.Since(started).Seconds())
+	if err != nil {
+		log.Printf("Error fetching projects: %v", err)
+		result := metrics.ResultFailed
+		if errors.Is(err, context.Canceled) {
+			result = metrics.ResultCancelled
+		}
+		metrics.RefreshJobs.WithLabelValues(result).Inc()
+		a.failRefreshJob(jobID, err)
+		return
+	}
+
+	a.warnCoverageDrift()
+
+	etagsAfter := a.conditionalStats()
+	hits, misses := int(etagsAfter.Hits-etagsBefore.Hits), int(etagsAfter.Misses-etagsBefore.Misses)
+	if err := a.db.SetRefreshJobETagStats(jobID, hits, misses); err != nil {
+		log.Printf("Error recording ETag stats: %v", err)
+	} else if hits+misses > 0 {
+		log.Printf("Refresh job %d repo detail ETags: %d not modified, %d fetched", jobID, hits, misses)
+	}
+
+	progress.flush()
+	if err := a.db.CompleteRefreshJob(jobID, len(res.Projects)); err != nil {
+		log.Printf("Error completing job: %v", err)
+	}
+	metrics.RefreshJobs.WithLabelValues(metrics.ResultCompleted).Inc()
+	metrics.LastRefreshSuccess.SetToCurrentTime()
+	a.cache.purge()
+	a.UpdateProjectMetrics()
+	a.notifyNewProjects(jobID, started)
+
+	// Adoption dates, file changes and the snapshot come after the job is marked
+	// complete, so the dashboard shows the new projects while enrichment is still
+	// running. File changes go last among the lookups and stop at fileChangesRateReserve.
+	if _, err := t.RunPartial(ctx, tracker.ScopeAdoptions|tracker.ScopeFileChanges|tracker.ScopeSnapshot); err != nil {
+		log.Printf("Refresh job %d enrichment stopped: %v", jobID, err)
+	}
+	progress.flush()
+
+	log.Printf("Refresh job %d completed (source: %s): %d projects", jobID, source, len(res.Projects))
+	a.progress.publish(ProgressEvent{Type: "completed", JobID: jobID, Total: len(res.Projects)})
+}
+
+// failRefreshJob records the error that stopped a run. A run stopped by the
+// cancel handler, which already marked its job cancelled, is announced as cancelled.
+func (a *API) failRefreshJob(jobID int64, err error) {
+	if errors.Is(err, context.Canceled) {
+		a.progress.publish(ProgressEvent{Type: "cancelled", JobID: jobID, Error: cancelledByUser})
+		return
+	}
+	a.db.FailRefreshJob(jobID, github.Describe(err))
+	a.progress.publish(ProgressEvent{Type: "failed", JobID: jobID, Error: github.Describe(err)})
+}
+
+// dryRunResult is what a dry run would have saved, stored with its job
+type dryRunResult struct {
+	Projects    []dryRunProject `json:"projects"`
+	Added       []string        `json:"added"`
+	Removed     []string        `json:"removed"`
+	SoftDeleted []string        `json:"would_soft_delete"` // removed repos that no longer exist on GitHub
+}
+
+// dryRunProject is a project as a refresh would save it, without the fields only
+// the database fills in
+type dryRunProject struct {
+	RepoFullName    string   `json:"repo_full_name"`
+	GitHubURL       string   `json:"github_url"`
+	Stars           int      `json:"stars"`
+	Description     string   `json:"description"`
+	PrimaryLanguage string   `json:"primary_language"`
+	DockerfilePath  string   `json:"dockerfile_path"`
+	FileURL         string   `json:"file_url"`
+	SourceType      string   `json:"source_type"`
+	Registry        string   `json:"registry"`
+	MatchedQueries  []string `json:"matched_queries"`
+	Topics          []string `json:"topics"`
+	Archived        bool     `json:"archived"`
+	Fork            bool     `json:"fork"`
+	HealthGrade     string   `json:"health_grade"`
+	HealthScore     int      `json:"health_score"`
+}
+
+// runDryRun searches and fetches details like a refresh, but against a store that
+// keeps the projects, snapshot and other tracked data untouched, and stores what
+// would have been saved with the job instead. It leaves out the refresh metrics.
+func (a *API) runDryRun(ctx context.Context, jobID int64, progress *jobProgress) {
+	store := &dryRunStore{job: a.jobStore(jobID)}
+	res, err := a.newTracker(store, jobID, progress).RunPartial(ctx, tracker.ScopeDiscover)
+	progress.flush()
+	if err != nil {
+		log.Printf("Error in dry run job %d: %v", jobID, err)
+		a.failRefreshJob(jobID, err)
+		return
+	}
+
+	if err := a.saveDryRunResult(jobID, store, res); err != nil {
+		log.Printf("Error storing dry run job %d result: %v", jobID, err)
+		a.db.FailRefreshJob(jobID, "storing result: "+err.Error())
+		a.progress.publish(ProgressEvent{Type: "failed", JobID: jobID, Error: err.Error()})
+		return
+	}
+
+	if err := a.db.CompleteRefreshJob(jobID, len(res.Projects)); err != nil {
+		log.Printf("Error completing job: %v", err)
+	}
+	log.Printf("Dry run job %d completed: %d projects, %d added, %d removed", jobID, len(res.Projects), len(res.Added), len(res.Removed))
+	a.progress.publish(ProgressEvent{Type: "completed", JobID: jobID, Total: len(res.Projects)})
+}
+
+// saveDryRunResult stores the projects a dry run found, graded as a refresh would
+// save them, with its diff
+func (a *API) saveDryRunResult(jobID int64, store *dryRunStore, res *tracker.Result) error {
+	result := dryRunResult{
+		Projects:    make([]dryRunProject, len(res.Projects)),
+		Added:       append([]string{}, res.Added...),
+		Removed:     append([]string{}, res.Removed...),
+		SoftDeleted: append([]string{}, res.SoftDeleted...),
+	}
+	for i, p := range res.Projects {
+		project, err := store.job.project(p)
+		if err != nil {
+			return err
+		}
+		result.Projects[i] = dryRunProject{
+			RepoFullName:    p.RepoFullName,
+			GitHubURL:       p.GitHubURL,
+			Stars:           p.Stars,
+			Description:     p.Description,
+			PrimaryLanguage: p.PrimaryLanguage,
+			DockerfilePath:  p.FilePath,
+			FileURL:         p.FileURL,
+			SourceType:      p.SourceType,
+			Registry:        p.Registry,
+			MatchedQueries:  append([]string{}, p.MatchedQueries...),
+			Topics:          append([]string{}, p.Topics...),
+			Archived:        p.Archived,
+			Fork:            p.Fork,
+			HealthGrade:     project.HealthGrade,
+			HealthScore:     project.HealthScore,
+		}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return a.db.SetRefreshJobDryRunResult(jobID, data)
+}
+
+// UpdateProjectMetrics sets the project count and star gauges from the database
+func (a *API) UpdateProjectMetrics() {
+	total, stars, _, _, err := a.db.GetStats(a.starThresholds)
+	if err != nil {
+		log.Printf("Error updating project metrics: %v", err)
+		return
+	}
+	metrics.ProjectsTotal.Set(float64(total))
+	metrics.TotalStars.Set(float64(stars))
+}
+
+// UpdateRefreshMetrics sets the last successful refresh time from the stored jobs,
+// so the metric survives restarts
+func (a *API) UpdateRefreshMetrics() {
+	if t := a.GetLastRefreshTime(); t != nil {
+		metrics.LastRefreshSuccess.Set(float64(t.Unix()))
+	}
+}
+
+// jobStore returns the store a refresh job saves its results to
+func (a *API) jobStore(jobID int64) *jobStore {
+	return &jobStore{db: a.db, jobID: jobID, grading: a.healthRules, snapshotGap: a.snapshotGap, thresholds: a.starThresholds}
+}
+
+// newTracker returns the pipeline for a refresh job saving to store, reporting its
+// events to the log, progress subscribers and the job's stored progress
+func (a *API) newTracker(store tracker.Store, jobID int64, progress *jobProgress) *tracker.Tracker {
+	source := ghSource{client: a.ghClient, excluded: a.db.ExcludedRepos, searchStats: func(stats map[string]github.QueryStat) {
+		a.recordSearchQueryStats(jobID, stats)
+	}}
+	return tracker.New(store, source, a.searchQueries(), tracker.Options{
+		OnEvent: func(e tracker.Event) {
+			logRefreshEvent(jobID, e)
+			a.publishRefreshEvent(jobID, e)
+			if e.Kind == tracker.EventProgress {
+				progress.record(e.Stage, e.Current, e.Total)
+			}
+		},
+	})
+}
+
+// recordSearchQueryStats stores how each search query of a refresh job fared.
+// Failing to is logged rather than failing the refresh.
+func (a *API) recordSearchQueryStats(jobID int64, stats map[string]github.QueryStat) {
+	rows := make([]db.SearchQueryStat, 0, len(stats))
+	for name, st := range stats {
+		rows = append(rows, db.SearchQueryStat{
+			QueryName:      name,
+			Repos:          st.Repos,
+			UniqueRepos:    st.UniqueRepos,
+			Pages:          st.Pages,
+			DurationMS:     st.Duration.Milliseconds(),
+			HitGitHubLimit: st.HitGitHubLimit,
+		})
+	}
+	if err := a.db.RecordSearchQueryStats(jobID, rows); err != nil {
+		log.Printf("Error recording search query stats for job %d: %v", jobID, err)
+	}
+}
+
+// RunRefreshStages runs the given pipeline stages as a refresh job and waits for
+// them, for one-off runs like the smoke subcommand. The job is completed with the
+// number of projects found, or failed with the error that stopped the run.
+func (a *API) RunRefreshStages(ctx context.Context, source string, scope tracker.Scope) (int64, *tracker.Result, error) {
+	a.refreshMu.Lock()
+	if a.refreshRunning {
+		a.refreshMu.Unlock()
+		return 0, nil, errRefreshRunning
+	}
+	a.refreshRunning = true
+	a.refreshMu.Unlock()
+	defer func() {
+		a.refreshMu.Lock()
+		a.refreshRunning = false
+		a.refreshMu.Unlock()
+	}()
+
+	jobID, err := a.db.CreateRefreshJob(source)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := a.db.StartRefreshJob(jobID); err != nil {
+		return jobID, nil, err
+	}
+	log.Printf("Starting refresh job %d (source: %s)", jobID, source)
+	defer a.recordRefreshUsage(jobID, time.Now(), a.requestsUsed())
+
+	progress := &jobProgress{db: a.db, jobID: jobID}
+	res, err := a.newTracker(a.jobStore(jobID), jobID, progress).RunPartial(ctx, scope)
+	progress.flush()
+	a.cache.purge()
+	if err != nil {
+		a.db.FailRefreshJob(jobID, github.Describe(err))
+		return jobID, res, err
+	}
+	if err := a.db.CompleteRefreshJob(jobID, len(res.Projects)); err != nil {
+		return jobID, res, err
+	}
+	log.Printf("Refresh job %d completed (source: %s): %d projects", jobID, source, len(res.Projects))
+	return jobID, res, nil
+}
+
+// searchQueryer is implemented by GitHub clients configured with their own searches
+type searchQueryer interface {
+	SearchQueries() []github.SearchQuery
+}
+
+// searchQueries returns the tracker queries: the enabled stored ones, or while
+// none are stored the client's configured ones
+func (a *API) searchQueries() []tracker.Query {
+	var queries []tracker.Query
+	stored, err := a.db.ListSearchQueries()
+	if err != nil {
+		log.Printf("Error loading search queries, running the configured ones: %v", err)
+	}
+	if err == nil && len(stored) > 0 {
+		for _, q := range stored {
+			if q.Enabled {
+				queries = append(queries, tracker.Query{Name: q.Name, Query: q.Query, Registry: q.Registry})
+			}
+		}
+		return queries
+	}
+	for _, q := range a.defaultSearchQueries() {
+		queries = append(queries, tracker.Query{Name: q.Name, Query: q.Query, Registry: q.Registry})
+	}
+	return queries
+}
+
+// defaultSearchQueries returns the queries for the client's configured registries
+func (a *API) defaultSearchQueries() []github.SearchQuery {
+	if c, ok := a.ghClient.(searchQueryer); ok {
+		return c.SearchQueries()
+	}
+	return github.GetSearchQueries()
+}
+
+// logRefreshEvent logs the outcomes of a refresh job's pipeline
+func logRefreshEvent(jobID int64, e tracker.Event) {
+	switch e.Kind {
+	case tracker.EventDiff:
+		log.Printf("Refresh job %d diff: %d added, %d removed", jobID, len(e.Added), len(e.Removed))
+	case tracker.EventSoftDeleted:
+		log.Printf("Soft-deleted %s: repository no longer exists on GitHub", e.Repo)
+	case tracker.EventInactive:
+		if e.Count > 0 {
+			log.Printf("Marked %d projects inactive (no longer found)", e.Count)
+		}
+	case tracker.EventProgress:
+		if e.Stage == "adoptions" {
+			log.Printf("Fetching adoption info for %s (%d/%d)", e.Repo, e.Current, e.Total)
+		}
+	case tracker.EventAdoption:
+		log.Printf("Set adoption for %s: %s (%s)", e.Repo, e.Adoption.Date.Format("2006-01-02"), e.Adoption.CommitURL)
+	case tracker.EventFileChange:
+		if e.FileChange.Skipped != "" {
+			log.Printf("File of %s changed, diff skipped: %s", e.Repo, e.FileChange.Skipped)
+		} else {
+			log.Printf("File of %s changed registry references", e.Repo)
+		}
+	case tracker.EventSnapshot:
+		log.Printf("Recorded snapshot after refresh")
+	case tracker.EventError:
+		if e.Repo != "" {
+			log.Printf("Refresh job %d: error during %s for %s: %s", jobID, e.Stage, e.Repo, github.Describe(e.Err))
+		} else {
+			log.Printf("Refresh job %d: error during %s: %s", jobID, e.Stage, github.Describe(e.Err))
+		}
+	}
+}
+
+// progressInterval is the most often a job's progress is written to the database
+const progressInterval = time.Second
+
+// jobProgress persists a refresh job's progress for /api/refresh/status. Writes are
+// throttled to progressInterval, except that a new phase is always recorded.
+type jobProgress struct {
+	db    *db.DB
+	jobID int64
+
+	mu             sync.Mutex
+	phase          string
+	current, total int
+	written        time.Time
+	pending        bool // the latest values haven't been written
+}
+
+func (p *jobProgress) record(stage string, current, total int) {
+	// Per-query search events carry the repos found so far, not a position in a known total
+	if _, ok := strings.CutPrefix(stage, github.QueryDoneStage); ok {
+		stage, total = "searching", 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	newPhase := stage != p.phase
+	p.phase, p.current, p.total, p.pending = stage, current, total, true
+	if newPhase || time.Since(p.written) >= progressInterval {
+		p.write()
+	}
+}
+
+// flush writes progress that was held back by the throttle
+func (p *jobProgress) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending {
+		p.write()
+	}
+}
+
+func (p *jobProgress) write() {
+	if err := p.db.SetRefreshJobProgress(p.jobID, p.phase, p.current, p.total); err != nil {
+		log.Printf("Error recording progress of refresh job %d: %v", p.jobID, err)
+		return
+	}
+	p.written, p.pending = time.Now(), false
+}
+
+// requestCounter is implemented by GitHub clients that count their requests
+type requestCounter interface {
+	RequestsUsed() int
+}
+
+// requestsUsed returns how many requests the GitHub client has made, or 0 if it doesn't count them
+func (a *API) requestsUsed() int {
+	if c, ok := a.ghClient.(requestCounter); ok {
+		return c.RequestsUsed()
+	}
+	return 0
+}
+
+// recordRefreshUsage stores how long a job's run took since started and how many
+// GitHub requests it made beyond requestsBefore
+func (a *API) recordRefreshUsage(jobID int64, started time.Time, requestsBefore int) {
+	duration, requests := time.Since(started), a.requestsUsed()-requestsBefore
+	if err := a.db.SetRefreshJobUsage(jobID, duration, requests); err != nil {
+		log.Printf("Error recording usage of refresh job %d: %v", jobID, err)
+		return
+	}
+	log.Printf("Refresh job %d took %s and %d GitHub requests", jobID, duration.Round(time.Millisecond), requests)
+}
+
+// conditionalStatser is implemented by GitHub clients that make conditional requests
+type conditionalStatser interface {
+	ConditionalStats() github.ConditionalStats
+}
+
+// conditionalStats returns the GitHub client's ETag counters, or zeros if the
+// client doesn't make conditional requests
+func (a *API) conditionalStats() github.ConditionalStats {
+	if c, ok := a.ghClient.(conditionalStatser); ok {
+		return c.ConditionalStats()
+	}
+	return github.ConditionalStats{}
+}
+
+// coverageDriftWarnRatio is the share of a query's projects it may stop matching
+// before a refresh logs a warning about the query degrading
+const coverageDriftWarnRatio = 0.1
+
+// warnCoverageDrift warns when a query has stopped matching too many projects it used to find
+func (a *API) warnCoverageDrift() {
+	drift, err := a.db.GetCoverageDrift()
+	if err != nil {
+		log.Printf("Error computing coverage drift: %v", err)
+		return
+	}
+	for _, q := range drift.Queries {
+		if q.LostRatio > coverageDriftWarnRatio {
+			log.Printf("WARNING: query %q no longer matches %d of %d projects it used to find (%.0f%%)",
+				q.Query, q.Lost, q.Historical, q.LostRatio*100)
+		}
+	}
+}
+
+// ghSource adapts the GitHub client to tracker.Source, waiting out rate limits once per call
+type ghSource struct {
+	client github.GitHubClient
+	// excluded returns the repos Discover leaves out, so they are neither saved
+	// nor counted as added in the diff; may be nil
+	excluded func() (map[string]bool, error)
+	// searchStats receives how each query fared, even when discovery fails; may be nil
+	searchStats func(map[string]github.QueryStat)
+}
+
+func (s ghSource) Discover(ctx context.Context, queries []tracker.Query, progress func(stage string, current, total int)) ([]tracker.Project, error) {
+	searches := make([]github.SearchQuery, len(queries))
+	for i, q := range queries {
+		searches[i] = github.SearchQuery{Name: q.Name, Query: q.Query, Registry: q.Registry}
+	}
+	found, stats, err := s.client.FetchProjects(ctx, searches, progress)
+	if s.searchStats != nil && len(stats) > 0 {
+		s.searchStats(stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var excluded map[string]bool
+	if s.excluded != nil {
+		if excluded, err = s.excluded(); err != nil {
+			return nil, fmt.Errorf("getting excluded repos: %w", err)
+		}
+	}
+
+	projects := make([]tracker.Project, 0, len(found))
+	for _, p := range found {
+		if excluded[p.RepoFullName] {
+			continue
+		}
+		projects = append(projects, tracker.Project{
+			RepoFullName:    p.RepoFullName,
+			GitHubURL:       p.GitHubURL,
+			Stars:           p.Stars,
+			Description:     p.Description,
+			PrimaryLanguage: p.PrimaryLanguage,
+			FilePath:        p.DockerfilePath,
+			FileURL:         p.FileURL,
+			SourceType:      p.SourceType,
+			Registry:        p.Registry,
+			MatchedQueries:  p.MatchedQueries,
+			PushedAt:        p.PushedAt,
+			Archived:        p.Archived,
+			Fork:            p.Fork,
+			Topics:          p.Topics,
+		})
+	}
+	return projects, nil
+}
+
+func (s ghSource) Exists(ctx context.Context, repoFullName string) (bool, error) {
+	_, err := s.client.GetRepoDetails(ctx, repoFullName)
+	if github.WaitForRateLimit(ctx, err) {
+		_, err = s.client.GetRepoDetails(ctx, repoFullName)
+	}
+	var nf *github.NotFoundError
+	if errors.As(err, &nf) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s ghSource) FirstAdoption(ctx context.Context, repoFullName, filePath string) (*tracker.Adoption, error) {
+	info, err := s.client.GetFileFirstCommit(ctx, repoFullName, filePath)
+	if github.WaitForRateLimit(ctx, err) {
+		info, err = s.client.GetFileFirstCommit(ctx, repoFullName, filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracker.Adoption{Date: info.Date, CommitSHA: info.CommitSHA, CommitURL: info.CommitURL}, nil
+}
+
+// fileChangesRateReserve is the core API budget the file changes stage leaves
+// untouched, so watching files never starves discovery or adoption lookups
+const fileChangesRateReserve = 500
+
+// rateLimitStatuser is implemented by GitHub clients that track their rate-limit budgets
+type rateLimitStatuser interface {
+	RateLimitStatus() map[string]github.RateLimitState
+}
+
+// FileContent defers once the core budget is down to fileChangesRateReserve or
+// rate limited, rather than waiting like the core lookups do
+func (s ghSource) FileContent(ctx context.Context, repoFullName, filePath string) (*tracker.File, error) {
+	if c, ok := s.client.(rateLimitStatuser); ok {
+		if state, ok := c.RateLimitStatus()["core"]; ok && state.Remaining < fileChangesRateReserve {
+			return nil, tracker.ErrDeferred
+		}
+	}
+	fc, err := s.client.GetFileContent(ctx, repoFullName, filePath)
+	var rl *github.RateLimitError
+	if errors.As(err, &rl) {
+		return nil, tracker.ErrDeferred
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &tracker.File{Hash: fc.SHA}
+	switch {
+	case fc.Content == nil:
+		f.Skipped = tracker.SkippedTooLarge
+	case bytes.IndexByte(fc.Content, 0) >= 0 || !utf8.Valid(fc.Content):
+		f.Skipped = tracker.SkippedBinary
+	default:
+		f.Content = fc.Content
+	}
+	return f, nil
+}
+
+// jobStore adapts the database to tracker.Store for a single refresh job
+type jobStore struct {
+	db          *db.DB
+	jobID       int64
+	grading     *health.Rules
+	snapshotGap time.Duration
+	thresholds  db.StarThresholds
+}
+
+func (s *jobStore) TrackedRepos() ([]string, error) {
+	return s.db.GetAllRepoNames()
+}
+
+// SaveProject stores a project with its health grade
+func (s *jobStore) SaveProject(p tracker.Project) error {
+	project, err := s.project(p)
+	if err != nil {
+		return err
+	}
+	return s.db.UpsertProject(project)
+}
+
+// SaveProjects stores the projects of a run in one transaction
+func (s *jobStore) SaveProjects(ps []tracker.Project) error {
+	projects := make([]*db.Project, len(ps))
+	for i, p := range ps {
+		project, err := s.project(p)
+		if err != nil {
+			return err
+		}
+		projects[i] = project
+	}
+	return s.db.UpsertProjects(projects)
+}
+
+// project converts a found project for storage and grades it. The grade is
+// measured from now, which matches the last_seen_at the upsert records.
+func (s *jobStore) project(p tracker.Project) (*db.Project, error) {
+	grade := s.grading.Grade(health.Signals{
+		PushedAt: p.PushedAt,
+		Archived: p.Archived,
+		Fork:     p.Fork,
+		SeenAt:   time.Now(),
+	})
+	factors, err := json.Marshal(grade.Factors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.Project{
+		RepoFullName:    p.RepoFullName,
+		GitHubURL:       p.GitHubURL,
+		Stars:           p.Stars,
+		Description:     p.Description,
+		PrimaryLanguage: p.PrimaryLanguage,
+		DockerfilePath:  p.FilePath,
+		FileURL:         p.FileURL,
+		SourceType:      p.SourceType,
+		Registry:        p.Registry,
+		PushedAt:        p.PushedAt,
+		Archived:        p.Archived,
+		Fork:            p.Fork,
+		Topics:          p.Topics,
+		HealthGrade:     grade.Grade,
+		HealthScore:     grade.Score,
+		HealthFactors:   factors,
+	}, nil
+}
+
+func (s *jobStore) RecordDiff(added, removed []string) error {
+	return s.db.RecordRefreshDiff(s.jobID, added, removed)
+}
+
+func (s *jobStore) RecordQueryMatches(matches map[string][]string) error {
+	return s.db.RecordQueryMatches(s.jobID, matches)
+}
+
+func (s *jobStore) SoftDelete(repoFullName string) error {
+	id, err := s.db.GetProjectID(repoFullName)
+	if err != nil {
+		return err
+	}
+	return s.db.SoftDeleteProject(id)
+}
+
+func (s *jobStore) MarkInactive(before time.Time) (int, error) {
+	return s.db.MarkInactiveProjects(before)
+}
+
+func (s *jobStore) PendingAdoptions() ([]tracker.PendingAdoption, error) {
+	projects, err := s.db.GetProjectsWithoutAdoptionDate()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]tracker.PendingAdoption, len(projects))
+	for i, p := range projects {
+		pending[i] = tracker.PendingAdoption{ID: p.ID, RepoFullName: p.RepoFullName, FilePath: p.DockerfilePath}
+	}
+	return pending, nil
+}
+
+func (s *jobStore) SaveAdoption(id int64, a tracker.Adoption) error {
+	return s.db.UpdateProjectAdoption(id, a.Date, a.CommitSHA, a.CommitURL)
+}
+
+func (s *jobStore) RecordSnapshot() error {
+	written, err := s.db.RecordSnapshotIfChanged(s.snapshotGap, s.thresholds)
+	if err != nil {
+		return err
+	}
+	if !written {
+		log.Printf("Refresh job %d: skipped snapshot, totals unchanged or last one under %s old", s.jobID, s.snapshotGap)
+		return tracker.ErrSnapshotSkipped
+	}
+	return nil
+}
+
+func (s *jobStore) WatchedFiles() ([]tracker.WatchedFile, error) {
+	files, err := s.db.GetWatchedFiles()
+	if err != nil {
+		return nil, err
+	}
+	watched := make([]tracker.WatchedFile, len(files))
+	for i, f := range files {
+		// Files read before images were extracted have the lines for them already
+		if len(f.Images) == 0 && len(f.Lines) > 0 {
+			if images := github.ParseImages(strings.Join(f.Lines, "\n"), f.Registry); len(images) > 0 {
+				if err := s.db.SetProjectImages(f.ProjectID, images); err != nil {
+					return nil, err
+				}
+			}
+		}
+		watched[i] = tracker.WatchedFile{
+			ID:           f.ProjectID,
+			RepoFullName: f.RepoFullName,
+			FilePath:     f.FilePath,
+			Match:        f.Registry,
+			Hash:         f.Hash,
+			Lines:        f.Lines,
+		}
+	}
+	return watched, nil
+}
+
+func (s *jobStore) SaveFile(f tracker.WatchedFile, change *tracker.FileChange) error {
+	var c *db.FileChange
+	if change != nil {
+		c = &db.FileChange{
+			FilePath: f.FilePath,
+			OldHash:  change.OldHash,
+			NewHash:  change.NewHash,
+			Diff:     change.Diff,
+			Skipped:  change.Skipped,
+		}
+	}
+	images := github.ParseImages(strings.Join(f.Lines, "\n"), f.Match)
+	return s.db.SaveFileVersion(f.ID, f.Hash, f.Lines, images, s.jobID, c)
+}
+
+// dryRunStore is the store of a dry-run job. It reads the tracked repos and
+// records the job's diff, which belongs to the job alone, but drops every write
+// to projects, query matches and snapshots.
+type dryRunStore struct {
+	job *jobStore
+}
+
+func (s *dryRunStore) TrackedRepos() ([]string, error) {
+	return s.job.TrackedRepos()
+}
+
+func (s *dryRunStore) SaveProject(p tracker.Project) error {
+	return nil
+}
+
+func (s *dryRunStore) RecordDiff(added, removed []string) error {
+	return s.job.RecordDiff(added, removed)
+}
+
+func (s *dryRunStore) RecordQueryMatches(matches map[string][]string) error {
+	return nil
+}
+
+func (s *dryRunStore) SoftDelete(repoFullName string) error {
+	return nil
+}
+
+// MarkInactive reports nothing flagged: with no projects saved, every project
+// would look unseen
+func (s *dryRunStore) MarkInactive(before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *dryRunStore) PendingAdoptions() ([]tracker.PendingAdoption, error) {
+	return nil, nil
+}
+
+func (s *dryRunStore) SaveAdoption(id int64, a tracker.Adoption) error {
+	return nil
+}
+
+func (s *dryRunStore) RecordSnapshot() error {
+	return tracker.ErrSnapshotSkipped
+}
@@ -0,0 +1,410 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/internal/github/mock"
+)
+
+// waitForRefresh waits until no refresh holds the lock
+func waitForRefresh(t *testing.T, a *API) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.refreshMu.Lock()
+		running := a.refreshRunning
+		a.refreshMu.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh still running after 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleRefreshWithMockClient(t *testing.T) {
+	adopted := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	client := &mock.MockClient{
+		Projects: []github.Project{{
+			RepoFullName:   "acme/app",
+			GitHubURL:      "https://github.com/acme/app",
+			Stars:          42,
+			DockerfilePath: "Dockerfile",
+			SourceType:     "Dockerfile",
+		}},
+		Adoptions: map[string]*github.AdoptionInfo{"acme/app": {Date: adopted, CommitSHA: "abc123"}},
+	}
+	a, mux := newTestAPI(t, client)
+
+	w := do(t, mux, http.MethodPost, "/api/refresh", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /api/refresh: status %d, body %s", w.Code, w.Body)
+	}
+	var started struct {
+		Success bool  `json:"success"`
+		JobID   int64 `json:"job_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || !started.Success || started.JobID == 0 {
+		t.Fatalf("body %s: %v", w.Body, err)
+	}
+	waitForRefresh(t, a)
+
+	job, err := a.db.GetLatestRefreshJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != started.JobID || job.Status != "completed" || job.ProjectsFound != 1 {
+		t.Errorf("job %d status %q with %d projects, want job %d completed with 1", job.ID, job.Status, job.ProjectsFound, started.JobID)
+	}
+
+	w = do(t, mux, http.MethodGet, "/api/projects", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/projects: status %d, body %s", w.Code, w.Body)
+	}
+	var projects []struct {
+		RepoFullName string     `json:"repo_full_name"`
+		Stars        int        `json:"stars"`
+		AdoptedAt    *time.Time `json:"adopted_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &projects); err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 {
+		t.Fatalf("got %d projects, want 1", len(projects))
+	}
+	if p := projects[0]; p.RepoFullName != "acme/app" || p.Stars != 42 || p.AdoptedAt == nil || !p.AdoptedAt.Equal(adopted) {
+		t.Errorf("project %s stars %d adopted %v, want acme/app with 42 adopted %v", p.RepoFullName, p.Stars, p.AdoptedAt, adopted)
+	}
+
+	var fetched bool
+	for _, c := range client.Calls() {
+		fetched = fetched || c.Method == "FetchProjects"
+	}
+	if !fetched {
+		t.Errorf("refresh didn't search through the client; calls %v", client.Calls())
+	}
+}
+
+func TestRefreshSkipsExcludedRepos(t *testing.T) {
+	client := &mock.MockClient{Projects: []github.Project{
+		{RepoFullName: "acme/app", GitHubURL: "https://github.com/acme/app", SourceType: "Dockerfile", MatchedQueries: []string{"Dockerfile"}},
+		{RepoFullName: "acme/mirror", GitHubURL: "https://github.com/acme/mirror", SourceType: "Dockerfile", MatchedQueries: []string{"Dockerfile"}},
+	}}
+	a, _ := newTestAPI(t, client)
+	if _, err := a.db.ExcludeRepo("acme/mirror", "docs mirror"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second run checks that the excluded repo isn't added again on every refresh
+	for run := 1; run <= 2; run++ {
+		jobID, err := a.startRefresh("manual", 0)
+		if err != nil {
+			t.Fatalf("run %d: startRefresh: %v", run, err)
+		}
+		waitForRefresh(t, a)
+		diff, err := a.db.GetRefreshDiff(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, repo := range diff.Added {
+			if repo == "acme/mirror" {
+				t.Errorf("run %d: excluded repo in the diff: %+v", run, diff)
+			}
+		}
+	}
+
+	var diffs int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM refresh_diffs WHERE repo_full_name = 'acme/mirror'`).Scan(&diffs); err != nil || diffs != 0 {
+		t.Errorf("%d refresh_diffs rows for the excluded repo (%v), want 0", diffs, err)
+	}
+	if matches, err := a.db.GetQueryMatches("acme/mirror"); err != nil || len(matches) != 0 {
+		t.Errorf("query matches of the excluded repo: %v, %v; want none", matches, err)
+	}
+	if id, err := a.db.GetProjectID("acme/mirror"); err == nil {
+		t.Errorf("excluded repo saved as project %d", id)
+	}
+}
+
+// blockingClient holds FetchProjects until release is closed, even once its
+// context is done, so a test can act while a run is in progress or unwinding
+type blockingClient struct {
+	*mock.MockClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingClient() *blockingClient {
+	return &blockingClient{MockClient: &mock.MockClient{}, started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (c *blockingClient) FetchProjects(ctx context.Context, queries []github.SearchQuery, progressFn func(string, int, int)) ([]github.Project, map[string]github.QueryStat, error) {
+	select {
+	case c.started <- struct{}{}:
+	default:
+	}
+	<-c.release
+	return nil, nil, ctx.Err()
+}
+
+func TestRefreshCancelHoldsLockUntilRunUnwinds(t *testing.T) {
+	client := newBlockingClient()
+	a, mux := newTestAPI(t, client)
+	events := a.progress.subscribe()
+	defer a.progress.unsubscribe(events)
+
+	if !a.TriggerRefresh("manual") {
+		t.Fatal("TriggerRefresh refused to start")
+	}
+	<-client.started
+
+	if w := do(t, mux, http.MethodPost, "/api/refresh/cancel", ""); w.Code != http.StatusOK {
+		t.Fatalf("cancel: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(t, mux, http.MethodPost, "/api/refresh/cancel", ""); w.Code != http.StatusConflict {
+		t.Errorf("second cancel: status %d, want 409", w.Code)
+	}
+	// The cancelled run hasn't returned yet, so nothing may start alongside it
+	if a.TriggerRefresh("manual") {
+		t.Fatal("TriggerRefresh started a refresh while the cancelled one was unwinding")
+	}
+	close(client.release)
+
+	var last ProgressEvent
+	for !last.terminal() {
+		select {
+		case data := <-events:
+			if err := json.Unmarshal([]byte(data), &last); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no terminal progress event")
+		}
+	}
+	waitForRefresh(t, a)
+
+	job, err := a.db.GetLatestRefreshJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Type != "cancelled" || last.JobID != job.ID || last.Error != cancelledByUser {
+		t.Errorf("terminal event = %+v, want cancelled for job %d", last, job.ID)
+	}
+	if job.Status != "cancelled" || job.ErrorMessage != cancelledByUser {
+		t.Errorf("job status %q, error %q; want cancelled by user", job.Status, job.ErrorMessage)
+	}
+}
+
+func TestHandleRefreshConflictAndCooldown(t *testing.T) {
+	client := newBlockingClient()
+	a, mux := newTestAPI(t, client)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	w := do(t, mux, http.MethodPost, "/api/refresh", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("first POST: status %d, body %s", w.Code, w.Body)
+	}
+	var started struct {
+		JobID int64 `json:"job_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &started)
+	<-client.started
+
+	// A double-click or proxy replay while the job runs, forced or not
+	for _, target := range []string{"/api/refresh", "/api/refresh?force=true"} {
+		w = do(t, mux, http.MethodPost, target, "")
+		if w.Code != http.StatusConflict {
+			t.Fatalf("POST %s while running: status %d, want 409", target, w.Code)
+		}
+		var running struct {
+			Success   bool       `json:"success"`
+			JobID     int64      `json:"job_id"`
+			StartedAt *time.Time `json:"started_at"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &running); err != nil {
+			t.Fatal(err)
+		}
+		if running.Success || running.JobID != started.JobID || running.StartedAt == nil {
+			t.Errorf("POST %s while running: body %s, want the running job %d", target, w.Body, started.JobID)
+		}
+	}
+	close(client.release)
+	waitForRefresh(t, a)
+
+	// Within the cooldown a replay is refused without creating a job
+	w = do(t, mux, http.MethodPost, "/api/refresh", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("POST within cooldown: status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" && got != "29" {
+		t.Errorf("Retry-After %q, want about 30", got)
+	}
+	if jobs, total, err := a.db.ListRefreshJobs(10, 0); err != nil || total != 1 {
+		t.Errorf("%d jobs (%v, %v) after refused triggers, want 1", total, jobs, err)
+	}
+
+	w = do(t, mux, http.MethodPost, "/api/refresh?force=true", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("forced POST within cooldown: status %d, body %s", w.Code, w.Body)
+	}
+	waitForRefresh(t, a)
+
+	now = now.Add(minRefreshInterval + time.Second)
+	if w = do(t, mux, http.MethodPost, "/api/refresh", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("POST after cooldown: status %d, body %s", w.Code, w.Body)
+	}
+	waitForRefresh(t, a)
+}
+
+func TestCleanupRefreshJobs(t *testing.T) {
+	a, _ := newTestAPI(t, nil)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	pending, err := a.db.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := a.db.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.StartRefreshJob(running); err != nil {
+		t.Fatal(err)
+	}
+	current, err := a.db.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.refreshJobID = current
+
+	status := func(id int64) string {
+		t.Helper()
+		job, err := a.db.GetRefreshJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil {
+			return "deleted"
+		}
+		return job.Status
+	}
+
+	// Young jobs are left alone
+	now = now.Add(orphanedJobAge / 2)
+	a.CleanupRefreshJobs(false)
+	if s := status(pending); s != "pending" {
+		t.Errorf("young pending job is %s", s)
+	}
+
+	now = now.Add(refreshTimeout)
+	a.CleanupRefreshJobs(false)
+	if s := status(pending); s != "deleted" {
+		t.Errorf("orphaned pending job is %s, want deleted", s)
+	}
+	if s := status(running); s != "failed" {
+		t.Errorf("stale running job is %s, want failed", s)
+	}
+	if s := status(current); s != "pending" {
+		t.Errorf("current job is %s, want it kept", s)
+	}
+	if job, _ := a.db.GetRefreshJob(running); job != nil && job.ErrorMessage != "lost track of running job" {
+		t.Errorf("stale job error %q", job.ErrorMessage)
+	}
+}
+
+func TestCleanupRefreshJobsAtStartup(t *testing.T) {
+	a, _ := newTestAPI(t, nil)
+	pending, err := a.db.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := a.db.CreateRefreshJob("scheduled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.StartRefreshJob(running); err != nil {
+		t.Fatal(err)
+	}
+
+	a.CleanupRefreshJobs(true)
+	if job, _ := a.db.GetRefreshJob(pending); job != nil {
+		t.Errorf("pending job left at startup: %s", job.Status)
+	}
+	if job, _ := a.db.GetRefreshJob(running); job == nil || job.Status != "failed" || job.ErrorMessage != "process restarted" {
+		t.Errorf("running job at startup: %+v, want failed by restart", job)
+	}
+}
+
+func TestHandleRefreshContract(t *testing.T) {
+	client := newBlockingClient()
+	a, mux := newTestAPI(t, client)
+
+	decode := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		t.Helper()
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q, want application/json", ct)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("body %q: %v", w.Body, err)
+		}
+		return body
+	}
+
+	if w := do(t, mux, http.MethodGet, "/api/refresh", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", w.Code)
+	}
+
+	w := do(t, mux, http.MethodPost, "/api/refresh", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST: status %d, want 202", w.Code)
+	}
+	body := decode(w)
+	jobID, _ := body["job_id"].(float64)
+	if body["success"] != true || jobID == 0 || body["message"] != "Refresh started" {
+		t.Errorf("202 body %s", w.Body)
+	}
+	<-client.started
+
+	w = do(t, mux, http.MethodPost, "/api/refresh", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("POST while running: status %d, want 409", w.Code)
+	}
+	body = decode(w)
+	if body["success"] != false || body["job_id"] != jobID || body["started_at"] == nil || body["message"] != "Refresh already in progress" {
+		t.Errorf("409 body %s, want the running job %v", w.Body, jobID)
+	}
+
+	close(client.release)
+	waitForRefresh(t, a)
+
+	// A refresh that hasn't created its job yet doesn't report the previous one
+	a.refreshMu.Lock()
+	a.refreshRunning = true
+	a.refreshMu.Unlock()
+	w = do(t, mux, http.MethodPost, "/api/refresh", "")
+	if body = decode(w); w.Code != http.StatusConflict || body["job_id"] != nil || body["started_at"] != nil {
+		t.Errorf("409 before the job exists: status %d, body %s", w.Code, w.Body)
+	}
+	a.refreshMu.Lock()
+	a.refreshRunning = false
+	a.refreshMu.Unlock()
+
+	// Cancelling with nothing running is a conflict too
+	w = do(t, mux, http.MethodPost, "/api/refresh/cancel", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("cancel while idle: status %d, want 409", w.Code)
+	}
+	if body = decode(w); body["success"] != false || body["message"] != "no refresh running" {
+		t.Errorf("cancel 409 body %s", w.Body)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/github"
+)
+
+// maxSearchQueryLength is the longest query GitHub code search accepts
+const maxSearchQueryLength = 256
+
+// SeedSearchQueries stores the client's configured search queries when none are
+// stored yet, so later changes are made through /api/search-queries. Until then
+// refreshes run the configured queries.
+func (a *API) SeedSearchQueries() error {
+	defaults := a.defaultSearchQueries()
+	queries := make([]db.SearchQuery, len(defaults))
+	for i, q := range defaults {
+		queries[i] = db.SearchQuery{Name: q.Name, Query: q.Query, Registry: q.Registry}
+	}
+	n, err := a.db.SeedSearchQueries(queries)
+	if n > 0 {
+		log.Printf("Stored %d built-in search queries", n)
+	}
+	return err
+}
+
+// handleSearchQueries lists the stored search queries, or adds one on POST
+func (a *API) handleSearchQueries(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		queries, err := a.db.ListSearchQueries()
+		if err != nil {
+			log.Printf("Error listing search queries: %v", err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		a.writeJSON(w, r, http.StatusOK, queries)
+
+	case http.MethodPost:
+		var req struct {
+			Name     string `json:"name"`
+			Query    string `json:"query"`
+			Registry string `json:"registry"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		req.Name, req.Query = strings.TrimSpace(req.Name), strings.TrimSpace(req.Query)
+		if req.Registry = strings.TrimSpace(req.Registry); req.Registry == "" {
+			req.Registry = github.DefaultRegistry
+		}
+		if req.Name == "" || req.Query == "" {
+			a.apiError(w, http.StatusBadRequest, "name and query are required", nil)
+			return
+		}
+		if len(req.Query) > maxSearchQueryLength {
+			a.apiError(w, http.StatusBadRequest, fmt.Sprintf("query must be at most %d characters", maxSearchQueryLength), nil)
+			return
+		}
+
+		q, err := a.db.AddSearchQuery(req.Name, req.Query, req.Registry)
+		if errors.Is(err, db.ErrSearchQueryExists) {
+			a.apiError(w, http.StatusConflict, "A search query with that name already exists", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Error adding search query: %v", err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		log.Printf("Added search query %d (%s) for %s", q.ID, q.Name, requestCaller(r).name)
+		a.writeJSON(w, r, http.StatusCreated, q)
+
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// handleSearchQuery enables or disables a stored search query on PATCH, or
+// removes it on DELETE. The last enabled query can't be disabled or removed,
+// since a refresh without queries would mark every project inactive.
+func (a *API) handleSearchQuery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid search query id", err)
+		return
+	}
+
+	var enabled bool
+	switch r.Method {
+	case http.MethodPatch:
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		if req.Enabled == nil {
+			a.apiError(w, http.StatusBadRequest, "enabled is required", nil)
+			return
+		}
+		enabled = *req.Enabled
+	case http.MethodDelete:
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	if !enabled {
+		last, err := a.lastEnabledSearchQuery(id)
+		if err != nil {
+			log.Printf("Error listing search queries: %v", err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		if last {
+			a.apiError(w, http.StatusConflict, "At least one search query must stay enabled", nil)
+			return
+		}
+	}
+
+	var found bool
+	action := "removed"
+	if r.Method == http.MethodDelete {
+		found, err = a.db.RemoveSearchQuery(id)
+	} else {
+		found, err = a.db.ToggleSearchQuery(id, enabled)
+		action = "disabled"
+		if enabled {
+			action = "enabled"
+		}
+	}
+	if err != nil {
+		log.Printf("Error updating search query %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !found {
+		a.apiError(w, http.StatusNotFound, "Search query not found", nil)
+		return
+	}
+	log.Printf("Search query %d %s for %s", id, action, requestCaller(r).name)
+	a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"id":      id,
+	})
+}
+
+// lastEnabledSearchQuery reports whether id is the only enabled stored query
+func (a *API) lastEnabledSearchQuery(id int64) (bool, error) {
+	queries, err := a.db.ListSearchQueries()
+	if err != nil {
+		return false, err
+	}
+	last := false
+	for _, q := range queries {
+		if q.Enabled && q.ID != id {
+			return false, nil
+		}
+		if q.Enabled {
+			last = true
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/github"
+	"dhi-oss-usage/pkg/tracker"
+)
+
+// submitTimeout bounds the GitHub lookups of a project submission
+const submitTimeout = 30 * time.Second
+
+// repoNamePattern matches GitHub "owner/name" repo names
+var repoNamePattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})/[A-Za-z0-9._-]{1,100}$`)
+
+// projectSubmission is the body of POST /api/projects
+type projectSubmission struct {
+	RepoFullName string `json:"repo_full_name"`
+	FilePath     string `json:"file_path"` // optional; checked for the registry when set
+	Registry     string `json:"registry"`  // optional; github.DefaultRegistry when empty
+}
+
+// handleSubmitProject adds a repo the searches miss, e.g. one referencing the
+// registry from a Makefile. The repo must exist on GitHub and, when a file path
+// is given, the file must mention the registry.
+func (a *API) handleSubmitProject(w http.ResponseWriter, r *http.Request) {
+	var req projectSubmission
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	req.RepoFullName = strings.TrimSpace(req.RepoFullName)
+	req.FilePath = strings.Trim(strings.TrimSpace(req.FilePath), "/")
+	if req.Registry = strings.TrimSpace(req.Registry); req.Registry == "" {
+		req.Registry = github.DefaultRegistry
+	}
+	if !repoNamePattern.MatchString(req.RepoFullName) {
+		a.apiError(w, http.StatusBadRequest, "repo_full_name must look like owner/name", nil)
+		return
+	}
+	if strings.Contains(req.FilePath, "..") {
+		a.apiError(w, http.StatusBadRequest, "Invalid file_path", nil)
+		return
+	}
+
+	if tracked, err := a.db.GetProjectByRepoName(req.RepoFullName); err != nil {
+		log.Printf("Error looking up project %s: %v", req.RepoFullName, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	} else if tracked != nil {
+		a.apiError(w, http.StatusConflict, "Project is already tracked", nil)
+		return
+	}
+	if suppressed, err := a.db.IsSuppressed(req.RepoFullName); err != nil {
+		log.Printf("Error checking suppression of %s: %v", req.RepoFullName, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	} else if suppressed {
+		a.apiError(w, http.StatusConflict, "Project was removed by an admin", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), submitTimeout)
+	defer cancel()
+	details, err := a.ghClient.GetRepoDetails(ctx, req.RepoFullName)
+	if err != nil {
+		a.submissionLookupError(w, "Repository not found on GitHub", err)
+		return
+	}
+	// GitHub answers for renamed repos, and names in another case, with the stored name
+	if details.FullName != req.RepoFullName {
+		if tracked, err := a.db.GetProjectByRepoName(details.FullName); err == nil && tracked != nil {
+			a.apiError(w, http.StatusConflict, fmt.Sprintf("Project is already tracked as %s", details.FullName), nil)
+			return
+		}
+		if suppressed, err := a.db.IsSuppressed(details.FullName); err == nil && suppressed {
+			a.apiError(w, http.StatusConflict, fmt.Sprintf("Project %s was removed by an admin", details.FullName), nil)
+			return
+		}
+	}
+
+	p := tracker.Project{
+		RepoFullName:    details.FullName,
+		GitHubURL:       details.HTMLURL,
+		Stars:           details.StargazersCount,
+		Description:     details.Description,
+		PrimaryLanguage: details.Language,
+		SourceType:      db.SourceTypeManual,
+		Registry:        req.Registry,
+		PushedAt:        details.PushedAt,
+		Archived:        details.Archived,
+		Fork:            details.Fork,
+		Topics:          details.Topics,
+	}
+	if req.FilePath != "" {
+		file, err := a.ghClient.GetFileContent(ctx, details.FullName, req.FilePath)
+		if err != nil {
+			a.submissionLookupError(w, "File not found in the repository", err)
+			return
+		}
+		if file.Content == nil {
+			a.apiError(w, http.StatusUnprocessableEntity, "File is too large to check for the registry", nil)
+			return
+		}
+		if !bytes.Contains(file.Content, []byte(req.Registry)) {
+			a.apiError(w, http.StatusUnprocessableEntity, fmt.Sprintf("File doesn't mention %s", req.Registry), nil)
+			return
+		}
+		p.FilePath = req.FilePath
+		p.FileURL = details.HTMLURL + "/blob/HEAD/" + req.FilePath
+	}
+
+	project, err := a.jobStore(0).project(p)
+	if err != nil {
+		log.Printf("Error grading submitted project %s: %v", p.RepoFullName, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if err := a.db.UpsertProject(project); err != nil {
+		log.Printf("Error saving submitted project %s: %v", p.RepoFullName, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	a.cache.purge()
+	log.Printf("Added submitted project %s for %s", p.RepoFullName, requestCaller(r).name)
+
+	saved, err := a.db.GetProjectByRepoName(p.RepoFullName)
+	if err != nil || saved == nil {
+		log.Printf("Error reading back submitted project %s: %v", p.RepoFullName, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	saved.Ecosystem = a.ecosystems.Of(saved.PrimaryLanguage)
+	a.writeJSON(w, r, http.StatusCreated, saved)
+}
+
+// submissionLookupError answers a failed GitHub lookup of a submission: 422 when
+// GitHub doesn't have it, 502 when GitHub couldn't be asked
+func (a *API) submissionLookupError(w http.ResponseWriter, notFound string, err error) {
+	var nf *github.NotFoundError
+	if errors.As(err, &nf) {
+		a.apiError(w, http.StatusUnprocessableEntity, notFound, nil)
+		return
+	}
+	log.Printf("Error checking project submission on GitHub: %v", err)
+	a.apiError(w, http.StatusBadGateway, "GitHub lookup failed", err)
+}
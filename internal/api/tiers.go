@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tier is the access level of an API caller
+type Tier string
+
+const (
+	TierAnonymous Tier = "anonymous" // no API key
+	TierPartner   Tier = "partner"   // higher rate limit and extra response fields
+	TierAdmin     Tier = "admin"     // unlimited; may refresh and manage keys
+)
+
+// tierRank orders tiers from least to most privileged
+var tierRank = map[Tier]int{TierAnonymous: 0, TierPartner: 1, TierAdmin: 2}
+
+// atLeast reports whether t has every privilege of min
+func (t Tier) atLeast(min Tier) bool {
+	return tierRank[t] >= tierRank[min]
+}
+
+// tierRateLimits is the number of requests each tier may make per tierRateWindow.
+// Tiers without an entry are unlimited.
+var tierRateLimits = map[Tier]int{
+	TierAnonymous: 300,
+	TierPartner:   3000,
+}
+
+const tierRateWindow = time.Minute
+
+// caller is who made a request, as resolved by tierMiddleware
+type caller struct {
+	tier  Tier
+	keyID int64  // stored key ID; 0 for anonymous callers and API_KEYS keys
+	name  string // key name, for logs
+}
+
+type callerKey struct{}
+
+// rateLimitKey is what the caller's requests are counted under: the stored key,
+// or the client IP for anonymous callers
+func (c caller) rateLimitKey(r *http.Request) string {
+	if c.keyID != 0 {
+		return fmt.Sprintf("key:%d", c.keyID)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// requestCaller returns the caller of a request, anonymous if tierMiddleware didn't run
+func requestCaller(r *http.Request) caller {
+	if c, ok := r.Context().Value(callerKey{}).(caller); ok {
+		return c
+	}
+	return caller{tier: TierAnonymous, name: "anonymous"}
+}
+
+// tierMiddleware resolves the caller's tier from their API key, counts the request
+// against the key and applies the tier's rate limit. Keys from WithAPIKeys are admin
+// keys; other keys are looked up in the database. An unknown or revoked key is
+// rejected with 401 rather than downgraded, so a misconfigured client notices.
+// With SetRequireAPIKey, requests without a key are rejected with 401 too.
+func (a *API) tierMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := caller{tier: TierAnonymous, name: "anonymous"}
+		if key := requestAPIKey(r); key != "" {
+			if a.validAPIKey(key) {
+				c = caller{tier: TierAdmin, name: "API_KEYS"}
+			} else {
+				stored, err := a.db.GetAPIKeyByHash(hashAPIKey(key))
+				if err != nil {
+					a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+					return
+				}
+				if stored == nil {
+					log.Printf("Rejected unknown API key for %s %s from %s", r.Method, r.URL.Path, clientIP(r))
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+					a.apiError(w, http.StatusUnauthorized, "Invalid API key", nil)
+					return
+				}
+				c = caller{tier: Tier(stored.Tier), keyID: stored.ID, name: stored.Name}
+				if err := a.db.RecordAPIKeyUse(stored.ID); err != nil {
+					log.Printf("Error recording use of API key %d: %v", stored.ID, err)
+				}
+			}
+		}
+
+		if a.requireKey && c.tier == TierAnonymous {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			a.apiError(w, http.StatusUnauthorized, "API key required", nil)
+			return
+		}
+
+		if limiter := a.tierLimiters[c.tier]; limiter != nil {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.limit))
+			if !limiter.allow(c.rateLimitKey(r), 1) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(tierRateWindow.Seconds())))
+				a.apiError(w, http.StatusTooManyRequests, "Rate limit exceeded", nil)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, c)))
+	})
+}
+
+// adminMiddleware rejects requests not made with an admin key
+func (a *API) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := requestCaller(r)
+		if c.tier.atLeast(TierAdmin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.tier == TierAnonymous {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			a.apiError(w, http.StatusUnauthorized, "Missing or invalid API key", nil)
+			return
+		}
+		a.apiError(w, http.StatusForbidden, "Admin API key required", nil)
+	})
+}
+
+// newAPIKey returns a random key for a caller to present
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "dhi_" + hex.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the stored form of a key. Keys are random, so an unsalted
+// hash is enough to keep a leaked database from exposing them.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleKeys lists API keys with their usage (GET) or creates one (POST).
+// The new key is only ever returned in the create response.
+func (a *API) handleKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := a.db.ListAPIKeys()
+		if err != nil {
+			log.Printf("Error listing API keys: %v", err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		a.writeJSON(w, r, http.StatusOK, keys)
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+			Tier Tier   `json:"tier"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			a.apiError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			a.apiError(w, http.StatusBadRequest, "name is required", nil)
+			return
+		}
+		if req.Tier != TierPartner && req.Tier != TierAdmin {
+			a.apiError(w, http.StatusBadRequest, fmt.Sprintf("tier must be %q or %q", TierPartner, TierAdmin), nil)
+			return
+		}
+
+		key, err := newAPIKey()
+		if err != nil {
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		stored, err := a.db.CreateAPIKey(req.Name, string(req.Tier), hashAPIKey(key))
+		if err != nil {
+			log.Printf("Error creating API key: %v", err)
+			a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		log.Printf("Created %s API key %d (%s) for %s", stored.Tier, stored.ID, stored.Name, requestCaller(r).name)
+		a.writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+			"key":     key,
+			"api_key": stored,
+		})
+
+	default:
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// handleKey revokes an API key (DELETE /api/keys/{id})
+func (a *API) handleKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.apiError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, "Invalid key id", err)
+		return
+	}
+
+	revoked, err := a.db.RevokeAPIKey(id)
+	if err != nil {
+		log.Printf("Error revoking API key %d: %v", id, err)
+		a.apiError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	if !revoked {
+		a.apiError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+	log.Printf("Revoked API key %d for %s", id, requestCaller(r).name)
+	a.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"id":      id,
+	})
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"dhi-oss-usage/internal/db"
+)
+
+// addTestKey stores an API key of the tier and returns it
+func addTestKey(t *testing.T, a *API, name string, tier Tier) string {
+	t.Helper()
+	key, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.CreateAPIKey(name, string(tier), hashAPIKey(key)); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return key
+}
+
+func TestProjectFieldsByTier(t *testing.T) {
+	a, mux := newTestAPI(t, nil, WithAPIKey("admin-key"))
+	addProject(t, a, db.Project{
+		RepoFullName:   "acme/app",
+		DockerfilePath: "build/Dockerfile",
+		FileURL:        "https://github.com/acme/app/blob/main/build/Dockerfile",
+		HealthGrade:    "B",
+		HealthScore:    72,
+	})
+	partner := addTestKey(t, a, "acme", TierPartner)
+
+	tests := []struct {
+		name    string
+		headers []string
+		partner bool
+	}{
+		{"anonymous", nil, false},
+		{"partner", []string{"Authorization", "Bearer " + partner}, true},
+		{"admin", []string{"X-API-Key", "admin-key"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(t, mux, http.MethodGet, "/api/projects", "", tt.headers...)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", w.Code, w.Body)
+			}
+			var projects []map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &projects); err != nil || len(projects) != 1 {
+				t.Fatalf("body %s: %v", w.Body, err)
+			}
+			p := projects[0]
+			// The dashboard links every project to its matched file, whatever the tier
+			for _, field := range []string{"dockerfile_path", "file_path", "file_url", "health_grade"} {
+				if _, ok := p[field]; !ok {
+					t.Errorf("%s missing", field)
+				}
+			}
+			if _, ok := p["health_score"]; ok != tt.partner {
+				t.Errorf("health_score present = %v, want %v", ok, tt.partner)
+			}
+		})
+	}
+}
+
+func TestTierRateLimits(t *testing.T) {
+	a, mux := newTestAPI(t, nil, WithAPIKey("admin-key"))
+	a.tierLimiters[TierAnonymous] = newRateLimiter(2, time.Minute)
+	a.tierLimiters[TierPartner] = newRateLimiter(4, time.Minute)
+	partner := addTestKey(t, a, "acme", TierPartner)
+
+	allowed := func(headers ...string) int {
+		n := 0
+		for i := 0; i < 10; i++ {
+			w := do(t, mux, http.MethodGet, "/api/stats", "", headers...)
+			switch w.Code {
+			case http.StatusOK:
+				n++
+			case http.StatusTooManyRequests:
+				if w.Header().Get("Retry-After") == "" {
+					t.Error("429 without Retry-After")
+				}
+			default:
+				t.Fatalf("status %d, body %s", w.Code, w.Body)
+			}
+		}
+		return n
+	}
+	if n := allowed(); n != 2 {
+		t.Errorf("anonymous: %d of 10 requests allowed, want 2", n)
+	}
+	if n := allowed("X-API-Key", partner); n != 4 {
+		t.Errorf("partner: %d of 10 requests allowed, want 4", n)
+	}
+	if n := allowed("X-API-Key", "admin-key"); n != 10 {
+		t.Errorf("admin: %d of 10 requests allowed, want all", n)
+	}
+}
+
+func TestBatchCountsEachOperation(t *testing.T) {
+	a, mux := newTestAPI(t, nil)
+	a.tierLimiters[TierAnonymous] = newRateLimiter(5, time.Minute)
+	a.tierLimiters[TierPartner] = newRateLimiter(5, time.Minute)
+	partner := addTestKey(t, a, "acme", TierPartner)
+	batch := `{"requests":{"a":{"op":"stats"},"b":{"op":"languages"},"c":{"op":"topics"}}}`
+
+	for _, tt := range []struct {
+		name    string
+		headers []string
+	}{
+		{"anonymous", nil},
+		{"partner", []string{"X-API-Key", partner}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(t, mux, http.MethodPost, "/api/batch", batch, tt.headers...); w.Code != http.StatusOK {
+				t.Fatalf("first batch: status %d, body %s", w.Code, w.Body)
+			}
+			// Three of five spent, so a second batch of three doesn't fit
+			if w := do(t, mux, http.MethodPost, "/api/batch", batch, tt.headers...); w.Code != http.StatusTooManyRequests {
+				t.Errorf("second batch: status %d, want 429", w.Code)
+			}
+			// The refused batch still cost its own request, leaving one
+			if w := do(t, mux, http.MethodGet, "/api/stats", "", tt.headers...); w.Code != http.StatusOK {
+				t.Errorf("GET after batches: status %d, want 200", w.Code)
+			}
+			if w := do(t, mux, http.MethodGet, "/api/stats", "", tt.headers...); w.Code != http.StatusTooManyRequests {
+				t.Errorf("GET over the limit: status %d, want 429", w.Code)
+			}
+		})
+	}
+}
+
+func TestUnknownKeyRejected(t *testing.T) {
+	_, mux := newTestAPI(t, nil)
+	w := do(t, mux, http.MethodGet, "/api/stats", "", "X-API-Key", "dhi_not-a-key")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("status %d, WWW-Authenticate %q; want 401 with a challenge", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAdminRoutesRequireAdminKey(t *testing.T) {
+	a, mux := newTestAPI(t, nil, WithAPIKey("admin-key"))
+	partner := addTestKey(t, a, "acme", TierPartner)
+	for _, target := range []string{"/api/admin/diagnostics", "/api/admin/coverage-drift", "/api/admin/ecosystems", "/api/keys"} {
+		if w := do(t, mux, http.MethodGet, target, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous GET %s: status %d, want 401", target, w.Code)
+		}
+		if w := do(t, mux, http.MethodGet, target, "", "X-API-Key", partner); w.Code != http.StatusForbidden {
+			t.Errorf("partner GET %s: status %d, want 403", target, w.Code)
+		}
+		if w := do(t, mux, http.MethodGet, target, "", "X-API-Key", "admin-key"); w.Code != http.StatusOK {
+			t.Errorf("admin GET %s: status %d, body %s", target, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Response fields can be restricted to a minimum tier with a struct tag next to
+// their json tag, e.g. `json:"health_score" tier:"partner"`. writeJSON drops them
+// for callers below that tier, so handlers never check tiers themselves.
+
+// writeJSON writes body as JSON with the fields the caller's tier may not see removed
+func (a *API) writeJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(visibleTo(requestCaller(r).tier, body))
+}
+
+// visibleTo returns v with fields above tier removed. Values whose types can't
+// contain restricted fields are returned unchanged; others are rebuilt as maps
+// and slices that encode to the same JSON minus the restricted fields.
+func visibleTo(tier Tier, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return filterValue(tier, reflect.ValueOf(v))
+}
+
+func filterValue(tier Tier, v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if !mayRestrict(v.Type()) {
+		return v.Interface()
+	}
+	// Types that encode themselves are passed through as they are
+	if v.Kind() != reflect.Interface && v.Type().Implements(marshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return filterValue(tier, v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = filterValue(tier, v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = filterValue(tier, iter.Value())
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		filterStruct(tier, v, out)
+		return out
+	}
+	return v.Interface()
+}
+
+// filterStruct adds the JSON fields of struct v visible to tier to out,
+// following encoding/json's rules for names, "-" and omitempty
+func filterStruct(tier Tier, v reflect.Value, out map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		if min, ok := f.Tag.Lookup("tier"); ok && !tier.atLeast(Tier(min)) {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				filterStruct(tier, fv, out)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		out[name] = filterValue(tier, fv)
+	}
+}
+
+// isEmptyValue matches encoding/json's definition for omitempty
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// restrictCache records which types can contain restricted fields
+var restrictCache sync.Map // reflect.Type -> bool
+
+// mayRestrict reports whether values of type t can contain a tier-restricted
+// field. Interfaces may hold anything, so they always can.
+func mayRestrict(t reflect.Type) bool {
+	if cached, ok := restrictCache.Load(t); ok {
+		return cached.(bool)
+	}
+	restrict := computeRestrict(t, map[reflect.Type]bool{})
+	restrictCache.Store(t, restrict)
+	return restrict
+}
+
+// computeRestrict implements mayRestrict. visiting holds the types being
+// examined, so recursive types terminate.
+func computeRestrict(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return computeRestrict(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() && !f.Anonymous {
+				continue
+			}
+			if _, ok := f.Tag.Lookup("tier"); ok || computeRestrict(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type visibilityInner struct {
+	Public  string `json:"public"`
+	Private string `json:"private" tier:"partner"`
+}
+
+type visibilityOuter struct {
+	visibilityInner
+	Name     string                     `json:"name"`
+	Secret   int                        `json:"secret" tier:"admin"`
+	Optional string                     `json:"optional,omitempty" tier:"partner"`
+	Skipped  string                     `json:"-"`
+	Items    []visibilityInner          `json:"items"`
+	ByKey    map[string]visibilityInner `json:"by_key"`
+	Raw      json.RawMessage            `json:"raw,omitempty"`
+}
+
+func TestVisibleTo(t *testing.T) {
+	v := visibilityOuter{
+		visibilityInner: visibilityInner{Public: "p", Private: "x"},
+		Name:            "n",
+		Secret:          7,
+		Optional:        "o",
+		Skipped:         "s",
+		Items:           []visibilityInner{{Public: "ip", Private: "ix"}},
+		ByKey:           map[string]visibilityInner{"k": {Public: "kp", Private: "kx"}},
+		Raw:             json.RawMessage(`{"kept":true}`),
+	}
+	tests := []struct {
+		tier Tier
+		want string
+	}{
+		{TierAnonymous, `{"by_key":{"k":{"public":"kp"}},"items":[{"public":"ip"}],"name":"n","public":"p","raw":{"kept":true}}`},
+		{TierPartner, `{"by_key":{"k":{"private":"kx","public":"kp"}},"items":[{"private":"ix","public":"ip"}],"name":"n","optional":"o","private":"x","public":"p","raw":{"kept":true}}`},
+		{TierAdmin, `{"by_key":{"k":{"private":"kx","public":"kp"}},"items":[{"private":"ix","public":"ip"}],"name":"n","optional":"o","private":"x","public":"p","raw":{"kept":true},"secret":7}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.tier), func(t *testing.T) {
+			got, err := json.Marshal(visibleTo(tt.tier, &v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	// Admins see everything, so the filtered value encodes like the original
+	full, _ := json.Marshal(v)
+	filtered, _ := json.Marshal(visibleTo(TierAdmin, v))
+	var a, b interface{}
+	json.Unmarshal(full, &a)
+	json.Unmarshal(filtered, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("admin view differs from plain encoding:\n%s\n%s", full, filtered)
+	}
+}
+
+func TestVisibleToUnrestrictedTypes(t *testing.T) {
+	type plain struct {
+		A int `json:"a"`
+	}
+	v := []plain{{1}}
+	if got := visibleTo(TierAnonymous, v); !reflect.DeepEqual(got, v) {
+		t.Errorf("unrestricted value rebuilt as %#v", got)
+	}
+	if mayRestrict(reflect.TypeOf(v)) {
+		t.Error("mayRestrict true for a type without tier tags")
+	}
+	if !mayRestrict(reflect.TypeOf(visibilityOuter{})) {
+		t.Error("mayRestrict false for a type with tier tags")
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"dhi-oss-usage/internal/db"
+	"dhi-oss-usage/internal/webhook"
+)
+
+// webhookTimeout bounds a delivery with all its retries
+const webhookTimeout = 2 * time.Minute
+
+// SetWebhook sets the URL notified of projects that refreshes find for the first
+// time, and the secret its deliveries are signed with; an empty URL disables it
+func (a *API) SetWebhook(url, secret string) {
+	if url == "" {
+		a.webhook = nil
+		return
+	}
+	a.webhook = &webhook.Sender{URL: url, Secret: secret}
+}
+
+// newProjectsEvent is the webhook payload for projects first seen by a refresh
+type newProjectsEvent struct {
+	Event    string            `json:"event"` // always "new_projects"
+	JobID    int64             `json:"job_id"`
+	Count    int               `json:"count"`
+	Projects []newProjectEntry `json:"projects"`
+}
+
+type newProjectEntry struct {
+	RepoFullName    string    `json:"repo_full_name"`
+	GitHubURL       string    `json:"github_url"`
+	Stars           int       `json:"stars"`
+	Description     string    `json:"description"`
+	PrimaryLanguage string    `json:"primary_language"`
+	Registry        string    `json:"registry"`
+	FirstSeenAt     time.Time `json:"first_seen_at"`
+}
+
+// notifyNewProjects sends the projects first seen since a refresh job started to
+// the webhook, if one is set, in the background so retries don't hold up the
+// refresh. Every attempt is recorded in webhook_deliveries.
+func (a *API) notifyNewProjects(jobID int64, started time.Time) {
+	if a.webhook == nil {
+		return
+	}
+	projects, err := a.db.GetProjectsFirstSeenSince(started)
+	if err != nil {
+		log.Printf("Error getting new projects of refresh job %d: %v", jobID, err)
+		return
+	}
+	if len(projects) == 0 {
+		return
+	}
+
+	event := newProjectsEvent{Event: "new_projects", JobID: jobID, Count: len(projects)}
+	for _, p := range projects {
+		event.Projects = append(event.Projects, newProjectEntry{
+			RepoFullName:    p.RepoFullName,
+			GitHubURL:       p.GitHubURL,
+			Stars:           p.Stars,
+			Description:     p.Description,
+			PrimaryLanguage: p.PrimaryLanguage,
+			Registry:        p.Registry,
+			FirstSeenAt:     p.FirstSeenAt,
+		})
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding new_projects webhook of refresh job %d: %v", jobID, err)
+		return
+	}
+
+	sender := a.webhook
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		err := sender.Send(ctx, body, func(at webhook.Attempt) {
+			d := db.WebhookDelivery{
+				JobID:      jobID,
+				Event:      event.Event,
+				URL:        sender.URL,
+				Attempt:    at.Number,
+				StatusCode: at.StatusCode,
+				DurationMS: at.Duration.Milliseconds(),
+			}
+			if at.Err != nil {
+				d.Error = at.Err.Error()
+				log.Printf("Webhook new_projects for refresh job %d failed (attempt %d): %v", jobID, at.Number, at.Err)
+			}
+			if err := a.db.RecordWebhookDelivery(d); err != nil {
+				log.Printf("Error recording webhook delivery: %v", err)
+			}
+		})
+		if err != nil {
+			log.Printf("Giving up on webhook new_projects for refresh job %d: %v", jobID, err)
+			return
+		}
+		log.Printf("Delivered webhook new_projects for refresh job %d: %d projects", jobID, len(projects))
+	}()
+}
@@ -0,0 +1,121 @@
+// Package bootstrap publishes the project table as a dataset archive and seeds
+// new deployments from one, so they are usable before their first full refresh.
+//
+// An archive is gzipped JSON (see Dataset). It is published alongside its SHA-256
+// checksum, and importing requires that checksum, so a deployment only loads the
+// exact archive its operator configured.
+package bootstrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"dhi-oss-usage/internal/db"
+)
+
+// FormatVersion is the archive format written by Publish. Import rejects other versions.
+const FormatVersion = 1
+
+// maxArchiveSize bounds downloads so a bad URL can't exhaust memory
+const maxArchiveSize = 256 << 20
+
+// Dataset is the content of an archive
+type Dataset struct {
+	FormatVersion int          `json:"format_version"`
+	GeneratedAt   time.Time    `json:"generated_at"`
+	Projects      []db.Project `json:"projects"`
+}
+
+// Publish writes an archive of every project, including inactive and soft-deleted
+// ones, and returns its hex SHA-256 checksum
+func Publish(w io.Writer, database *db.DB, now time.Time) (string, error) {
+	projects, err := database.ListProjects(db.ProjectFilter{
+		SortBy:         "name",
+		SortOrder:      "asc",
+		IncludeDeleted: true,
+		Active:         db.AnyActivity,
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing projects: %w", err)
+	}
+
+	h := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(w, h))
+	err = json.NewEncoder(gz).Encode(Dataset{
+		FormatVersion: FormatVersion,
+		GeneratedAt:   now.UTC(),
+		Projects:      projects,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Fetch downloads an archive and verifies it against the expected hex SHA-256 checksum
+func Fetch(ctx context.Context, url, wantSHA256 string) ([]byte, error) {
+	wantSHA256 = strings.ToLower(strings.TrimSpace(wantSHA256))
+	if wantSHA256 == "" {
+		return nil, fmt.Errorf("no checksum configured for %s", url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveSize {
+		return nil, fmt.Errorf("archive at %s exceeds %d bytes", url, maxArchiveSize)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != wantSHA256 {
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, want %s", url, got, wantSHA256)
+	}
+	return data, nil
+}
+
+// Import loads an archive into an empty database, recording a bootstrap refresh
+// job. It returns the number of projects imported; on error nothing is imported.
+func Import(database *db.DB, archive []byte) (int, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return 0, fmt.Errorf("reading archive: %w", err)
+	}
+	var ds Dataset
+	if err := json.NewDecoder(gz).Decode(&ds); err != nil {
+		return 0, fmt.Errorf("decoding archive: %w", err)
+	}
+	if ds.FormatVersion != FormatVersion {
+		return 0, fmt.Errorf("unsupported archive format version %d (want %d)", ds.FormatVersion, FormatVersion)
+	}
+
+	if _, err := database.ImportBootstrap(ds.Projects, ds.GeneratedAt); err != nil {
+		return 0, err
+	}
+	return len(ds.Projects), nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// APIKey is an issued API key. Only a hash of the key itself is stored.
+type APIKey struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Tier         string     `json:"tier"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	RequestCount int        `json:"request_count"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+const apiKeyColumns = `id, name, tier, created_at, revoked_at, request_count, last_used_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var k APIKey
+	if err := row.Scan(&k.ID, &k.Name, &k.Tier, &k.CreatedAt, &k.RevokedAt, &k.RequestCount, &k.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateAPIKey stores a new key by its hash
+func (db *DB) CreateAPIKey(name, tier, keyHash string) (*APIKey, error) {
+	res, err := db.Exec(`INSERT INTO api_keys (name, tier, key_hash) VALUES (?, ?, ?)`, name, tier, keyHash)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+}
+
+// GetAPIKeyByHash returns the unrevoked key with the given hash, or nil if there is none
+func (db *DB) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	k, err := scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// ListAPIKeys returns every key, revoked ones included, newest first
+func (db *DB) ListAPIKeys() ([]APIKey, error) {
+	rows, err := db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key. It returns false if no unrevoked key has the id.
+func (db *DB) RevokeAPIKey(id int64) (bool, error) {
+	res, err := db.Exec(`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecordAPIKeyUse counts a request made with a key
+func (db *DB) RecordAPIKeyUse(id int64) error {
+	_, err := db.Exec(`UPDATE api_keys SET request_count = request_count + 1, last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+// HasAPIKeys reports whether any unrevoked key of the given tier exists
+func (db *DB) HasAPIKeys(tier string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE tier = ? AND revoked_at IS NULL`, tier).Scan(&n)
+	return n > 0, err
+}
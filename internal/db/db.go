@@ -2,7 +2,12 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -10,6 +15,7 @@ import (
 
 type DB struct {
 	*sql.DB
+	fts bool // projects_fts is maintained, set by Migrate
 }
 
 type Project struct {
@@ -20,24 +26,79 @@ type Project struct {
 	Description     string     `json:"description"`
 	PrimaryLanguage string     `json:"primary_language"`
 	DockerfilePath  string     `json:"dockerfile_path"`
+	FilePath        string     `json:"file_path"` // DockerfilePath, under a name that fits YAML and workflow files too
 	FileURL         string     `json:"file_url"`
 	SourceType      string     `json:"source_type"`
+	Registry        string     `json:"registry"` // registry host the project references, e.g. dhi.io
 	AdoptedAt       *time.Time `json:"adopted_at"`
-	AdoptionCommit  string     `json:"adoption_commit"`
+	AdoptionCommit  string     `json:"adoption_commit"` // URL of the commit that first referenced the registry
+	AdoptionSHA     string     `json:"adoption_commit_sha"`
 	FirstSeenAt     time.Time  `json:"first_seen_at"`
 	LastSeenAt      time.Time  `json:"last_seen_at"`
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"` // set when the repo disappeared from GitHub
+	Active          bool       `json:"active"`               // false once a refresh no longer finds the project
+	Ecosystem       string     `json:"ecosystem,omitempty"`  // computed from PrimaryLanguage by the API, not stored
+	PushedAt        *time.Time `json:"pushed_at"`
+	Archived        bool       `json:"archived"`
+	Fork            bool       `json:"fork"`
+	Topics          []string   `json:"topics"`     // GitHub repo topics; empty until the next refresh for older rows
+	DHIImages       []string   `json:"dhi_images"` // registry images in FROM lines of the matched file, e.g. golang:1.22
+
+	// Number of notes on the project, set by ListProjects; omitted when there are none
+	NoteCount int `json:"note_count,omitempty"`
+
+	// Health grade computed when the project was last saved; empty until its first refresh
+	HealthGrade   string          `json:"health_grade"`
+	HealthScore   int             `json:"health_score" tier:"partner"`
+	HealthFactors json.RawMessage `json:"health_factors,omitempty" tier:"partner"` // which factors passed and failed
+
+	// HTML-escaped with search matches marked with <mark>, set when listing with a full-text search
+	HighlightName string `json:"highlight_name,omitempty"`
+	HighlightDesc string `json:"highlight_desc,omitempty"`
 }
 
 type RefreshJob struct {
 	ID            int64      `json:"id"`
-	Status        string     `json:"status"` // pending, running, completed, failed
+	Status        string     `json:"status"` // pending, running, completed, failed, cancelled
 	StartedAt     *time.Time `json:"started_at"`
 	CompletedAt   *time.Time `json:"completed_at"`
 	ProjectsFound int        `json:"projects_found"`
 	ErrorMessage  string     `json:"error_message"`
 	CreatedAt     time.Time  `json:"created_at"`
+	ETagHits      int        `json:"etag_hits"`        // repo detail fetches answered 304 Not Modified
+	ETagMisses    int        `json:"etag_misses"`      // repo detail fetches that returned full metadata
+	Source        string     `json:"source,omitempty"` // what started the job: manual, scheduled, startup, bootstrap, smoke, dry_run
+	// Progress of a running job, updated at most once a second; kept as last reported once it ends
+	CurrentPhase string `json:"current_phase"` // e.g. searching, fetching_details, adoptions
+	CurrentCount int    `json:"current_count"`
+	TotalCount   int    `json:"total_count"` // 0 if unknown
+	// DurationSeconds is completed_at - started_at, nil until the job finishes
+	DurationSeconds *float64 `json:"duration_seconds"`
+	// DurationMS and APIRequestsUsed cover the whole run, enrichment after
+	// completion included; nil until the run ends
+	DurationMS      *int64 `json:"duration_ms"`
+	APIRequestsUsed *int   `json:"api_requests_used"`
+}
+
+const refreshJobColumns = `id, status, started_at, completed_at, projects_found, error_message, created_at, etag_hits, etag_misses, source,
+	current_phase, current_count, total_count, duration_ms, api_requests_used`
+
+// scanRefreshJob scans a row of refreshJobColumns and fills DurationSeconds from
+// the start and completion times
+func scanRefreshJob(row interface{ Scan(...interface{}) error }) (*RefreshJob, error) {
+	var job RefreshJob
+	err := row.Scan(&job.ID, &job.Status, &job.StartedAt, &job.CompletedAt, &job.ProjectsFound, &job.ErrorMessage, &job.CreatedAt, &job.ETagHits, &job.ETagMisses, &job.Source,
+		&job.CurrentPhase, &job.CurrentCount, &job.TotalCount, &job.DurationMS, &job.APIRequestsUsed)
+	if err != nil {
+		return nil, err
+	}
+	if job.StartedAt != nil && job.CompletedAt != nil {
+		d := job.CompletedAt.Sub(*job.StartedAt).Seconds()
+		job.DurationSeconds = &d
+	}
+	return &job, nil
 }
 
 type RefreshSnapshot struct {
@@ -47,6 +108,9 @@ type RefreshSnapshot struct {
 	TotalStars    int       `json:"total_stars"`
 	PopularCount  int       `json:"popular_count"`
 	NotableCount  int       `json:"notable_count"`
+	// BucketStart is the UTC start of the day or week the snapshot is the last
+	// of, set when snapshots are listed by granularity
+	BucketStart *time.Time `json:"bucket_start,omitempty"`
 }
 
 func Open(path string) (*DB, error) {
@@ -59,7 +123,7 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("pinging database: %w", err)
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
 }
 
 func (db *DB) Migrate() error {
@@ -76,6 +140,7 @@ func (db *DB) Migrate() error {
 		source_type TEXT DEFAULT '',
 		adopted_at TIMESTAMP,
 		adoption_commit TEXT DEFAULT '',
+		adoption_commit_sha TEXT DEFAULT '',
 		first_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -101,11 +166,135 @@ func (db *DB) Migrate() error {
 		notable_count INTEGER NOT NULL
 	);
 
+	CREATE TABLE IF NOT EXISTS refresh_diffs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INTEGER NOT NULL REFERENCES refresh_jobs(id) ON DELETE CASCADE,
+		repo_full_name TEXT NOT NULL,
+		event TEXT NOT NULL CHECK(event IN ('added', 'removed')),
+		recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS project_query_matches (
+		repo_full_name TEXT NOT NULL,
+		query_name TEXT NOT NULL,
+		first_matched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_matched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_job_id INTEGER NOT NULL,
+		PRIMARY KEY (repo_full_name, query_name)
+	);
+
+	CREATE TABLE IF NOT EXISTS snapshot_languages (
+		snapshot_id INTEGER NOT NULL REFERENCES refresh_snapshots(id),
+		language TEXT NOT NULL,
+		project_count INTEGER NOT NULL,
+		PRIMARY KEY (snapshot_id, language)
+	);
+
+	CREATE TABLE IF NOT EXISTS github_etags (
+		endpoint TEXT PRIMARY KEY,
+		etag TEXT NOT NULL,
+		body BLOB NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		tier TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP,
+		request_count INTEGER DEFAULT 0,
+		last_used_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS scheduler_state (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		schedule TEXT NOT NULL,
+		next_run_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS file_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id),
+		job_id INTEGER NOT NULL,
+		file_path TEXT NOT NULL,
+		old_hash TEXT NOT NULL,
+		new_hash TEXT NOT NULL,
+		diff TEXT DEFAULT '',
+		skipped TEXT DEFAULT '',
+		recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS project_star_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id),
+		stars INTEGER NOT NULL,
+		recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		url TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER DEFAULT 0,
+		error TEXT DEFAULT '',
+		duration_ms INTEGER DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Code search queries run by refreshes, seeded from the built-in ones
+	CREATE TABLE IF NOT EXISTS search_queries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		query TEXT NOT NULL,
+		registry TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Annotations on projects, e.g. why one was added by hand or flagged
+	CREATE TABLE IF NOT EXISTS project_notes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		note TEXT NOT NULL,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- How each search query fared in a refresh job, for tuning the queries
+	CREATE TABLE IF NOT EXISTS search_query_stats (
+		job_id INTEGER NOT NULL REFERENCES refresh_jobs(id),
+		query_name TEXT NOT NULL,
+		repos INTEGER NOT NULL,
+		unique_repos INTEGER NOT NULL,
+		pages INTEGER NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		hit_github_limit INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (job_id, query_name)
+	);
+
+	-- Excluded repos, e.g. false positives removed by hand, which refreshes must not add back
+	CREATE TABLE IF NOT EXISTS project_suppressions (
+		repo_full_name TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_projects_stars ON projects(stars DESC);
 	CREATE INDEX IF NOT EXISTS idx_projects_repo ON projects(repo_full_name);
 	CREATE INDEX IF NOT EXISTS idx_projects_first_seen ON projects(first_seen_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_projects_last_seen ON projects(last_seen_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_projects_updated ON projects(updated_at DESC);
 	CREATE INDEX IF NOT EXISTS idx_projects_adopted ON projects(adopted_at DESC);
 	CREATE INDEX IF NOT EXISTS idx_snapshots_recorded ON refresh_snapshots(recorded_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_refresh_diffs_job ON refresh_diffs(job_id);
+	CREATE INDEX IF NOT EXISTS idx_query_matches_job ON project_query_matches(last_job_id);
+	CREATE INDEX IF NOT EXISTS idx_file_changes_project ON file_changes(project_id);
+	CREATE INDEX IF NOT EXISTS idx_projects_language_stars ON projects(primary_language, stars);
+	CREATE INDEX IF NOT EXISTS idx_star_history_project ON project_star_history(project_id, recorded_at);
+	CREATE INDEX IF NOT EXISTS idx_project_notes_project ON project_notes(project_id);
 
 
 	`
@@ -118,17 +307,92 @@ func (db *DB) Migrate() error {
 	// Migration: add adopted_at column if it doesn't exist (ignore error if already exists)
 	db.Exec("ALTER TABLE projects ADD COLUMN adopted_at TIMESTAMP")
 	db.Exec("ALTER TABLE projects ADD COLUMN adoption_commit TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN adoption_commit_sha TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN deleted_at TIMESTAMP")
+	db.Exec("ALTER TABLE projects ADD COLUMN is_deleted BOOLEAN DEFAULT 0")
+	db.Exec("ALTER TABLE projects ADD COLUMN active BOOLEAN DEFAULT 1")
+	// Projects tracked before the registry became configurable all reference dhi.io
+	db.Exec("ALTER TABLE projects ADD COLUMN registry TEXT DEFAULT 'dhi.io'")
+	db.Exec("ALTER TABLE projects ADD COLUMN pushed_at TIMESTAMP")
+	db.Exec("ALTER TABLE projects ADD COLUMN archived BOOLEAN DEFAULT 0")
+	db.Exec("ALTER TABLE projects ADD COLUMN fork BOOLEAN DEFAULT 0")
+	db.Exec("ALTER TABLE projects ADD COLUMN health_grade TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN health_score INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE projects ADD COLUMN health_factors TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN file_hash TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN file_lines TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN topics TEXT DEFAULT '[]'")     // JSON array of strings
+	db.Exec("ALTER TABLE projects ADD COLUMN dhi_images TEXT DEFAULT '[]'") // JSON array of strings
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN etag_hits INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN etag_misses INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN source TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN current_phase TEXT DEFAULT ''")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN current_count INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN total_count INTEGER DEFAULT 0")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN duration_ms INTEGER")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN api_requests_used INTEGER")
+	db.Exec("ALTER TABLE refresh_jobs ADD COLUMN dry_run_result TEXT") // JSON, set by dry runs only
+	db.Exec("ALTER TABLE project_suppressions ADD COLUMN reason TEXT DEFAULT ''")
 
+	if err := db.migrateRefreshDiffsCascade(); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	if err := db.migrateFTS(); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
 
 	return nil
 }
 
+// migrateRefreshDiffsCascade rebuilds refresh_diffs tables created before their
+// job_id cascaded on delete, since SQLite can't alter a foreign key in place
+func (db *DB) migrateRefreshDiffsCascade() error {
+	var schema string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'refresh_diffs'`).Scan(&schema); err != nil {
+		return fmt.Errorf("reading refresh_diffs schema: %w", err)
+	}
+	if strings.Contains(schema, "ON DELETE CASCADE") {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`CREATE TABLE refresh_diffs_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL REFERENCES refresh_jobs(id) ON DELETE CASCADE,
+			repo_full_name TEXT NOT NULL,
+			event TEXT NOT NULL CHECK(event IN ('added', 'removed')),
+			recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO refresh_diffs_new (id, job_id, repo_full_name, event, recorded_at)
+			SELECT id, job_id, repo_full_name, event, recorded_at FROM refresh_diffs`,
+		`DROP TABLE refresh_diffs`,
+		`ALTER TABLE refresh_diffs_new RENAME TO refresh_diffs`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_diffs_job ON refresh_diffs(job_id)`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("rebuilding refresh_diffs: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Ping checks that the database answers a trivial query
+func (db *DB) Ping() error {
+	var one int
+	return db.QueryRow("SELECT 1").Scan(&one)
+}
+
 // Project operations
 
-func (db *DB) UpsertProject(p *Project) error {
-	query := `
-	INSERT INTO projects (repo_full_name, github_url, stars, description, primary_language, dockerfile_path, file_url, source_type, adopted_at, first_seen_at, last_seen_at, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+// upsertProjectQuery inserts a project or updates the tracked one with the same name
+const upsertProjectQuery = `
+	INSERT INTO projects (repo_full_name, github_url, stars, description, primary_language, dockerfile_path, file_url, source_type, adopted_at, registry, pushed_at, archived, fork, topics, health_grade, health_score, health_factors, first_seen_at, last_seen_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(NULLIF(?, ''), 'dhi.io'), ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	ON CONFLICT(repo_full_name) DO UPDATE SET
 		stars = excluded.stars,
 		description = excluded.description,
@@ -136,38 +400,358 @@ func (db *DB) UpsertProject(p *Project) error {
 		dockerfile_path = excluded.dockerfile_path,
 		file_url = excluded.file_url,
 		source_type = excluded.source_type,
+		registry = excluded.registry,
+		pushed_at = excluded.pushed_at,
+		archived = excluded.archived,
+		fork = excluded.fork,
+		topics = excluded.topics,
+		health_grade = excluded.health_grade,
+		health_score = excluded.health_score,
+		health_factors = excluded.health_factors,
 		adopted_at = COALESCE(projects.adopted_at, excluded.adopted_at),
+		is_deleted = 0,
+		deleted_at = NULL,
+		active = 1,
 		last_seen_at = CURRENT_TIMESTAMP,
 		updated_at = CURRENT_TIMESTAMP
 	`
-	_, err := db.Exec(query, p.RepoFullName, p.GitHubURL, p.Stars, p.Description, p.PrimaryLanguage, p.DockerfilePath, p.FileURL, p.SourceType, p.AdoptedAt)
+
+// recordStarsQuery adds a project's star count to its history when it differs
+// from the last one recorded, so the history only grows when stars change
+const recordStarsQuery = `
+	INSERT INTO project_star_history (project_id, stars)
+	SELECT id, stars FROM projects WHERE repo_full_name = ?
+		AND stars IS NOT (SELECT h.stars FROM project_star_history h WHERE h.project_id = projects.id ORDER BY h.recorded_at DESC, h.id DESC LIMIT 1)`
+
+func upsertProjectArgs(p *Project) []interface{} {
+	return []interface{}{p.RepoFullName, p.GitHubURL, p.Stars, p.Description, p.PrimaryLanguage, p.DockerfilePath, p.FileURL, p.SourceType, p.AdoptedAt, p.Registry,
+		p.PushedAt, p.Archived, p.Fork, jsonStrings(p.Topics), p.HealthGrade, p.HealthScore, string(p.HealthFactors)}
+}
+
+// jsonStrings encodes a list for a JSON array column, "[]" when empty
+func jsonStrings(list []string) string {
+	if len(list) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+// scanJSONStrings decodes a JSON array column, giving an empty list rather than nil
+func scanJSONStrings(s string) ([]string, error) {
+	list := []string{}
+	if s == "" {
+		return list, nil
+	}
+	err := json.Unmarshal([]byte(s), &list)
+	return list, err
+}
+
+// UpsertProject inserts or updates a project and records a change in its stars
+func (db *DB) UpsertProject(p *Project) error {
+	return db.UpsertProjects([]*Project{p})
+}
+
+// UpsertProjects upserts every project in one transaction, so a refresh commits
+// once rather than per project and a failure leaves the table as it was. Changed
+// star counts are added to project_star_history. Suppressed repos are skipped.
+func (db *DB) UpsertProjects(projects []*Project) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	suppressed, err := suppressedRepos(tx.Query)
+	if err != nil {
+		return fmt.Errorf("getting suppressed repos: %w", err)
+	}
+
+	stmt, err := tx.Prepare(upsertProjectQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	stars, err := tx.Prepare(recordStarsQuery)
+	if err != nil {
+		return err
+	}
+	defer stars.Close()
+	for _, p := range projects {
+		if suppressed[p.RepoFullName] {
+			continue
+		}
+		if _, err := stmt.Exec(upsertProjectArgs(p)...); err != nil {
+			return fmt.Errorf("upserting %s: %w", p.RepoFullName, err)
+		}
+		if _, err := stars.Exec(p.RepoFullName); err != nil {
+			return fmt.Errorf("recording stars of %s: %w", p.RepoFullName, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SourceTypeManual is the source type of projects submitted through the API rather
+// than found by a search. Refreshes don't look for them, so they are never marked
+// inactive or counted as removed.
+const SourceTypeManual = "manual"
+
+// MarkInactiveProjects flags active projects not seen since before as no longer
+// using DHI and returns how many were marked. Rows are kept so churn can be measured.
+// Manually submitted projects are left alone.
+func (db *DB) MarkInactiveProjects(before time.Time) (int, error) {
+	// last_seen_at is written by CURRENT_TIMESTAMP, so compare in the same UTC text format
+	res, err := db.Exec(`UPDATE projects SET active = 0, updated_at = CURRENT_TIMESTAMP WHERE active = 1 AND last_seen_at < ? AND source_type != ?`,
+		before.UTC().Format("2006-01-02 15:04:05"), SourceTypeManual)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// GetProjectID returns the id of a project by its full repo name
+func (db *DB) GetProjectID(repoFullName string) (int64, error) {
+	var id int64
+	err := db.QueryRow(`SELECT id FROM projects WHERE repo_full_name = ?`, repoFullName).Scan(&id)
+	return id, err
+}
+
+// GetProjectByRepoName returns a project by its full repo name, or nil if there
+// is none or it was soft-deleted
+func (db *DB) GetProjectByRepoName(name string) (*Project, error) {
+	var p Project
+	err := scanProject(db.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE repo_full_name = ? AND is_deleted = 0`, name), &p)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetProjectByID returns a project by its id, or nil if there is none or it was
+// soft-deleted
+func (db *DB) GetProjectByID(id int64) (*Project, error) {
+	var p Project
+	err := scanProject(db.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE id = ? AND is_deleted = 0`, id), &p)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ProjectPatch holds manual corrections to a project. Nil fields are left alone.
+type ProjectPatch struct {
+	Description     *string
+	PrimaryLanguage *string
+	Stars           *int
+	SourceType      *string
+}
+
+// PatchProject applies the set fields of patch to a tracked project. It returns
+// false if no tracked project has the id.
+func (db *DB) PatchProject(id int64, patch ProjectPatch) (bool, error) {
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	var args []interface{}
+	if patch.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *patch.Description)
+	}
+	if patch.PrimaryLanguage != nil {
+		sets = append(sets, "primary_language = ?")
+		args = append(args, *patch.PrimaryLanguage)
+	}
+	if patch.Stars != nil {
+		sets = append(sets, "stars = ?")
+		args = append(args, *patch.Stars)
+	}
+	if patch.SourceType != nil {
+		sets = append(sets, "source_type = ?")
+		args = append(args, *patch.SourceType)
+	}
+
+	res, err := db.Exec(`UPDATE projects SET `+strings.Join(sets, ", ")+` WHERE id = ? AND is_deleted = 0`, append(args, id)...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SoftDeleteProject hides a project from listings and stats without losing its history
+func (db *DB) SoftDeleteProject(id int64) error {
+	_, err := db.Exec(`UPDATE projects SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
 	return err
 }
 
+// RestoreProject undoes a soft delete
+func (db *DB) RestoreProject(id int64) error {
+	_, err := db.Exec(`UPDATE projects SET is_deleted = 0, deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+// GetProjectRepoName returns the full repo name of the project with the id,
+// soft-deleted or not, or "" if there is none
+func (db *DB) GetProjectRepoName(id int64) (string, error) {
+	var name string
+	err := db.QueryRow(`SELECT repo_full_name FROM projects WHERE id = ?`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+// ErrProjectReferenced is returned by HardDeleteProject for a project that refresh
+// diffs mention, since removing it would leave them pointing at nothing
+var ErrProjectReferenced = errors.New("project is referenced by refresh diffs")
+
+// HardDeleteProject removes a project and its star history, file changes, query
+// matches and notes for good, and excludes its repo so refreshes don't add it back.
+// It returns false if no project has the id.
+func (db *DB) HardDeleteProject(id int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRow(`SELECT repo_full_name FROM projects WHERE id = ?`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var diffs int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM refresh_diffs WHERE repo_full_name = ?`, name).Scan(&diffs); err != nil {
+		return false, err
+	}
+	if diffs > 0 {
+		return false, ErrProjectReferenced
+	}
+
+	for _, q := range []string{
+		`DELETE FROM project_star_history WHERE project_id = ?`,
+		`DELETE FROM file_changes WHERE project_id = ?`,
+		`DELETE FROM project_query_matches WHERE repo_full_name = (SELECT repo_full_name FROM projects WHERE id = ?)`,
+		`DELETE FROM projects WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO project_suppressions (repo_full_name) VALUES (?)`, name); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
 type ProjectFilter struct {
-	MinStars   int
-	MaxStars   int
-	Search     string
+	MinStars   *int   // nil means no lower bound
+	MaxStars   *int   // nil means no upper bound
+	Search     string // full-text when FTS5 is available, else a substring of name or description
 	SourceType string
-	SortBy     string // stars, name, first_seen
-	SortOrder  string // asc, desc
+	Language   string // exact match; "Unknown" or "_unknown" matches projects with no language
+	Registry   string // exact registry host
+	Grade      string // exact health grade, e.g. "A"
+	Topic      string // exact GitHub topic, one of the project's topics
+	SortBy     string // one of SortFields; empty sorts by stars
+	SortOrder  string // asc, desc; empty means desc
 	Limit      int
 	Offset     int
+
+	IncludeDeleted bool         // include soft-deleted projects
+	Active         ActiveFilter // defaults to active projects only
 }
 
-func (db *DB) ListProjects(filter ProjectFilter) ([]Project, error) {
-	query := `SELECT id, repo_full_name, github_url, stars, description, primary_language, dockerfile_path, file_url, source_type, adopted_at, adoption_commit, first_seen_at, last_seen_at, created_at, updated_at FROM projects WHERE 1=1`
+// ActiveFilter selects projects by whether they still reference DHI
+type ActiveFilter string
+
+const (
+	ActiveOnly   ActiveFilter = ""         // projects seen in the latest refresh
+	InactiveOnly ActiveFilter = "inactive" // projects that stopped referencing DHI
+	AnyActivity  ActiveFilter = "all"
+)
+
+// sortColumns maps ProjectFilter.SortBy values to the columns they sort by
+var sortColumns = map[string]string{
+	"stars":      "stars",
+	"name":       "projects.repo_full_name",
+	"first_seen": "first_seen_at",
+	"last_seen":  "last_seen_at",
+	"updated":    "updated_at",
+	"updated_at": "updated_at", // the column name, which front ends tend to send
+	"health":     "health_score",
+}
+
+// SortFields returns the accepted ProjectFilter.SortBy values, sorted
+func SortFields() []string {
+	fields := make([]string, 0, len(sortColumns))
+	for f := range sortColumns {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Validate reports an unknown sort field or order, so typos aren't silently
+// sorted by stars
+func (f ProjectFilter) Validate() error {
+	if _, ok := sortColumns[f.SortBy]; f.SortBy != "" && !ok {
+		return fmt.Errorf("invalid sort %q: must be one of %s", f.SortBy, strings.Join(SortFields(), ", "))
+	}
+	if f.SortOrder != "" && f.SortOrder != "asc" && f.SortOrder != "desc" {
+		return fmt.Errorf("invalid order %q: must be asc or desc", f.SortOrder)
+	}
+	return nil
+}
+
+// projectQuery builds the SELECT statement and args for a project filter. With
+// the full-text index a search joins projects_fts for highlights and, unless
+// another sort is asked for, relevance order.
+func (db *DB) projectQuery(filter ProjectFilter) (string, []interface{}, error) {
+	if err := filter.Validate(); err != nil {
+		return "", nil, err
+	}
+	match := ""
+	if db.fts {
+		match = ftsQuery(filter.Search)
+	}
+
+	query := `SELECT ` + projectColumns + `, (SELECT COUNT(*) FROM project_notes WHERE project_notes.project_id = projects.id)`
 	args := []interface{}{}
+	if match != "" {
+		query += `, highlight(projects_fts, 0, char(1), char(2)), highlight(projects_fts, 1, char(1), char(2))
+			FROM projects JOIN projects_fts ON projects_fts.rowid = projects.id WHERE projects_fts MATCH ?`
+		args = append(args, match)
+	} else {
+		query += `, '', '' FROM projects WHERE 1=1`
+	}
 
-	if filter.MinStars > 0 {
+	if !filter.IncludeDeleted {
+		query += " AND is_deleted = 0"
+	}
+	switch filter.Active {
+	case ActiveOnly:
+		query += " AND active = 1"
+	case InactiveOnly:
+		query += " AND active = 0"
+	}
+	if filter.MinStars != nil {
 		query += " AND stars >= ?"
-		args = append(args, filter.MinStars)
+		args = append(args, *filter.MinStars)
 	}
-	if filter.MaxStars > 0 {
+	if filter.MaxStars != nil {
 		query += " AND stars <= ?"
-		args = append(args, filter.MaxStars)
+		args = append(args, *filter.MaxStars)
 	}
-	if filter.Search != "" {
+	if filter.Search != "" && match == "" {
 		query += " AND (repo_full_name LIKE ? OR description LIKE ?)"
 		searchPattern := "%" + filter.Search + "%"
 		args = append(args, searchPattern, searchPattern)
@@ -176,52 +760,122 @@ func (db *DB) ListProjects(filter ProjectFilter) ([]Project, error) {
 		query += " AND source_type = ?"
 		args = append(args, filter.SourceType)
 	}
+	if filter.Registry != "" {
+		query += " AND registry = ?"
+		args = append(args, filter.Registry)
+	}
+	if filter.Grade != "" {
+		query += " AND health_grade = ?"
+		args = append(args, filter.Grade)
+	}
+	if filter.Topic != "" {
+		query += " AND EXISTS (SELECT 1 FROM json_each(projects.topics) WHERE value = ?)"
+		args = append(args, filter.Topic)
+	}
+	if filter.Language != "" {
+		if filter.Language == UnknownLanguage || filter.Language == UnknownLanguageSummary {
+			query += " AND COALESCE(primary_language, '') = ''"
+		} else {
+			query += " AND primary_language = ?"
+			args = append(args, filter.Language)
+		}
+	}
 
 	// Sorting
 	sortCol := "stars"
-	switch filter.SortBy {
-	case "name":
-		sortCol = "repo_full_name"
-	case "first_seen":
-		sortCol = "first_seen_at"
-	case "stars":
-		sortCol = "stars"
+	if filter.SortBy != "" {
+		sortCol = sortColumns[filter.SortBy]
 	}
 	sortOrder := "DESC"
 	if filter.SortOrder == "asc" {
 		sortOrder = "ASC"
 	}
-	query += fmt.Sprintf(" ORDER BY %s %s", sortCol, sortOrder)
+	if match != "" && filter.SortBy == "" && filter.SortOrder == "" {
+		// Best match first, a name match counting ten times a description match
+		query += " ORDER BY bm25(projects_fts, 10.0, 1.0)"
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s", sortCol, sortOrder)
+	}
 
 	if filter.Limit > 0 {
 		query += " LIMIT ?"
 		args = append(args, filter.Limit)
 	}
 	if filter.Offset > 0 {
+		if filter.Limit <= 0 {
+			query += " LIMIT -1"
+		}
 		query += " OFFSET ?"
 		args = append(args, filter.Offset)
 	}
+	return query, args, nil
+}
 
+func (db *DB) ListProjects(filter ProjectFilter) ([]Project, error) {
+	var projects []Project
+	err := db.EachProject(filter, func(p *Project) error {
+		projects = append(projects, *p)
+		return nil
+	})
+	return projects, err
+}
+
+// EachProject streams projects matching filter to fn straight from the database
+// cursor, without loading them all into memory. Iteration stops at the first error.
+func (db *DB) EachProject(filter ProjectFilter, fn func(*Project) error) error {
+	query, args, err := db.projectQuery(filter)
+	if err != nil {
+		return err
+	}
 	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var projects []Project
 	for rows.Next() {
 		var p Project
-		err := rows.Scan(&p.ID, &p.RepoFullName, &p.GitHubURL, &p.Stars, &p.Description, &p.PrimaryLanguage, &p.DockerfilePath, &p.FileURL, &p.SourceType, &p.AdoptedAt, &p.AdoptionCommit, &p.FirstSeenAt, &p.LastSeenAt, &p.CreatedAt, &p.UpdatedAt)
-		if err != nil {
-			return nil, err
+		if err := scanProject(rows, &p, &p.NoteCount, &p.HighlightName, &p.HighlightDesc); err != nil {
+			return err
+		}
+		p.HighlightName, p.HighlightDesc = markHighlights(p.HighlightName), markHighlights(p.HighlightDesc)
+		if err := fn(&p); err != nil {
+			return err
 		}
-		projects = append(projects, p)
 	}
-	return projects, rows.Err()
+	return rows.Err()
+}
+
+// projectColumns are the columns scanProject reads. repo_full_name and description
+// are qualified since projects_fts has them too, stars for joins with history.
+const projectColumns = `id, projects.repo_full_name, github_url, projects.stars, projects.description, primary_language, dockerfile_path, file_url, source_type, adopted_at, adoption_commit, adoption_commit_sha, first_seen_at, last_seen_at, created_at, updated_at, deleted_at, active, registry, pushed_at, archived, fork, topics, dhi_images, health_grade, health_score, health_factors`
+
+// scanProject scans a row of projectColumns followed by the extra columns into p
+// and extra
+func scanProject(row interface{ Scan(...interface{}) error }, p *Project, extra ...interface{}) error {
+	var factors []byte
+	var topics, images string
+	dest := []interface{}{&p.ID, &p.RepoFullName, &p.GitHubURL, &p.Stars, &p.Description, &p.PrimaryLanguage, &p.DockerfilePath, &p.FileURL, &p.SourceType, &p.AdoptedAt, &p.AdoptionCommit, &p.AdoptionSHA, &p.FirstSeenAt, &p.LastSeenAt, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt, &p.Active, &p.Registry,
+		&p.PushedAt, &p.Archived, &p.Fork, &topics, &images, &p.HealthGrade, &p.HealthScore, &factors}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	p.FilePath = p.DockerfilePath
+	var err error
+	if p.Topics, err = scanJSONStrings(topics); err != nil {
+		return fmt.Errorf("decoding topics of %s: %w", p.RepoFullName, err)
+	}
+	if p.DHIImages, err = scanJSONStrings(images); err != nil {
+		return fmt.Errorf("decoding images of %s: %w", p.RepoFullName, err)
+	}
+	if len(factors) > 0 {
+		p.HealthFactors = factors
+	}
+	return nil
 }
 
 func (db *DB) GetSourceTypes() ([]string, error) {
-	rows, err := db.Query(`SELECT DISTINCT source_type FROM projects WHERE source_type != '' ORDER BY source_type`)
+	rows, err := db.Query(`SELECT DISTINCT source_type FROM projects WHERE source_type != '' AND is_deleted = 0 ORDER BY source_type`)
 	if err != nil {
 		return nil, err
 	}
@@ -238,95 +892,914 @@ func (db *DB) GetSourceTypes() ([]string, error) {
 	return types, rows.Err()
 }
 
-func (db *DB) GetStats() (total int, totalStars int, popular int, notable int, err error) {
-	err = db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(stars), 0) FROM projects`).Scan(&total, &totalStars)
+// SourceTypeCount is the number of projects found by a search source type
+type SourceTypeCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// GetSourceTypeCounts returns each distinct source type with its active project count
+func (db *DB) GetSourceTypeCounts() ([]SourceTypeCount, error) {
+	rows, err := db.Query(`SELECT source_type, COUNT(*) FROM projects WHERE source_type != '' AND is_deleted = 0 AND active = 1 GROUP BY source_type ORDER BY source_type`)
 	if err != nil {
-		return
+		return nil, err
 	}
-	err = db.QueryRow(`SELECT COUNT(*) FROM projects WHERE stars >= 1000`).Scan(&popular)
-	if err != nil {
-		return
+	defer rows.Close()
+
+	var counts []SourceTypeCount
+	for rows.Next() {
+		var c SourceTypeCount
+		if err := rows.Scan(&c.Name, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
 	}
-	err = db.QueryRow(`SELECT COUNT(*) FROM projects WHERE stars >= 100 AND stars < 1000`).Scan(&notable)
-	return
+	return counts, rows.Err()
 }
 
-// Refresh job operations
+// UnknownLanguage is the bucket used for projects without a primary language
+const UnknownLanguage = "Unknown"
+
+// UnknownLanguageSummary is the language summary's entry for projects without a
+// primary language. The underscore keeps it apart from any real language name.
+const UnknownLanguageSummary = "_unknown"
 
-func (db *DB) CreateRefreshJob() (int64, error) {
-	result, err := db.Exec(`INSERT INTO refresh_jobs (status) VALUES ('pending')`)
+// LanguageCount is the number of projects using a primary language
+type LanguageCount struct {
+	Language string `json:"language"`
+	Count    int    `json:"count"`
+}
+
+// GetLanguageCounts returns active project counts per primary language, most
+// common first. Projects without a language are grouped under UnknownLanguage.
+func (db *DB) GetLanguageCounts() ([]LanguageCount, error) {
+	rows, err := db.Query(`SELECT CASE WHEN COALESCE(primary_language, '') = '' THEN ? ELSE primary_language END AS lang, COUNT(*) AS cnt
+		FROM projects WHERE is_deleted = 0 AND active = 1 GROUP BY lang ORDER BY cnt DESC, lang ASC`, UnknownLanguage)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return result.LastInsertId()
+	defer rows.Close()
+
+	var counts []LanguageCount
+	for rows.Next() {
+		var c LanguageCount
+		if err := rows.Scan(&c.Language, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
 }
 
-func (db *DB) StartRefreshJob(id int64) error {
-	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'running', started_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
-	return err
+// LanguageStats is the number of projects using a primary language and their stars
+type LanguageStats struct {
+	Language string `json:"language"`
+	Count    int    `json:"count"`
+	Stars    int    `json:"stars"`
 }
 
-func (db *DB) CompleteRefreshJob(id int64, projectsFound int) error {
-	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'completed', completed_at = CURRENT_TIMESTAMP, projects_found = ? WHERE id = ?`, projectsFound, id)
-	return err
+// GetLanguageBreakdown returns the project count and total stars per primary
+// language among active projects, most common first. Projects without a language
+// are grouped under UnknownLanguage.
+func (db *DB) GetLanguageBreakdown() ([]LanguageStats, error) {
+	rows, err := db.Query(`SELECT CASE WHEN COALESCE(primary_language, '') = '' THEN ? ELSE primary_language END AS lang, COUNT(*) AS cnt, COALESCE(SUM(stars), 0)
+		FROM projects WHERE is_deleted = 0 AND active = 1 GROUP BY lang ORDER BY cnt DESC, lang ASC`, UnknownLanguage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breakdown := []LanguageStats{}
+	for rows.Next() {
+		var l LanguageStats
+		if err := rows.Scan(&l.Language, &l.Count, &l.Stars); err != nil {
+			return nil, err
+		}
+		breakdown = append(breakdown, l)
+	}
+	return breakdown, rows.Err()
 }
 
-func (db *DB) FailRefreshJob(id int64, errMsg string) error {
-	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'failed', completed_at = CURRENT_TIMESTAMP, error_message = ? WHERE id = ?`, errMsg, id)
-	return err
+// LanguageSummary aggregates the projects using a primary language
+type LanguageSummary struct {
+	Language     string  `json:"language"`
+	ProjectCount int     `json:"project_count"`
+	TotalStars   int     `json:"total_stars"`
+	AvgStars     float64 `json:"avg_stars"` // rounded to one decimal
+	MaxStars     int     `json:"max_stars"`
 }
 
-func (db *DB) GetLatestRefreshJob() (*RefreshJob, error) {
-	row := db.QueryRow(`SELECT id, status, started_at, completed_at, projects_found, error_message, created_at FROM refresh_jobs ORDER BY id DESC LIMIT 1`)
-	var job RefreshJob
-	err := row.Scan(&job.ID, &job.Status, &job.StartedAt, &job.CompletedAt, &job.ProjectsFound, &job.ErrorMessage, &job.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
+// GetLanguageSummary returns project count and star aggregates per primary
+// language among active projects, most common first. Projects
+// without a language are grouped under UnknownLanguageSummary.
+func (db *DB) GetLanguageSummary() ([]LanguageSummary, error) {
+	rows, err := db.Query(`SELECT CASE WHEN COALESCE(primary_language, '') = '' THEN ? ELSE primary_language END AS lang,
+			COUNT(*) AS cnt, COALESCE(SUM(stars), 0), ROUND(COALESCE(AVG(stars), 0), 1), COALESCE(MAX(stars), 0)
+		FROM projects WHERE is_deleted = 0 AND active = 1 GROUP BY lang ORDER BY cnt DESC, lang ASC`, UnknownLanguageSummary)
 	if err != nil {
 		return nil, err
 	}
-	return &job, nil
-}
+	defer rows.Close()
 
-func (db *DB) GetRunningRefreshJob() (*RefreshJob, error) {
-	row := db.QueryRow(`SELECT id, status, started_at, completed_at, projects_found, error_message, created_at FROM refresh_jobs WHERE status = 'running' ORDER BY id DESC LIMIT 1`)
-	var job RefreshJob
-	err := row.Scan(&job.ID, &job.Status, &job.StartedAt, &job.CompletedAt, &job.ProjectsFound, &job.ErrorMessage, &job.CreatedAt)
+	summary := []LanguageSummary{}
+	for rows.Next() {
+		var l LanguageSummary
+		if err := rows.Scan(&l.Language, &l.ProjectCount, &l.TotalStars, &l.AvgStars, &l.MaxStars); err != nil {
+			return nil, err
+		}
+		summary = append(summary, l)
+	}
+	return summary, rows.Err()
+}
+
+// TopicCount is the number of projects tagged with a GitHub topic
+type TopicCount struct {
+	Topic string `json:"topic"`
+	Count int    `json:"count"`
+}
+
+// GetTopicCounts returns active project counts per GitHub topic, most common first
+func (db *DB) GetTopicCounts() ([]TopicCount, error) {
+	rows, err := db.Query(`SELECT t.value, COUNT(*) AS cnt
+		FROM projects, json_each(projects.topics) AS t
+		WHERE projects.is_deleted = 0 AND projects.active = 1 GROUP BY t.value ORDER BY cnt DESC, t.value ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []TopicCount
+	for rows.Next() {
+		var c TopicCount
+		if err := rows.Scan(&c.Topic, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// GetHealthGradeCounts returns the number of active projects with each health grade.
+// Projects that haven't been graded yet are not counted.
+func (db *DB) GetHealthGradeCounts() (map[string]int, error) {
+	rows, err := db.Query(`SELECT health_grade, COUNT(*) FROM projects WHERE health_grade != '' AND is_deleted = 0 AND active = 1 GROUP BY health_grade`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var grade string
+		var n int
+		if err := rows.Scan(&grade, &n); err != nil {
+			return nil, err
+		}
+		counts[grade] = n
+	}
+	return counts, rows.Err()
+}
+
+// StarThresholds are the least stars for a project to count as popular or notable
+// in stats and snapshots. Notable projects are those below Popular.
+type StarThresholds struct {
+	Popular int
+	Notable int
+}
+
+// DefaultStarThresholds count 1000+ stars as popular and 100-999 as notable
+var DefaultStarThresholds = StarThresholds{Popular: 1000, Notable: 100}
+
+// Validate checks that both thresholds are positive and notable is below popular
+func (t StarThresholds) Validate() error {
+	if t.Notable < 1 || t.Popular <= t.Notable {
+		return fmt.Errorf("thresholds must satisfy 0 < notable < popular, got notable %d and popular %d", t.Notable, t.Popular)
+	}
+	return nil
+}
+
+func (db *DB) GetStats(t StarThresholds) (total int, totalStars int, popular int, notable int, err error) {
+	err = db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(stars), 0) FROM projects WHERE is_deleted = 0 AND active = 1`).Scan(&total, &totalStars)
+	if err != nil {
+		return
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM projects WHERE stars >= ? AND is_deleted = 0 AND active = 1`, t.Popular).Scan(&popular)
+	if err != nil {
+		return
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM projects WHERE stars >= ? AND stars < ? AND is_deleted = 0 AND active = 1`, t.Notable, t.Popular).Scan(&notable)
+	return
+}
+
+// Refresh job operations
+
+// RefreshSourceDryRun is the source of jobs that only report what a refresh would
+// find. They write nothing but their job row and diff, so they don't count as the
+// last refresh.
+const RefreshSourceDryRun = "dry_run"
+
+// CreateRefreshJob creates a pending job recording what started it, e.g. "manual"
+func (db *DB) CreateRefreshJob(source string) (int64, error) {
+	result, err := db.Exec(`INSERT INTO refresh_jobs (status, source) VALUES ('pending', ?)`, source)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *DB) StartRefreshJob(id int64) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'running', started_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+func (db *DB) CompleteRefreshJob(id int64, projectsFound int) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'completed', completed_at = CURRENT_TIMESTAMP, projects_found = ? WHERE id = ? AND status != 'cancelled'`, projectsFound, id)
+	return err
+}
+
+// SetRefreshJobDryRunResult stores what a dry run found, as JSON
+func (db *DB) SetRefreshJobDryRunResult(id int64, result []byte) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET dry_run_result = ? WHERE id = ?`, string(result), id)
+	return err
+}
+
+// GetRefreshJobDryRunResult returns the JSON stored by SetRefreshJobDryRunResult,
+// or nil if the job has none, e.g. because it's still running
+func (db *DB) GetRefreshJobDryRunResult(id int64) ([]byte, error) {
+	var result sql.NullString
+	err := db.QueryRow(`SELECT dry_run_result FROM refresh_jobs WHERE id = ?`, id).Scan(&result)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	if err != nil {
+	if err != nil || !result.Valid {
 		return nil, err
 	}
-	return &job, nil
+	return []byte(result.String), nil
+}
+
+// SetRefreshJobETagStats records how many repo detail fetches a job saved with ETags
+func (db *DB) SetRefreshJobETagStats(id int64, hits, misses int) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET etag_hits = ?, etag_misses = ? WHERE id = ?`, hits, misses, id)
+	return err
+}
+
+// SetRefreshJobUsage records how long a job's whole run took and how many GitHub
+// requests it made
+func (db *DB) SetRefreshJobUsage(id int64, duration time.Duration, apiRequests int) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET duration_ms = ?, api_requests_used = ? WHERE id = ?`, duration.Milliseconds(), apiRequests, id)
+	return err
+}
+
+// SetRefreshJobProgress records the phase a running job is in and how far it has got
+func (db *DB) SetRefreshJobProgress(id int64, phase string, current, total int) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET current_phase = ?, current_count = ?, total_count = ? WHERE id = ?`, phase, current, total, id)
+	return err
+}
+
+// GetNextScheduledRefresh returns the persisted next scheduled refresh and the
+// schedule it was computed from, or a nil time if none is stored
+func (db *DB) GetNextScheduledRefresh() (*time.Time, string, error) {
+	var next time.Time
+	var schedule string
+	err := db.QueryRow(`SELECT next_run_at, schedule FROM scheduler_state WHERE id = 1`).Scan(&next, &schedule)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &next, schedule, nil
+}
+
+// SetNextScheduledRefresh persists the next scheduled refresh
+func (db *DB) SetNextScheduledRefresh(next time.Time, schedule string) error {
+	_, err := db.Exec(`INSERT INTO scheduler_state (id, schedule, next_run_at) VALUES (1, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET schedule = excluded.schedule, next_run_at = excluded.next_run_at`, schedule, next.UTC())
+	return err
+}
+
+// GetETag returns the stored ETag and response body for a GitHub API endpoint,
+// or an empty ETag if none is stored
+func (db *DB) GetETag(endpoint string) (string, []byte, error) {
+	var etag string
+	var body []byte
+	err := db.QueryRow(`SELECT etag, body FROM github_etags WHERE endpoint = ?`, endpoint).Scan(&etag, &body)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	return etag, body, err
+}
+
+// PutETag stores the ETag and response body for a GitHub API endpoint
+func (db *DB) PutETag(endpoint, etag string, body []byte) error {
+	_, err := db.Exec(`INSERT INTO github_etags (endpoint, etag, body, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(endpoint) DO UPDATE SET etag = excluded.etag, body = excluded.body, updated_at = CURRENT_TIMESTAMP`,
+		endpoint, etag, body)
+	return err
+}
+
+// FailRefreshJob marks a job failed, unless it was already cancelled
+func (db *DB) FailRefreshJob(id int64, errMsg string) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'failed', completed_at = CURRENT_TIMESTAMP, error_message = ? WHERE id = ? AND status != 'cancelled'`, errMsg, id)
+	return err
+}
+
+// FailStaleRunningJobs marks running jobs started at or before before failed with
+// msg, except keepID. At startup, with before set to now, no job can really be running.
+func (db *DB) FailStaleRunningJobs(before time.Time, keepID int64, msg string) (int, error) {
+	res, err := db.Exec(`UPDATE refresh_jobs SET status = 'failed', completed_at = CURRENT_TIMESTAMP, error_message = ?
+		WHERE status = 'running' AND id != ? AND started_at <= ?`,
+		msg, keepID, before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// DeleteOrphanedRefreshJobs deletes pending jobs created at or before before,
+// except keepID
+func (db *DB) DeleteOrphanedRefreshJobs(before time.Time, keepID int64) (int, error) {
+	res, err := db.Exec(`DELETE FROM refresh_jobs WHERE status = 'pending' AND id != ? AND created_at <= ?`,
+		keepID, before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// CancelRefreshJob marks a pending or running job cancelled. Jobs that already
+// finished keep their status.
+func (db *DB) CancelRefreshJob(id int64, msg string) error {
+	_, err := db.Exec(`UPDATE refresh_jobs SET status = 'cancelled', completed_at = CURRENT_TIMESTAMP, error_message = ? WHERE id = ? AND status IN ('pending', 'running')`, msg, id)
+	return err
+}
+
+// GetRefreshJob returns a refresh job by ID, or nil if it doesn't exist
+func (db *DB) GetRefreshJob(id int64) (*RefreshJob, error) {
+	row := db.QueryRow(`SELECT `+refreshJobColumns+` FROM refresh_jobs WHERE id = ?`, id)
+	job, err := scanRefreshJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (db *DB) GetLatestRefreshJob() (*RefreshJob, error) {
+	row := db.QueryRow(`SELECT ` + refreshJobColumns + ` FROM refresh_jobs ORDER BY id DESC LIMIT 1`)
+	job, err := scanRefreshJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (db *DB) GetRunningRefreshJob() (*RefreshJob, error) {
+	row := db.QueryRow(`SELECT ` + refreshJobColumns + ` FROM refresh_jobs WHERE status = 'running' ORDER BY id DESC LIMIT 1`)
+	job, err := scanRefreshJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
 }
 
+// GetLastCompletedRefreshJob returns the last completed job that refreshed the data,
+// i.e. not a dry run
 func (db *DB) GetLastCompletedRefreshJob() (*RefreshJob, error) {
-	row := db.QueryRow(`SELECT id, status, started_at, completed_at, projects_found, error_message, created_at FROM refresh_jobs WHERE status = 'completed' ORDER BY completed_at DESC LIMIT 1`)
-	var job RefreshJob
-	err := row.Scan(&job.ID, &job.Status, &job.StartedAt, &job.CompletedAt, &job.ProjectsFound, &job.ErrorMessage, &job.CreatedAt)
+	row := db.QueryRow(`SELECT `+refreshJobColumns+` FROM refresh_jobs WHERE status = 'completed' AND source != ? ORDER BY completed_at DESC LIMIT 1`, RefreshSourceDryRun)
+	job, err := scanRefreshJob(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	return job, err
+}
+
+// HasProjects reports whether any project has been stored, including soft-deleted ones
+func (db *DB) HasProjects() (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects)`).Scan(&exists)
+	return exists, err
+}
+
+// ImportBootstrap loads projects from a published dataset into an empty projects
+// table and records a completed "bootstrap" refresh job dated generatedAt, so the
+// data's age is tracked like a refresh. Everything happens in one transaction: on
+// any error, including a non-empty table, nothing is imported.
+func (db *DB) ImportBootstrap(projects []Project, generatedAt time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM projects`).Scan(&existing); err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		return 0, fmt.Errorf("projects table already has %d rows", existing)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO projects (repo_full_name, github_url, stars, description, primary_language, dockerfile_path, file_url, source_type, registry,
+		adopted_at, adoption_commit, adoption_commit_sha, first_seen_at, last_seen_at, deleted_at, is_deleted, active,
+		pushed_at, archived, fork, health_grade, health_score, health_factors)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, COALESCE(NULLIF(?, ''), 'dhi.io'), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, p := range projects {
+		_, err := stmt.Exec(p.RepoFullName, p.GitHubURL, p.Stars, p.Description, p.PrimaryLanguage, p.DockerfilePath, p.FileURL, p.SourceType, p.Registry,
+			p.AdoptedAt, p.AdoptionCommit, p.AdoptionSHA, p.FirstSeenAt, p.LastSeenAt, p.DeletedAt, p.DeletedAt != nil, p.Active,
+			p.PushedAt, p.Archived, p.Fork, p.HealthGrade, p.HealthScore, string(p.HealthFactors))
+		if err != nil {
+			return 0, fmt.Errorf("importing %s: %w", p.RepoFullName, err)
+		}
+	}
+
+	res, err := tx.Exec(`INSERT INTO refresh_jobs (status, started_at, completed_at, projects_found, source) VALUES ('completed', ?, ?, ?, 'bootstrap')`,
+		generatedAt, generatedAt, len(projects))
+	if err != nil {
+		return 0, err
+	}
+	jobID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return jobID, tx.Commit()
+}
+
+// RefreshJobStatuses are the values of RefreshJob.Status
+var RefreshJobStatuses = []string{"pending", "running", "completed", "failed", "cancelled"}
+
+// ListRefreshJobs returns a page of refresh jobs, most recent first, and the
+// total number of jobs. A limit of 0 returns every job.
+func (db *DB) ListRefreshJobs(limit, offset int) ([]RefreshJob, int, error) {
+	return db.ListRefreshJobsByStatus("", limit, offset)
+}
+
+// ListRefreshJobsByStatus is ListRefreshJobs restricted to jobs with the given
+// status, with the total counting only those; "" matches every status
+func (db *DB) ListRefreshJobsByStatus(status string, limit, offset int) ([]RefreshJob, int, error) {
+	where := ""
+	args := []interface{}{}
+	if status != "" {
+		where = " WHERE status = ?"
+		args = append(args, status)
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM refresh_jobs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + refreshJobColumns + ` FROM refresh_jobs` + where + ` ORDER BY created_at DESC, id DESC`
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var jobs []RefreshJob
+	for rows.Next() {
+		job, err := scanRefreshJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, total, rows.Err()
+}
+
+// DeleteRefreshJobsBefore deletes finished (completed, failed or cancelled) jobs
+// created before the given time, with their refresh diffs and search query stats.
+// The latest completed job other than a dry run is kept, since it dates the data.
+// Neither table cascades on delete, so their rows are deleted explicitly first.
+func (db *DB) DeleteRefreshJobsBefore(before time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const match = `status IN ('completed', 'failed', 'cancelled') AND created_at < ?
+		AND id NOT IN (SELECT id FROM refresh_jobs WHERE status = 'completed' AND source != '` + RefreshSourceDryRun + `' ORDER BY completed_at DESC, id DESC LIMIT 1)`
+	// created_at is set by CURRENT_TIMESTAMP, so compare in its UTC text format
+	cutoff := before.UTC().Format("2006-01-02 15:04:05")
+	// refresh_diffs rows go with their jobs through the foreign key cascade
+	if _, err := tx.Exec(`DELETE FROM search_query_stats WHERE job_id IN (SELECT id FROM refresh_jobs WHERE `+match+`)`, cutoff); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM refresh_jobs WHERE `+match, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// Refresh diff operations
+
+// GetAllRepoNames returns the full names of all tracked (not soft-deleted)
+// projects found by searches, leaving out manually submitted ones
+func (db *DB) GetAllRepoNames() ([]string, error) {
+	rows, err := db.Query(`SELECT repo_full_name FROM projects WHERE is_deleted = 0 AND source_type != ?`, SourceTypeManual)
 	if err != nil {
 		return nil, err
 	}
-	return &job, nil
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// RecordRefreshDiff stores which repos a refresh job added and which disappeared
+func (db *DB) RecordRefreshDiff(jobID int64, added, removed []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO refresh_diffs (job_id, repo_full_name, event) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, name := range added {
+		if _, err := stmt.Exec(jobID, name, "added"); err != nil {
+			return err
+		}
+	}
+	for _, name := range removed {
+		if _, err := stmt.Exec(jobID, name, "removed"); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RefreshDiff lists the repos added and removed by a refresh job
+type RefreshDiff struct {
+	JobID   int64    `json:"job_id"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// GetRefreshDiff returns the recorded diff for a refresh job
+func (db *DB) GetRefreshDiff(jobID int64) (*RefreshDiff, error) {
+	rows, err := db.Query(`SELECT repo_full_name, event FROM refresh_diffs WHERE job_id = ? ORDER BY repo_full_name`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diff := &RefreshDiff{JobID: jobID, Added: []string{}, Removed: []string{}}
+	for rows.Next() {
+		var name, event string
+		if err := rows.Scan(&name, &event); err != nil {
+			return nil, err
+		}
+		if event == "added" {
+			diff.Added = append(diff.Added, name)
+		} else {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	return diff, rows.Err()
+}
+
+// Query coverage operations
+
+// RecordQueryMatches stores which search queries matched each repo in a refresh job.
+// matches maps repo full name to query names.
+func (db *DB) RecordQueryMatches(jobID int64, matches map[string][]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO project_query_matches (repo_full_name, query_name, last_job_id)
+	VALUES (?, ?, ?)
+	ON CONFLICT(repo_full_name, query_name) DO UPDATE SET
+		last_matched_at = CURRENT_TIMESTAMP,
+		last_job_id = excluded.last_job_id
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for repo, queries := range matches {
+		for _, q := range queries {
+			if _, err := stmt.Exec(repo, q, jobID); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// QueryMatch is a search query that found a repo
+type QueryMatch struct {
+	Query         string    `json:"query"`
+	LastMatchedAt time.Time `json:"last_matched_at"`
+	LastJobID     int64     `json:"last_job_id"` // refresh job that last found the repo with this query
+}
+
+// GetQueryMatches returns the search queries that have found a repo, most recently
+// matched first
+func (db *DB) GetQueryMatches(repoFullName string) ([]QueryMatch, error) {
+	rows, err := db.Query(`SELECT query_name, last_matched_at, last_job_id FROM project_query_matches
+		WHERE repo_full_name = ? ORDER BY last_job_id DESC, query_name`, repoFullName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []QueryMatch{}
+	for rows.Next() {
+		var m QueryMatch
+		if err := rows.Scan(&m.Query, &m.LastMatchedAt, &m.LastJobID); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// ProjectCoverageDrift is a project that some queries matched historically but not in the latest refresh
+type ProjectCoverageDrift struct {
+	RepoFullName string   `json:"repo_full_name"`
+	Historical   []string `json:"historical"`
+	Current      []string `json:"current"`
+	Lost         []string `json:"lost"`
+}
+
+// QueryCoverage aggregates drift for a single search query
+type QueryCoverage struct {
+	Query      string  `json:"query"`
+	Historical int     `json:"historical"` // still-tracked projects this query has ever matched
+	Lost       int     `json:"lost"`       // of those, projects it no longer matches
+	LostRatio  float64 `json:"lost_ratio"`
+}
+
+// CoverageDrift compares historical and latest query coverage for projects still found by the latest refresh
+type CoverageDrift struct {
+	JobID    int64                  `json:"job_id"`
+	Projects []ProjectCoverageDrift `json:"projects"`
+	Queries  []QueryCoverage        `json:"queries"`
+}
+
+// GetCoverageDrift lists projects found by the most recent refresh whose set of matching
+// queries shrank compared with their history, with per-query totals
+func (db *DB) GetCoverageDrift() (*CoverageDrift, error) {
+	drift := &CoverageDrift{Projects: []ProjectCoverageDrift{}, Queries: []QueryCoverage{}}
+	err := db.QueryRow(`SELECT COALESCE(MAX(last_job_id), 0) FROM project_query_matches`).Scan(&drift.JobID)
+	if err != nil || drift.JobID == 0 {
+		return drift, err
+	}
+
+	rows, err := db.Query(`SELECT repo_full_name, query_name, last_job_id FROM project_query_matches
+		WHERE repo_full_name IN (SELECT repo_full_name FROM project_query_matches WHERE last_job_id = ?)
+		ORDER BY repo_full_name, query_name`, drift.JobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byRepo := make(map[string]*ProjectCoverageDrift)
+	var order []string
+	queries := make(map[string]*QueryCoverage)
+	var queryOrder []string
+	for rows.Next() {
+		var repo, query string
+		var jobID int64
+		if err := rows.Scan(&repo, &query, &jobID); err != nil {
+			return nil, err
+		}
+
+		p, ok := byRepo[repo]
+		if !ok {
+			p = &ProjectCoverageDrift{RepoFullName: repo, Current: []string{}, Lost: []string{}}
+			byRepo[repo] = p
+			order = append(order, repo)
+		}
+		q, ok := queries[query]
+		if !ok {
+			q = &QueryCoverage{Query: query}
+			queries[query] = q
+			queryOrder = append(queryOrder, query)
+		}
+
+		p.Historical = append(p.Historical, query)
+		q.Historical++
+		if jobID == drift.JobID {
+			p.Current = append(p.Current, query)
+		} else {
+			p.Lost = append(p.Lost, query)
+			q.Lost++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, repo := range order {
+		if p := byRepo[repo]; len(p.Lost) > 0 {
+			drift.Projects = append(drift.Projects, *p)
+		}
+	}
+	sort.Strings(queryOrder)
+	for _, name := range queryOrder {
+		q := queries[name]
+		q.LostRatio = float64(q.Lost) / float64(q.Historical)
+		drift.Queries = append(drift.Queries, *q)
+	}
+	return drift, nil
+}
+
+// Maintenance operations
+
+// TableInfo describes a table in the database schema
+type TableInfo struct {
+	Name     string `json:"name"`
+	SQL      string `json:"sql"`
+	RowCount int64  `json:"row_count"`
+}
+
+// SchemaInfo returns the schema user_version and every table with its row count
+func (db *DB) SchemaInfo() (int, []TableInfo, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := db.Query(`SELECT name, COALESCE(sql, '') FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return 0, nil, err
+	}
+	var tables []TableInfo
+	for rows.Next() {
+		var t TableInfo
+		if err := rows.Scan(&t.Name, &t.SQL); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range tables {
+		// Table names come from sqlite_master, not user input
+		if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, tables[i].Name)).Scan(&tables[i].RowCount); err != nil {
+			return 0, nil, err
+		}
+	}
+	return version, tables, nil
 }
 
 // Snapshot operations
 
-// RecordSnapshot saves current stats as a snapshot
-func (db *DB) RecordSnapshot() error {
-	total, totalStars, popular, notable, err := db.GetStats()
+// RecordSnapshot saves current stats as a snapshot, counting popular and notable
+// projects by t. Snapshots keep the counts, not the thresholds, so earlier ones
+// stay as counted under the thresholds of their time.
+func (db *DB) RecordSnapshot(t StarThresholds) error {
+	total, totalStars, popular, notable, err := db.GetStats(t)
 	if err != nil {
 		return fmt.Errorf("getting stats for snapshot: %w", err)
 	}
+	return db.insertSnapshot(total, totalStars, popular, notable)
+}
 
-	_, err = db.Exec(`INSERT INTO refresh_snapshots (total_projects, total_stars, popular_count, notable_count) VALUES (?, ?, ?, ?)`,
+// RecordSnapshotIfChanged saves current stats as a snapshot unless the latest
+// snapshot has the same totals or was recorded less than minInterval ago, so
+// repeated refreshes don't fill the history with duplicate points. It returns
+// whether a snapshot was written. Popular and notable projects are counted by t.
+func (db *DB) RecordSnapshotIfChanged(minInterval time.Duration, t StarThresholds) (bool, error) {
+	total, totalStars, popular, notable, err := db.GetStats(t)
+	if err != nil {
+		return false, fmt.Errorf("getting stats for snapshot: %w", err)
+	}
+
+	latest, err := db.GetSnapshots(SnapshotFilter{Limit: 1})
+	if err != nil {
+		return false, fmt.Errorf("getting latest snapshot: %w", err)
+	}
+	if len(latest) > 0 {
+		l := latest[0]
+		if l.TotalProjects == total && l.TotalStars == totalStars && l.PopularCount == popular && l.NotableCount == notable {
+			return false, nil
+		}
+		if time.Since(l.RecordedAt) < minInterval {
+			return false, nil
+		}
+	}
+	if err := db.insertSnapshot(total, totalStars, popular, notable); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// insertSnapshot saves the given totals with the current language counts
+func (db *DB) insertSnapshot(total, totalStars, popular, notable int) error {
+	languages, err := db.GetLanguageCounts()
+	if err != nil {
+		return fmt.Errorf("getting language counts for snapshot: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO refresh_snapshots (total_projects, total_stars, popular_count, notable_count) VALUES (?, ?, ?, ?)`,
 		total, totalStars, popular, notable)
-	return err
+	if err != nil {
+		return err
+	}
+	snapshotID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	// Store raw languages rather than ecosystems so breakdowns follow mapping changes
+	for _, l := range languages {
+		if _, err := tx.Exec(`INSERT INTO snapshot_languages (snapshot_id, language, project_count) VALUES (?, ?, ?)`,
+			snapshotID, l.Language, l.Count); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SnapshotExistsForDay reports whether the latest snapshot was recorded on the
+// same UTC calendar day as day
+func (db *DB) SnapshotExistsForDay(day time.Time) (bool, error) {
+	var latest time.Time
+	err := db.QueryRow(`SELECT recorded_at FROM refresh_snapshots ORDER BY recorded_at DESC LIMIT 1`).Scan(&latest)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	y1, m1, d1 := latest.UTC().Date()
+	y2, m2, d2 := day.UTC().Date()
+	return y1 == y2 && m1 == m2 && d1 == d2, nil
+}
+
+// GetSnapshotLanguages returns per-language project counts for the given
+// snapshots, keyed by snapshot id
+func (db *DB) GetSnapshotLanguages(ids []int64) (map[int64][]LanguageCount, error) {
+	out := make(map[int64][]LanguageCount)
+	if len(ids) == 0 {
+		return out, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := db.Query(`SELECT snapshot_id, language, project_count FROM snapshot_languages
+		WHERE snapshot_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+		ORDER BY snapshot_id, project_count DESC, language`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var c LanguageCount
+		if err := rows.Scan(&id, &c.Language, &c.Count); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], c)
+	}
+	return out, rows.Err()
 }
 
 // AdoptionByDate represents adoption count for a specific date
@@ -378,14 +1851,100 @@ func (db *DB) GetAdoptionByDate(days int) ([]AdoptionByDate, error) {
 	return results, rows.Err()
 }
 
-// GetSnapshots returns historical snapshots, most recent first
-func (db *DB) GetSnapshots(limit int) ([]RefreshSnapshot, error) {
-	query := `SELECT id, recorded_at, total_projects, total_stars, popular_count, notable_count FROM refresh_snapshots ORDER BY recorded_at DESC`
-	if limit > 0 {
-		query += fmt.Sprintf(" LIMIT %d", limit)
+// LanguageAdoption is the number of projects using a language that adopted DHI on a date
+type LanguageAdoption struct {
+	Date     string `json:"date"`
+	Language string `json:"language"`
+	Count    int    `json:"count"`
+}
+
+// GetAdoptionByDateAndLanguage returns daily adoption counts per primary language for
+// the last days days. Projects without a language are reported as UnknownLanguage.
+func (db *DB) GetAdoptionByDateAndLanguage(days int) ([]LanguageAdoption, error) {
+	rows, err := db.Query(`SELECT date(adopted_at) AS date,
+			CASE WHEN COALESCE(primary_language, '') = '' THEN ? ELSE primary_language END AS lang,
+			COUNT(*)
+		FROM projects
+		WHERE adopted_at IS NOT NULL AND adopted_at >= date('now', ?) AND is_deleted = 0
+		GROUP BY date, lang
+		ORDER BY date, lang`, UnknownLanguage, fmt.Sprintf("-%d days", days))
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	rows, err := db.Query(query)
+	var results []LanguageAdoption
+	for rows.Next() {
+		var r LanguageAdoption
+		if err := rows.Scan(&r.Date, &r.Language, &r.Count); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// SnapshotFilter selects snapshots by when they were recorded
+type SnapshotFilter struct {
+	Since     time.Time // inclusive; zero means no lower bound
+	Until     time.Time // inclusive; zero means no upper bound
+	Limit     int       // 0 means no limit
+	Ascending bool      // oldest first instead of most recent first
+	// Granularity keeps only the last snapshot of each UTC "daily" or "weekly"
+	// (Monday-based) bucket; "" keeps every snapshot
+	Granularity string
+}
+
+// snapshotBuckets maps SnapshotFilter.Granularity values to the SQL giving the
+// date a snapshot's bucket starts on
+var snapshotBuckets = map[string]string{
+	"daily":  "date(recorded_at)",
+	"weekly": "date(recorded_at, '-6 days', 'weekday 1')",
+}
+
+// GetSnapshots returns historical snapshots matching the filter, most recent first
+// unless it asks for ascending order
+func (db *DB) GetSnapshots(filter SnapshotFilter) ([]RefreshSnapshot, error) {
+	bucket := "NULL"
+	if filter.Granularity != "" {
+		var ok bool
+		if bucket, ok = snapshotBuckets[filter.Granularity]; !ok {
+			return nil, fmt.Errorf("invalid granularity %q: must be daily or weekly", filter.Granularity)
+		}
+	}
+
+	where := "1=1"
+	args := []interface{}{}
+	// recorded_at is set by CURRENT_TIMESTAMP, so compare in its UTC text format
+	if !filter.Since.IsZero() {
+		where += " AND recorded_at >= ?"
+		args = append(args, filter.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !filter.Until.IsZero() {
+		where += " AND recorded_at <= ?"
+		args = append(args, filter.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	query := `SELECT id, recorded_at, total_projects, total_stars, popular_count, notable_count, ` + bucket + ` AS bucket
+		FROM refresh_snapshots WHERE ` + where
+	if filter.Granularity != "" {
+		// Rank the snapshots of each bucket, latest first, and keep the first
+		query = `SELECT id, recorded_at, total_projects, total_stars, popular_count, notable_count, bucket FROM (
+			SELECT *, ` + bucket + ` AS bucket,
+				ROW_NUMBER() OVER (PARTITION BY ` + bucket + ` ORDER BY recorded_at DESC, id DESC) AS rn
+			FROM refresh_snapshots WHERE ` + where + `) WHERE rn = 1`
+	}
+	if filter.Ascending {
+		query += " ORDER BY recorded_at ASC, id ASC"
+	} else {
+		query += " ORDER BY recorded_at DESC, id DESC"
+	}
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -394,33 +1953,188 @@ func (db *DB) GetSnapshots(limit int) ([]RefreshSnapshot, error) {
 	var snapshots []RefreshSnapshot
 	for rows.Next() {
 		var s RefreshSnapshot
-		err := rows.Scan(&s.ID, &s.RecordedAt, &s.TotalProjects, &s.TotalStars, &s.PopularCount, &s.NotableCount)
+		var bucket sql.NullString
+		err := rows.Scan(&s.ID, &s.RecordedAt, &s.TotalProjects, &s.TotalStars, &s.PopularCount, &s.NotableCount, &bucket)
 		if err != nil {
 			return nil, err
 		}
+		if bucket.Valid {
+			start, err := time.Parse("2006-01-02", bucket.String)
+			if err != nil {
+				return nil, fmt.Errorf("parsing snapshot bucket: %w", err)
+			}
+			s.BucketStart = &start
+		}
 		snapshots = append(snapshots, s)
 	}
 	return snapshots, rows.Err()
 }
 
-// GetNewProjectsSince returns projects adopted after the given time
-func (db *DB) GetNewProjectsSince(since time.Time) ([]Project, error) {
+// SnapshotDelta is a snapshot with the change in each total since the snapshot before it
+type SnapshotDelta struct {
+	RefreshSnapshot
+	DeltaProjects int `json:"delta_projects"`
+	DeltaStars    int `json:"delta_stars"`
+	DeltaPopular  int `json:"delta_popular"`
+	DeltaNotable  int `json:"delta_notable"`
+}
+
+// snapshotDeltaQuery selects snapshots with deltas from their predecessors by
+// recorded_at. The first snapshot ever has zero deltas.
+const snapshotDeltaQuery = `SELECT id, recorded_at, total_projects, total_stars, popular_count, notable_count,
+		total_projects - COALESCE(prev_projects, total_projects),
+		total_stars - COALESCE(prev_stars, total_stars),
+		popular_count - COALESCE(prev_popular, popular_count),
+		notable_count - COALESCE(prev_notable, notable_count)
+	FROM (SELECT *,
+			LAG(total_projects) OVER w AS prev_projects,
+			LAG(total_stars) OVER w AS prev_stars,
+			LAG(popular_count) OVER w AS prev_popular,
+			LAG(notable_count) OVER w AS prev_notable
+		FROM refresh_snapshots
+		WINDOW w AS (ORDER BY recorded_at, id))`
+
+// GetSnapshotTrends returns the snapshots recorded since the given time, oldest
+// first, with deltas from their predecessors. The first snapshot in the window is
+// compared with the one before the window.
+func (db *DB) GetSnapshotTrends(since time.Time) ([]SnapshotDelta, error) {
+	// recorded_at is set by CURRENT_TIMESTAMP, so compare in its UTC text format
+	return db.querySnapshotDeltas(snapshotDeltaQuery+` WHERE recorded_at >= ? ORDER BY recorded_at, id`,
+		since.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// GetSnapshotDeltas returns the most recent limit snapshots, most recent first,
+// each with its deltas from the snapshot before it
+func (db *DB) GetSnapshotDeltas(limit int) ([]SnapshotDelta, error) {
+	return db.querySnapshotDeltas(snapshotDeltaQuery+` ORDER BY recorded_at DESC, id DESC LIMIT ?`, limit)
+}
+
+func (db *DB) querySnapshotDeltas(query string, args ...interface{}) ([]SnapshotDelta, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deltas []SnapshotDelta
+	for rows.Next() {
+		var d SnapshotDelta
+		if err := rows.Scan(&d.ID, &d.RecordedAt, &d.TotalProjects, &d.TotalStars, &d.PopularCount, &d.NotableCount,
+			&d.DeltaProjects, &d.DeltaStars, &d.DeltaPopular, &d.DeltaNotable); err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas, rows.Err()
+}
+
+// GetSimilarProjects returns up to limit active projects with the same primary
+// language as the given one and within 50% of its stars, closest star count
+// first. Projects without a language have none similar. It returns nil if the
+// project doesn't exist.
+func (db *DB) GetSimilarProjects(projectID int64, limit int) ([]Project, error) {
+	var language string
+	var stars int
+	err := db.QueryRow(`SELECT COALESCE(primary_language, ''), stars FROM projects WHERE id = ?`, projectID).Scan(&language, &stars)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	projects := []Project{}
+	if language == "" {
+		return projects, nil
+	}
+	rows, err := db.Query(`SELECT `+projectColumns+` FROM projects
+		WHERE primary_language = ? AND stars BETWEEN ? AND ? AND id != ? AND is_deleted = 0 AND active = 1
+		ORDER BY ABS(stars - ?), stars DESC, repo_full_name LIMIT ?`,
+		language, stars-stars/2, stars+stars/2, projectID, stars, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Project
+		if err := scanProject(rows, &p); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// TrendingProject is a project with the stars it gained over a period
+type TrendingProject struct {
+	Project
+	StarsGained int      `json:"stars_gained"`
+	GrowthPct   *float64 `json:"growth_pct"` // of the stars at the start; null when that was 0
+}
+
+// GetTrendingProjects returns active projects that gained stars since the given
+// time, fastest growing relative to their stars then first. The baseline is the
+// last star count recorded at or before since, so projects without one, i.e. not
+// tracked yet or only recorded since the history was added, are left out. A
+// baseline of 0 stars ranks as if it were 1.
+func (db *DB) GetTrendingProjects(limit int, since time.Time) ([]TrendingProject, error) {
+	// recorded_at is set by CURRENT_TIMESTAMP, so compare in its UTC text format
+	query := `SELECT ` + projectColumns + `, projects.stars - base.stars AS gained, (projects.stars - base.stars) * 100.0 / NULLIF(base.stars, 0) AS pct_growth
+		FROM projects JOIN (
+			SELECT project_id, stars, ROW_NUMBER() OVER (PARTITION BY project_id ORDER BY recorded_at DESC, id DESC) AS rn
+			FROM project_star_history WHERE recorded_at <= ?
+		) base ON base.project_id = projects.id AND base.rn = 1
+		WHERE is_deleted = 0 AND active = 1 AND projects.stars > base.stars
+		ORDER BY (projects.stars - base.stars) * 1.0 / MAX(base.stars, 1) DESC, gained DESC, projects.repo_full_name`
+	args := []interface{}{since.UTC().Format("2006-01-02 15:04:05")}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []TrendingProject{}
+	for rows.Next() {
+		var t TrendingProject
+		var pct sql.NullFloat64
+		if err := scanProject(rows, &t.Project, &t.StarsGained, &pct); err != nil {
+			return nil, err
+		}
+		if pct.Valid {
+			rounded := math.Round(pct.Float64*10) / 10
+			t.GrowthPct = &rounded
+		}
+		projects = append(projects, t)
+	}
+	return projects, rows.Err()
+}
+
+// GetNewProjectsSince returns projects adopted after the given time with at
+// least minStars stars
+func (db *DB) GetNewProjectsSince(since time.Time, minStars int) ([]Project, error) {
 	query := `SELECT id, repo_full_name, github_url, stars, description, primary_language, dockerfile_path, file_url, source_type, adopted_at, adoption_commit, first_seen_at, last_seen_at, created_at, updated_at 
-		FROM projects WHERE adopted_at IS NOT NULL AND adopted_at > ? ORDER BY adopted_at DESC`
+		FROM projects WHERE adopted_at IS NOT NULL AND adopted_at > ? AND stars >= ? AND is_deleted = 0 ORDER BY adopted_at DESC`
 
-	rows, err := db.Query(query, since)
+	rows, err := db.Query(query, since, minStars)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var projects []Project
+	projects := []Project{}
 	for rows.Next() {
 		var p Project
 		err := rows.Scan(&p.ID, &p.RepoFullName, &p.GitHubURL, &p.Stars, &p.Description, &p.PrimaryLanguage, &p.DockerfilePath, &p.FileURL, &p.SourceType, &p.AdoptedAt, &p.AdoptionCommit, &p.FirstSeenAt, &p.LastSeenAt, &p.CreatedAt, &p.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		p.FilePath = p.DockerfilePath
 		projects = append(projects, p)
 	}
 	return projects, rows.Err()
@@ -429,14 +2143,15 @@ func (db *DB) GetNewProjectsSince(since time.Time) ([]Project, error) {
 // GetNewProjectsCount returns count of projects adopted after the given time
 func (db *DB) GetNewProjectsCount(since time.Time) (int, error) {
 	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM projects WHERE adopted_at IS NOT NULL AND adopted_at > ?`, since).Scan(&count)
+	err := db.QueryRow(`SELECT COUNT(*) FROM projects WHERE adopted_at IS NOT NULL AND adopted_at > ? AND is_deleted = 0`, since).Scan(&count)
 	return count, err
 }
 
-// GetProjectsWithoutAdoptionDate returns projects that need adoption date fetched
+// GetProjectsWithoutAdoptionDate returns projects that need adoption date fetched.
+// Projects without a matched file, submitted without one, have nothing to date.
 func (db *DB) GetProjectsWithoutAdoptionDate() ([]Project, error) {
 	query := `SELECT id, repo_full_name, github_url, stars, description, primary_language, dockerfile_path, file_url, source_type, adopted_at, adoption_commit, first_seen_at, last_seen_at, created_at, updated_at 
-		FROM projects WHERE adopted_at IS NULL`
+		FROM projects WHERE adopted_at IS NULL AND is_deleted = 0 AND dockerfile_path != ''`
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -451,13 +2166,14 @@ func (db *DB) GetProjectsWithoutAdoptionDate() ([]Project, error) {
 		if err != nil {
 			return nil, err
 		}
+		p.FilePath = p.DockerfilePath
 		projects = append(projects, p)
 	}
 	return projects, rows.Err()
 }
 
-// UpdateProjectAdoption sets the adoption date and commit URL for a project
-func (db *DB) UpdateProjectAdoption(id int64, adoptedAt time.Time, commitURL string) error {
-	_, err := db.Exec(`UPDATE projects SET adopted_at = ?, adoption_commit = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, adoptedAt, commitURL, id)
+// UpdateProjectAdoption sets the adoption date and commit for a project
+func (db *DB) UpdateProjectAdoption(id int64, adoptedAt time.Time, commitSHA, commitURL string) error {
+	_, err := db.Exec(`UPDATE projects SET adopted_at = ?, adoption_commit_sha = ?, adoption_commit = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, adoptedAt, commitSHA, commitURL, id)
 	return err
 }
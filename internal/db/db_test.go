@@ -0,0 +1,413 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestDB returns a migrated database in a temporary file. A file rather than
+// :memory: keeps every pooled connection on the same database.
+func newTestDB(t testing.TB) *DB {
+	t.Helper()
+	d, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return d
+}
+
+// addTestProjects stores projects with the given names and stars
+func addTestProjects(t testing.TB, d *DB, stars map[string]int) {
+	t.Helper()
+	for name, n := range stars {
+		p := &Project{RepoFullName: name, GitHubURL: "https://github.com/" + name, Stars: n, SourceType: "Dockerfile"}
+		if err := d.UpsertProject(p); err != nil {
+			t.Fatalf("UpsertProject(%s): %v", name, err)
+		}
+	}
+}
+
+func TestListProjectsStarBounds(t *testing.T) {
+	d := newTestDB(t)
+	addTestProjects(t, d, map[string]int{"a/zero": 0, "a/five": 5, "a/ten": 10, "a/hundred": 100})
+
+	intp := func(n int) *int { return &n }
+	tests := []struct {
+		name     string
+		min, max *int
+		want     []string
+	}{
+		{"no bounds", nil, nil, []string{"a/hundred", "a/ten", "a/five", "a/zero"}},
+		{"min only", intp(10), nil, []string{"a/hundred", "a/ten"}},
+		{"max only", nil, intp(5), []string{"a/five", "a/zero"}},
+		{"max zero", nil, intp(0), []string{"a/zero"}},
+		{"min zero", intp(0), nil, []string{"a/hundred", "a/ten", "a/five", "a/zero"}},
+		{"min equals max", intp(10), intp(10), []string{"a/ten"}},
+		{"both zero", intp(0), intp(0), []string{"a/zero"}},
+		{"range", intp(1), intp(99), []string{"a/ten", "a/five"}},
+		{"min above max", intp(50), intp(5), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projects, err := d.ListProjects(ProjectFilter{MinStars: tt.min, MaxStars: tt.max})
+			if err != nil {
+				t.Fatalf("ListProjects: %v", err)
+			}
+			var got []string
+			for _, p := range projects {
+				got = append(got, p.RepoFullName)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordSnapshotIfChanged(t *testing.T) {
+	d := newTestDB(t)
+	record := func(minInterval time.Duration) bool {
+		t.Helper()
+		wrote, err := d.RecordSnapshotIfChanged(minInterval, DefaultStarThresholds)
+		if err != nil {
+			t.Fatalf("RecordSnapshotIfChanged: %v", err)
+		}
+		return wrote
+	}
+	count := func() int {
+		t.Helper()
+		snapshots, err := d.GetSnapshots(SnapshotFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(snapshots)
+	}
+
+	addTestProjects(t, d, map[string]int{"a/one": 10})
+	if !record(time.Hour) {
+		t.Fatal("first snapshot not written")
+	}
+
+	// Unchanged totals are never recorded again, however long ago the last one was
+	if record(0) {
+		t.Error("snapshot written although nothing changed")
+	}
+
+	// Changed totals wait for minInterval
+	addTestProjects(t, d, map[string]int{"a/two": 20})
+	if record(time.Hour) {
+		t.Error("snapshot written within minInterval of the last one")
+	}
+	if count() != 1 {
+		t.Fatalf("%d snapshots, want 1", count())
+	}
+
+	if _, err := d.Exec(`UPDATE refresh_snapshots SET recorded_at = datetime('now', '-2 hours')`); err != nil {
+		t.Fatal(err)
+	}
+	if !record(time.Hour) {
+		t.Error("changed snapshot not written after minInterval")
+	}
+	if count() != 2 {
+		t.Errorf("%d snapshots, want 2", count())
+	}
+	if latest, _ := d.GetSnapshots(SnapshotFilter{Limit: 1}); len(latest) != 1 || latest[0].TotalProjects != 2 || latest[0].TotalStars != 30 {
+		t.Errorf("latest snapshot %+v, want 2 projects with 30 stars", latest)
+	}
+}
+
+func TestUpsertProjectsRollsBack(t *testing.T) {
+	d := newTestDB(t)
+	addTestProjects(t, d, map[string]int{"a/one": 10})
+	// Fail the batch part way through
+	if _, err := d.Exec(`CREATE TRIGGER fail_bad_repo BEFORE INSERT ON projects WHEN NEW.repo_full_name = 'bad/repo'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatal(err)
+	}
+	var history int
+	d.QueryRow(`SELECT COUNT(*) FROM project_star_history`).Scan(&history)
+
+	err := d.UpsertProjects([]*Project{
+		{RepoFullName: "a/one", GitHubURL: "https://github.com/a/one", Stars: 50, SourceType: "Dockerfile"},
+		{RepoFullName: "a/new", GitHubURL: "https://github.com/a/new", Stars: 1, SourceType: "Dockerfile"},
+		{RepoFullName: "bad/repo", GitHubURL: "https://github.com/bad/repo", SourceType: "Dockerfile"},
+	})
+	if err == nil {
+		t.Fatal("UpsertProjects succeeded despite the failing row")
+	}
+
+	var stars int
+	if err := d.QueryRow(`SELECT stars FROM projects WHERE repo_full_name = 'a/one'`).Scan(&stars); err != nil || stars != 10 {
+		t.Errorf("a/one after rollback: %d stars, %v; want 10", stars, err)
+	}
+	if id, err := d.GetProjectID("a/new"); err != sql.ErrNoRows {
+		t.Errorf("a/new saved by a failed batch: id %d, %v", id, err)
+	}
+	var after int
+	d.QueryRow(`SELECT COUNT(*) FROM project_star_history`).Scan(&after)
+	if after != history {
+		t.Errorf("star history went from %d to %d rows in a failed batch", history, after)
+	}
+}
+
+func benchmarkProjects(n int) []*Project {
+	projects := make([]*Project, n)
+	for i := range projects {
+		name := fmt.Sprintf("bench/repo-%d", i)
+		projects[i] = &Project{RepoFullName: name, GitHubURL: "https://github.com/" + name, Stars: i, SourceType: "Dockerfile", Registry: "dhi.io"}
+	}
+	return projects
+}
+
+// BenchmarkUpsertProjects saves a refresh-sized batch in one transaction
+func BenchmarkUpsertProjects(b *testing.B) {
+	d := newTestDB(b)
+	projects := benchmarkProjects(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range projects {
+			p.Stars++
+		}
+		if err := d.UpsertProjects(projects); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUpsertProjectEach saves the same batch with a transaction per project,
+// as refreshes did before UpsertProjects
+func BenchmarkUpsertProjectEach(b *testing.B) {
+	d := newTestDB(b)
+	projects := benchmarkProjects(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range projects {
+			p.Stars++
+			if err := d.UpsertProject(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestGetLanguageSummary(t *testing.T) {
+	d := newTestDB(t)
+	for _, p := range []*Project{
+		{RepoFullName: "a/go1", PrimaryLanguage: "Go", Stars: 10},
+		{RepoFullName: "a/go2", PrimaryLanguage: "Go", Stars: 5},
+		{RepoFullName: "a/go3", PrimaryLanguage: "Go", Stars: 0},
+		{RepoFullName: "a/py", PrimaryLanguage: "Python", Stars: 7},
+		{RepoFullName: "a/none1", Stars: 3},
+		{RepoFullName: "a/none2", Stars: 4},
+		{RepoFullName: "a/gone", PrimaryLanguage: "Rust", Stars: 100},
+	} {
+		p.GitHubURL, p.SourceType = "https://github.com/"+p.RepoFullName, "Dockerfile"
+		if err := d.UpsertProject(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gone, err := d.GetProjectID("a/gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SoftDeleteProject(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.GetLanguageSummary()
+	if err != nil {
+		t.Fatalf("GetLanguageSummary: %v", err)
+	}
+	want := []LanguageSummary{
+		{Language: "Go", ProjectCount: 3, TotalStars: 15, AvgStars: 5, MaxStars: 10},
+		{Language: UnknownLanguageSummary, ProjectCount: 2, TotalStars: 7, AvgStars: 3.5, MaxStars: 4},
+		{Language: "Python", ProjectCount: 1, TotalStars: 7, AvgStars: 7, MaxStars: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetLanguageSummary =\n%+v\nwant\n%+v", got, want)
+	}
+
+	for _, lang := range []string{UnknownLanguageSummary, UnknownLanguage} {
+		projects, err := d.ListProjects(ProjectFilter{Language: lang})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(projects) != 2 {
+			t.Errorf("ListProjects(language=%s) returned %d projects, want 2", lang, len(projects))
+		}
+	}
+}
+
+func TestAggregatesSkipInactiveProjects(t *testing.T) {
+	d := newTestDB(t)
+	for _, p := range []*Project{
+		{RepoFullName: "a/live", PrimaryLanguage: "Go", Stars: 2000, Topics: []string{"docker"}, HealthGrade: "A"},
+		{RepoFullName: "a/stale", PrimaryLanguage: "Rust", Stars: 500, Topics: []string{"docker", "wasm"}, HealthGrade: "C", SourceType: "Compose"},
+	} {
+		p.GitHubURL = "https://github.com/" + p.RepoFullName
+		if p.SourceType == "" {
+			p.SourceType = "Dockerfile"
+		}
+		if err := d.UpsertProject(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Exec(`UPDATE projects SET active = 0 WHERE repo_full_name = 'a/stale'`); err != nil {
+		t.Fatal(err)
+	}
+
+	total, stars, popular, notable, err := d.GetStats(DefaultStarThresholds)
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if total != 1 || stars != 2000 || popular != 1 || notable != 0 {
+		t.Errorf("GetStats = %d, %d, %d, %d; want 1, 2000, 1, 0", total, stars, popular, notable)
+	}
+
+	langs, err := d.GetLanguageCounts()
+	if err != nil {
+		t.Fatalf("GetLanguageCounts: %v", err)
+	}
+	if want := []LanguageCount{{Language: "Go", Count: 1}}; !reflect.DeepEqual(langs, want) {
+		t.Errorf("GetLanguageCounts = %+v, want %+v", langs, want)
+	}
+
+	summary, err := d.GetLanguageSummary()
+	if err != nil {
+		t.Fatalf("GetLanguageSummary: %v", err)
+	}
+	if len(summary) != 1 || summary[0].Language != "Go" {
+		t.Errorf("GetLanguageSummary = %+v, want only Go", summary)
+	}
+
+	sources, err := d.GetSourceTypeCounts()
+	if err != nil {
+		t.Fatalf("GetSourceTypeCounts: %v", err)
+	}
+	if want := []SourceTypeCount{{Name: "Dockerfile", Count: 1}}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("GetSourceTypeCounts = %+v, want %+v", sources, want)
+	}
+
+	topics, err := d.GetTopicCounts()
+	if err != nil {
+		t.Fatalf("GetTopicCounts: %v", err)
+	}
+	if want := []TopicCount{{Topic: "docker", Count: 1}}; !reflect.DeepEqual(topics, want) {
+		t.Errorf("GetTopicCounts = %+v, want %+v", topics, want)
+	}
+
+	grades, err := d.GetHealthGradeCounts()
+	if err != nil {
+		t.Fatalf("GetHealthGradeCounts: %v", err)
+	}
+	if len(grades) != 1 || grades["A"] != 1 {
+		t.Errorf("GetHealthGradeCounts = %v, want only A: 1", grades)
+	}
+}
+
+func TestDeleteRefreshJobsBeforeRemovesSearchQueryStats(t *testing.T) {
+	d := newTestDB(t)
+
+	kept, err := d.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CompleteRefreshJob(kept, 1); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := d.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.FailRefreshJob(pruned, "boom"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{kept, pruned} {
+		if err := d.RecordSearchQueryStats(id, []SearchQueryStat{{QueryName: "Dockerfile", Repos: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.RecordRefreshDiff(id, []string{"a/b"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := d.DeleteRefreshJobsBefore(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteRefreshJobsBefore: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d jobs, want 1", n)
+	}
+	if stats, err := d.GetSearchQueryStats(pruned); err != nil || len(stats) != 0 {
+		t.Errorf("stats of pruned job = %v, %v; want none", stats, err)
+	}
+	if stats, err := d.GetSearchQueryStats(kept); err != nil || len(stats) != 1 {
+		t.Errorf("stats of kept job = %v, %v; want 1", stats, err)
+	}
+	if diff, err := d.GetRefreshDiff(pruned); err != nil || len(diff.Added) != 0 {
+		t.Errorf("diff of pruned job = %+v, %v; want none", diff, err)
+	}
+	if diff, err := d.GetRefreshDiff(kept); err != nil || len(diff.Added) != 1 {
+		t.Errorf("diff of kept job = %+v, %v; want a/b added", diff, err)
+	}
+}
+
+func TestMigrateCascadesRefreshDiffs(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	// refresh_diffs as created before job_id cascaded
+	for _, stmt := range []string{
+		`CREATE TABLE refresh_jobs (id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL DEFAULT 'pending',
+			started_at TIMESTAMP, completed_at TIMESTAMP, projects_found INTEGER DEFAULT 0, error_message TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE refresh_diffs (id INTEGER PRIMARY KEY AUTOINCREMENT, job_id INTEGER NOT NULL REFERENCES refresh_jobs(id),
+			repo_full_name TEXT NOT NULL, event TEXT NOT NULL CHECK(event IN ('added', 'removed')),
+			recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO refresh_jobs (id, status) VALUES (1, 'completed'), (2, 'failed')`,
+		`INSERT INTO refresh_diffs (job_id, repo_full_name, event) VALUES (1, 'a/one', 'added'), (2, 'a/two', 'removed')`,
+	} {
+		if _, err := d.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := d.Migrate(); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	if diff, err := d.GetRefreshDiff(2); err != nil || diff == nil || len(diff.Removed) != 1 {
+		t.Fatalf("diff of job 2 after migrating = %+v, %v; want a/two removed", diff, err)
+	}
+	if _, err := d.Exec(`DELETE FROM refresh_jobs WHERE id = 2`); err != nil {
+		t.Fatalf("deleting a job with diffs: %v", err)
+	}
+	var left []string
+	rows, err := d.Query(`SELECT repo_full_name FROM refresh_diffs`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		left = append(left, name)
+	}
+	if !reflect.DeepEqual(left, []string{"a/one"}) {
+		t.Errorf("refresh_diffs after deleting job 2 = %v, want [a/one]", left)
+	}
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Exclusion is a repo kept out of the tracker, e.g. a documentation mirror that
+// matches the searches. Exclusions are stored in project_suppressions.
+type Exclusion struct {
+	RepoFullName string    `json:"repo_full_name"`
+	Reason       string    `json:"reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// suppressedRepos returns the names of excluded repos, read with query, e.g. a
+// transaction's Query
+func suppressedRepos(query func(string, ...interface{}) (*sql.Rows, error)) (map[string]bool, error) {
+	rows, err := query(`SELECT repo_full_name FROM project_suppressions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressed := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		suppressed[name] = true
+	}
+	return suppressed, rows.Err()
+}
+
+// DeleteProject removes a false positive: the project is soft-deleted and its
+// name excluded, so refreshes that find it again don't add it back. It reports
+// whether a tracked project had that name.
+func (db *DB) DeleteProject(repoFullName string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := softDeleteTracked(tx, repoFullName)
+	if err != nil || !deleted {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO project_suppressions (repo_full_name) VALUES (?)`, repoFullName); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ExcludeRepo keeps a repo out of the tracker, whether or not it's tracked yet.
+// A tracked project is soft-deleted at once, so it leaves listings and stats.
+// Excluding an excluded repo updates the reason. It reports whether a tracked
+// project was removed.
+func (db *DB) ExcludeRepo(repoFullName, reason string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := softDeleteTracked(tx, repoFullName)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO project_suppressions (repo_full_name, reason) VALUES (?, ?)
+		ON CONFLICT(repo_full_name) DO UPDATE SET reason = excluded.reason`, repoFullName, reason); err != nil {
+		return false, err
+	}
+	return deleted, tx.Commit()
+}
+
+// softDeleteTracked soft-deletes the tracked project with the name and reports
+// whether there was one
+func softDeleteTracked(tx *sql.Tx, repoFullName string) (bool, error) {
+	res, err := tx.Exec(`UPDATE projects SET is_deleted = 1, deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE repo_full_name = ? AND is_deleted = 0`, repoFullName)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveExclusion lets refreshes track a repo again. The project stays
+// soft-deleted until a refresh finds it. It returns false if the repo wasn't excluded.
+func (db *DB) RemoveExclusion(repoFullName string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM project_suppressions WHERE repo_full_name = ?`, repoFullName)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListExclusions returns every excluded repo, newest first
+func (db *DB) ListExclusions() ([]Exclusion, error) {
+	rows, err := db.Query(`SELECT repo_full_name, COALESCE(reason, ''), created_at FROM project_suppressions ORDER BY created_at DESC, repo_full_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exclusions := []Exclusion{}
+	for rows.Next() {
+		var e Exclusion
+		if err := rows.Scan(&e.RepoFullName, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		exclusions = append(exclusions, e)
+	}
+	return exclusions, rows.Err()
+}
+
+// ExcludedRepos returns the names of every excluded repo
+func (db *DB) ExcludedRepos() (map[string]bool, error) {
+	return suppressedRepos(db.Query)
+}
+
+// IsSuppressed reports whether a repo is excluded
+func (db *DB) IsSuppressed(repoFullName string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM project_suppressions WHERE repo_full_name = ?`, repoFullName).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// WatchedFileMinStars is the star count from which a project's matched file is
+// tracked for changes; the default threshold of popular projects in stats. Files of
+// other projects are read once, for the images they use.
+const WatchedFileMinStars = 1000
+
+// maxFileChanges is how many changes are kept per project
+const maxFileChanges = 20
+
+// FileChange is a change to the registry-relevant lines of a project's matched file
+type FileChange struct {
+	ID         int64     `json:"id"`
+	JobID      int64     `json:"job_id"`
+	FilePath   string    `json:"file_path" tier:"partner"`
+	OldHash    string    `json:"old_hash"`
+	NewHash    string    `json:"new_hash"`
+	Diff       string    `json:"diff,omitempty" tier:"partner"`
+	Skipped    string    `json:"skipped,omitempty"` // "binary" or "too_large" when there is no diff
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// WatchedFile is a project whose matched file is tracked, with the last version seen
+type WatchedFile struct {
+	ProjectID    int64
+	RepoFullName string
+	FilePath     string
+	Registry     string
+	Hash         string
+	Lines        []string
+	Images       []string
+}
+
+// GetWatchedFiles returns the matched files of active popular projects, and of
+// other active projects whose file hasn't been read yet, most starred first
+func (db *DB) GetWatchedFiles() ([]WatchedFile, error) {
+	rows, err := db.Query(`SELECT id, repo_full_name, dockerfile_path, registry, file_hash, file_lines, dhi_images FROM projects
+		WHERE (stars >= ? OR file_hash = '') AND is_deleted = 0 AND active = 1 AND dockerfile_path != ''
+		ORDER BY stars DESC`, WatchedFileMinStars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []WatchedFile
+	for rows.Next() {
+		var f WatchedFile
+		var lines, images string
+		if err := rows.Scan(&f.ProjectID, &f.RepoFullName, &f.FilePath, &f.Registry, &f.Hash, &lines, &images); err != nil {
+			return nil, err
+		}
+		if lines != "" {
+			f.Lines = strings.Split(lines, "\n")
+		}
+		if f.Images, err = scanJSONStrings(images); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// SaveFileVersion stores the latest hash, relevant lines and images of a
+// project's file. If change is not nil it is recorded too, and changes beyond the
+// last maxFileChanges of the project are removed.
+func (db *DB) SaveFileVersion(projectID int64, hash string, lines, images []string, jobID int64, change *FileChange) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE projects SET file_hash = ?, file_lines = ?, dhi_images = ? WHERE id = ?`,
+		hash, strings.Join(lines, "\n"), jsonStrings(images), projectID); err != nil {
+		return err
+	}
+	if change != nil {
+		_, err := tx.Exec(`INSERT INTO file_changes (project_id, job_id, file_path, old_hash, new_hash, diff, skipped) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			projectID, jobID, change.FilePath, change.OldHash, change.NewHash, change.Diff, change.Skipped)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM file_changes WHERE project_id = ? AND id NOT IN (
+			SELECT id FROM file_changes WHERE project_id = ? ORDER BY id DESC LIMIT ?)`, projectID, projectID, maxFileChanges)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetProjectImages stores the images a project's file uses
+func (db *DB) SetProjectImages(projectID int64, images []string) error {
+	_, err := db.Exec(`UPDATE projects SET dhi_images = ? WHERE id = ?`, jsonStrings(images), projectID)
+	return err
+}
+
+// GetFileChanges returns the recorded changes to a project's file, newest first.
+// It returns nil if the project doesn't exist.
+func (db *DB) GetFileChanges(repoFullName string) ([]FileChange, error) {
+	var projectID int64
+	err := db.QueryRow(`SELECT id FROM projects WHERE repo_full_name = ?`, repoFullName).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT id, job_id, file_path, old_hash, new_hash, diff, skipped, recorded_at FROM file_changes
+		WHERE project_id = ? ORDER BY id DESC`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []FileChange{}
+	for rows.Next() {
+		var c FileChange
+		if err := rows.Scan(&c.ID, &c.JobID, &c.FilePath, &c.OldHash, &c.NewHash, &c.Diff, &c.Skipped, &c.RecordedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// ImageCount is the number of projects using a registry image
+type ImageCount struct {
+	Image string `json:"image"` // image name without the tag, e.g. golang
+	Count int    `json:"count"`
+}
+
+// GetImageCounts returns how many projects use each image, whatever the tag,
+// most used first
+func (db *DB) GetImageCounts() ([]ImageCount, error) {
+	rows, err := db.Query(`SELECT CASE WHEN instr(i.value, ':') > 0 THEN substr(i.value, 1, instr(i.value, ':') - 1) ELSE i.value END AS image,
+			COUNT(DISTINCT projects.id) AS cnt
+		FROM projects, json_each(projects.dhi_images) AS i
+		WHERE projects.is_deleted = 0 GROUP BY image ORDER BY cnt DESC, image ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ImageCount
+	for rows.Next() {
+		var c ImageCount
+		if err := rows.Scan(&c.Image, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Full-text search over projects uses projects_fts, an FTS5 index of
+// repo_full_name and description kept in sync with projects by triggers. FTS5 is
+// only compiled into SQLite with the sqlite_fts5 build tag; without it search
+// falls back to LIKE matching.
+
+// ftsTriggers keep projects_fts in step with projects. The index reads its text
+// from projects, so deletes must pass the old values.
+var ftsTriggers = map[string]string{
+	"projects_fts_ai": `CREATE TRIGGER projects_fts_ai AFTER INSERT ON projects BEGIN
+		INSERT INTO projects_fts(rowid, repo_full_name, description) VALUES (new.id, new.repo_full_name, new.description);
+	END`,
+	"projects_fts_ad": `CREATE TRIGGER projects_fts_ad AFTER DELETE ON projects BEGIN
+		INSERT INTO projects_fts(projects_fts, rowid, repo_full_name, description) VALUES ('delete', old.id, old.repo_full_name, old.description);
+	END`,
+	"projects_fts_au": `CREATE TRIGGER projects_fts_au AFTER UPDATE OF repo_full_name, description ON projects BEGIN
+		INSERT INTO projects_fts(projects_fts, rowid, repo_full_name, description) VALUES ('delete', old.id, old.repo_full_name, old.description);
+		INSERT INTO projects_fts(rowid, repo_full_name, description) VALUES (new.id, new.repo_full_name, new.description);
+	END`,
+}
+
+// migrateFTS creates the search index and its triggers when SQLite has FTS5,
+// rebuilding the index if the triggers were missing, e.g. on first run or after
+// running a build without FTS5. Without FTS5 it drops the triggers, which would
+// otherwise fail every write to projects in a database indexed by an FTS5 build.
+func (db *DB) migrateFTS() error {
+	var enabled bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return fmt.Errorf("checking for FTS5: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !enabled {
+		for name := range ftsTriggers {
+			if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return fmt.Errorf("dropping %s: %w", name, err)
+			}
+		}
+		db.fts = false
+		return tx.Commit()
+	}
+
+	if _, err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS projects_fts USING fts5(
+		repo_full_name, description, content='projects', content_rowid='id')`); err != nil {
+		return fmt.Errorf("creating projects_fts: %w", err)
+	}
+	rebuild := false
+	for name, stmt := range ftsTriggers {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?`, name).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("creating %s: %w", name, err)
+		}
+		rebuild = true
+	}
+	if rebuild {
+		if _, err := tx.Exec(`INSERT INTO projects_fts(projects_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("rebuilding projects_fts: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.fts = true
+	return nil
+}
+
+// FTSEnabled reports whether project search uses the full-text index
+func (db *DB) FTSEnabled() bool {
+	return db.fts
+}
+
+// ftsQuery turns free text into an FTS5 query matching every word as a prefix.
+// Words are quoted so punctuation and FTS5 operators in them are taken literally.
+func ftsQuery(search string) string {
+	words := strings.Fields(search)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// Highlighted matches come back from SQLite between these control characters,
+// char(1) and char(2) in projectQuery, rather than <mark> tags, so the text
+// around them can be HTML-escaped first
+const (
+	highlightStart = "\x01"
+	highlightEnd   = "\x02"
+)
+
+// markHighlights HTML-escapes highlighted text and marks the matches with <mark>
+func markHighlights(s string) string {
+	if s == "" {
+		return ""
+	}
+	return strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>").Replace(html.EscapeString(s))
+}
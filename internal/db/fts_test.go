@@ -0,0 +1,56 @@
+//go:build sqlite_fts5
+
+package db
+
+import "testing"
+
+func TestFTSSearch(t *testing.T) {
+	d := newTestDB(t)
+	if !d.FTSEnabled() {
+		t.Fatal("FTS5 not enabled in a sqlite_fts5 build")
+	}
+	for _, p := range []*Project{
+		{RepoFullName: "acme/widget", Description: "A small tool", Stars: 1},
+		{RepoFullName: "acme/tools", Description: "Widgets for <script>alert(1)</script> & friends", Stars: 500},
+		{RepoFullName: "acme/other", Description: "Nothing to see", Stars: 1000},
+	} {
+		p.GitHubURL, p.SourceType = "https://github.com/"+p.RepoFullName, "Dockerfile"
+		if err := d.UpsertProject(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := d.ListProjects(ProjectFilter{Search: "widg"})
+	if err != nil {
+		t.Fatalf("ListProjects: %v", err)
+	}
+	// The name match ranks above the description match despite fewer stars
+	if len(got) != 2 || got[0].RepoFullName != "acme/widget" || got[1].RepoFullName != "acme/tools" {
+		t.Fatalf("search widg = %v, want acme/widget then acme/tools", got)
+	}
+	if got[0].HighlightName != "acme/<mark>widget</mark>" {
+		t.Errorf("HighlightName = %q", got[0].HighlightName)
+	}
+	want := "<mark>Widgets</mark> for &lt;script&gt;alert(1)&lt;/script&gt; &amp; friends"
+	if got[1].HighlightDesc != want {
+		t.Errorf("HighlightDesc = %q, want %q", got[1].HighlightDesc, want)
+	}
+
+	// An explicit sort replaces relevance order
+	got, err = d.ListProjects(ProjectFilter{Search: "widg", SortBy: "stars"})
+	if err != nil {
+		t.Fatalf("ListProjects sorted: %v", err)
+	}
+	if len(got) != 2 || got[0].RepoFullName != "acme/tools" {
+		t.Errorf("search widg by stars = %v, want acme/tools first", got)
+	}
+
+	// Matches inside escaped markup are marked without unescaping it
+	got, err = d.ListProjects(ProjectFilter{Search: "script"})
+	if err != nil {
+		t.Fatalf("ListProjects script: %v", err)
+	}
+	if len(got) != 1 || got[0].HighlightDesc != "Widgets for &lt;<mark>script</mark>&gt;alert(1)&lt;/<mark>script</mark>&gt; &amp; friends" {
+		t.Errorf("search script = %+v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import "time"
+
+// ProjectNote is an annotation on a project, e.g. why it was added by hand or flagged
+type ProjectNote struct {
+	ID        int64     `json:"id"`
+	ProjectID int64     `json:"project_id"`
+	Note      string    `json:"note"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const projectNoteColumns = `id, project_id, note, created_by, created_at`
+
+func scanProjectNote(row interface{ Scan(...interface{}) error }) (*ProjectNote, error) {
+	var n ProjectNote
+	if err := row.Scan(&n.ID, &n.ProjectID, &n.Note, &n.CreatedBy, &n.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// CreateNote adds a note to a project. The project must exist.
+func (db *DB) CreateNote(projectID int64, note, createdBy string) (*ProjectNote, error) {
+	res, err := db.Exec(`INSERT INTO project_notes (project_id, note, created_by) VALUES (?, ?, ?)`, projectID, note, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return scanProjectNote(db.QueryRow(`SELECT `+projectNoteColumns+` FROM project_notes WHERE id = ?`, id))
+}
+
+// ListNotes returns the notes on a project, newest first
+func (db *DB) ListNotes(projectID int64) ([]ProjectNote, error) {
+	rows, err := db.Query(`SELECT `+projectNoteColumns+` FROM project_notes WHERE project_id = ? ORDER BY created_at DESC, id DESC`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []ProjectNote{}
+	for rows.Next() {
+		n, err := scanProjectNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *n)
+	}
+	return notes, rows.Err()
+}
+
+// DeleteNote removes a note. It returns false if no note has the id.
+func (db *DB) DeleteNote(id int64) (bool, error) {
+	res, err := db.Exec(`DELETE FROM project_notes WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
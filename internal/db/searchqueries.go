@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// SearchQuery is a code search query run by refreshes, managed through the API
+type SearchQuery struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	Registry  string    `json:"registry"` // registry host the query looks for
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrSearchQueryExists is returned by AddSearchQuery for a name already in use
+var ErrSearchQueryExists = errors.New("a search query with that name already exists")
+
+const searchQueryColumns = `id, name, query, registry, enabled, created_at`
+
+func scanSearchQuery(row interface{ Scan(...interface{}) error }) (*SearchQuery, error) {
+	var q SearchQuery
+	if err := row.Scan(&q.ID, &q.Name, &q.Query, &q.Registry, &q.Enabled, &q.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// ListSearchQueries returns every stored query, disabled ones included, oldest first
+func (db *DB) ListSearchQueries() ([]SearchQuery, error) {
+	rows, err := db.Query(`SELECT ` + searchQueryColumns + ` FROM search_queries ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queries := []SearchQuery{}
+	for rows.Next() {
+		q, err := scanSearchQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, *q)
+	}
+	return queries, rows.Err()
+}
+
+// AddSearchQuery stores a new enabled query
+func (db *DB) AddSearchQuery(name, query, registry string) (*SearchQuery, error) {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM search_queries WHERE name = ?`, name).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, ErrSearchQueryExists
+	}
+	res, err := db.Exec(`INSERT INTO search_queries (name, query, registry) VALUES (?, ?, ?)`, name, query, registry)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchQuery(db.QueryRow(`SELECT `+searchQueryColumns+` FROM search_queries WHERE id = ?`, id))
+}
+
+// RemoveSearchQuery deletes a query. It returns false if no query has the id.
+func (db *DB) RemoveSearchQuery(id int64) (bool, error) {
+	res, err := db.Exec(`DELETE FROM search_queries WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ToggleSearchQuery enables or disables a query. It returns false if no query has the id.
+func (db *DB) ToggleSearchQuery(id int64, enabled bool) (bool, error) {
+	res, err := db.Exec(`UPDATE search_queries SET enabled = ? WHERE id = ?`, enabled, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SeedSearchQueries stores the given queries if none are stored yet, and returns
+// how many it stored
+func (db *DB) SeedSearchQueries(queries []SearchQuery) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM search_queries`).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return 0, nil
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(`INSERT INTO search_queries (name, query, registry) VALUES (?, ?, ?)`, q.Name, q.Query, q.Registry); err != nil {
+			return 0, err
+		}
+	}
+	return len(queries), tx.Commit()
+}
+
+// SearchQueryStat is how a search query fared in a refresh job
+type SearchQueryStat struct {
+	JobID          int64  `json:"job_id"`
+	QueryName      string `json:"query_name"`
+	Repos          int    `json:"repos"`        // distinct repos the query matched
+	UniqueRepos    int    `json:"unique_repos"` // repos no earlier query of the job had found
+	Pages          int    `json:"pages"`
+	DurationMS     int64  `json:"duration_ms"`
+	HitGitHubLimit bool   `json:"hit_github_limit"` // results were cut off at GitHub's 1000
+}
+
+// RecordSearchQueryStats stores the search stats of a refresh job, replacing any
+// it already has for the same queries
+func (db *DB) RecordSearchQueryStats(jobID int64, stats []SearchQueryStat) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, st := range stats {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO search_query_stats (job_id, query_name, repos, unique_repos, pages, duration_ms, hit_github_limit)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, jobID, st.QueryName, st.Repos, st.UniqueRepos, st.Pages, st.DurationMS, st.HitGitHubLimit); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSearchQueryStats returns the search stats of a refresh job in query name order
+func (db *DB) GetSearchQueryStats(jobID int64) ([]SearchQueryStat, error) {
+	rows, err := db.Query(`SELECT job_id, query_name, repos, unique_repos, pages, duration_ms, hit_github_limit
+		FROM search_query_stats WHERE job_id = ? ORDER BY query_name`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []SearchQueryStat{}
+	for rows.Next() {
+		var st SearchQueryStat
+		if err := rows.Scan(&st.JobID, &st.QueryName, &st.Repos, &st.UniqueRepos, &st.Pages, &st.DurationMS, &st.HitGitHubLimit); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
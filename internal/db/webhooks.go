@@ -0,0 +1,46 @@
+package db
+
+import "time"
+
+// WebhookDelivery is one attempt at delivering a webhook event
+type WebhookDelivery struct {
+	ID         int64     `json:"id"`
+	JobID      int64     `json:"job_id"` // refresh job that raised the event
+	Event      string    `json:"event"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`     // 1 for the first try
+	StatusCode int       `json:"status_code"` // 0 if there was no response
+	Error      string    `json:"error"`       // empty when delivered
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RecordWebhookDelivery stores a delivery attempt
+func (db *DB) RecordWebhookDelivery(d WebhookDelivery) error {
+	_, err := db.Exec(`INSERT INTO webhook_deliveries (job_id, event, url, attempt, status_code, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, d.JobID, d.Event, d.URL, d.Attempt, d.StatusCode, d.Error, d.DurationMS)
+	return err
+}
+
+// GetProjectsFirstSeenSince returns the projects first seen at or after the given
+// time, most starred first, leaving out soft-deleted ones
+func (db *DB) GetProjectsFirstSeenSince(since time.Time) ([]Project, error) {
+	// first_seen_at is set by CURRENT_TIMESTAMP, so compare in its UTC text format
+	rows, err := db.Query(`SELECT id, repo_full_name, github_url, stars, description, primary_language, source_type, registry, first_seen_at
+		FROM projects WHERE first_seen_at >= ? AND is_deleted = 0 ORDER BY stars DESC, repo_full_name`,
+		since.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		var p Project
+		if err := rows.Scan(&p.ID, &p.RepoFullName, &p.GitHubURL, &p.Stars, &p.Description, &p.PrimaryLanguage, &p.SourceType, &p.Registry, &p.FirstSeenAt); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
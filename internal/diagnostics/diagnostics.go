@@ -0,0 +1,229 @@
+// Package diagnostics assembles a support bundle describing the state of a
+// running tracker: configuration, build info, recent refresh jobs, database
+// stats and recent logs. Secrets are never written to the bundle.
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"dhi-oss-usage/internal/db"
+)
+
+const (
+	redacted        = "[REDACTED]"
+	defaultJobLimit = 20
+	errorExcerptLen = 500
+	projectChunk    = 500 // projects per data file, keeps memory bounded
+)
+
+// Options controls what goes into a bundle
+type Options struct {
+	Config      map[string]string  // effective configuration; secret values are redacted
+	Logs        *LogRing           // recent log lines, may be nil
+	RateLimit   func() interface{} // current GitHub rate-limit status, may be nil
+	JobLimit    int                // number of recent refresh jobs to include (default 20)
+	IncludeData bool               // include raw project rows
+}
+
+// isSecretKey reports whether a config key holds a credential
+func isSecretKey(key string) bool {
+	k := strings.ToUpper(key)
+	for _, marker := range []string{"TOKEN", "SECRET", "PASSWORD", "KEY"} {
+		if strings.Contains(k, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubber replaces any secret config value that leaks into free text
+type scrubber []string
+
+func newScrubber(config map[string]string) scrubber {
+	var s scrubber
+	for k, v := range config {
+		if !isSecretKey(k) {
+			continue
+		}
+		// Lists such as API_KEYS hold several secrets
+		for _, secret := range strings.Split(v, ",") {
+			if secret = strings.TrimSpace(secret); len(secret) >= 4 {
+				s = append(s, secret)
+			}
+		}
+	}
+	return s
+}
+
+func (s scrubber) scrub(text string) string {
+	for _, secret := range s {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// WriteBundle streams a gzipped tar archive of diagnostics to w
+func WriteBundle(w io.Writer, database *db.DB, opts Options) error {
+	if opts.JobLimit <= 0 {
+		opts.JobLimit = defaultJobLimit
+	}
+	scrub := newScrubber(opts.Config)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now().UTC()
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		// Scrub the serialized form so nested strings are covered too
+		return add(name, []byte(scrub.scrub(string(data))+"\n"))
+	}
+
+	var notes []string
+
+	// Effective config with secrets redacted
+	config := make(map[string]string, len(opts.Config))
+	for k, v := range opts.Config {
+		if isSecretKey(k) && v != "" {
+			v = redacted
+		}
+		config[k] = v
+	}
+	if err := addJSON("config.json", config); err != nil {
+		return err
+	}
+
+	if err := addJSON("build.json", buildInfo(now)); err != nil {
+		return err
+	}
+
+	// Recent refresh jobs with error excerpts
+	jobs, _, err := database.ListRefreshJobs(opts.JobLimit, 0)
+	if err != nil {
+		notes = append(notes, fmt.Sprintf("refresh_jobs.json: %v", err))
+	} else {
+		for i := range jobs {
+			if len(jobs[i].ErrorMessage) > errorExcerptLen {
+				jobs[i].ErrorMessage = jobs[i].ErrorMessage[:errorExcerptLen] + "..."
+			}
+		}
+		if err := addJSON("refresh_jobs.json", jobs); err != nil {
+			return err
+		}
+	}
+
+	// Schema version and table row counts
+	version, tables, err := database.SchemaInfo()
+	if err != nil {
+		notes = append(notes, fmt.Sprintf("database.json: %v", err))
+	} else {
+		if err := addJSON("database.json", map[string]interface{}{
+			"user_version": version,
+			"tables":       tables,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if opts.RateLimit != nil {
+		if err := addJSON("rate_limit.json", opts.RateLimit()); err != nil {
+			return err
+		}
+	} else {
+		notes = append(notes, "rate_limit.json: rate-limit status not available")
+	}
+
+	if opts.Logs != nil {
+		var buf bytes.Buffer
+		for _, line := range opts.Logs.Lines() {
+			buf.WriteString(scrub.scrub(line))
+			buf.WriteByte('\n')
+		}
+		if err := add("logs.txt", buf.Bytes()); err != nil {
+			return err
+		}
+	} else {
+		notes = append(notes, "logs.txt: no in-memory log buffer (bundle was not generated by the server)")
+	}
+
+	if opts.IncludeData {
+		if err := addProjectData(database, addJSON); err != nil {
+			notes = append(notes, fmt.Sprintf("projects: %v", err))
+		}
+	}
+
+	notes = append(notes, "per-query stats and audit log are not tracked by this version")
+	if err := addJSON("manifest.json", map[string]interface{}{
+		"generated_at": now,
+		"include_data": opts.IncludeData,
+		"notes":        notes,
+	}); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addProjectData writes project rows in fixed-size chunks so the whole table
+// is never held in memory at once
+func addProjectData(database *db.DB, addJSON func(string, interface{}) error) error {
+	for offset, part := 0, 1; ; offset, part = offset+projectChunk, part+1 {
+		projects, err := database.ListProjects(db.ProjectFilter{SortBy: "name", SortOrder: "asc", Limit: projectChunk, Offset: offset})
+		if err != nil {
+			return err
+		}
+		if len(projects) == 0 {
+			return nil
+		}
+		if err := addJSON(fmt.Sprintf("projects/part-%04d.json", part), projects); err != nil {
+			return err
+		}
+		if len(projects) < projectChunk {
+			return nil
+		}
+	}
+}
+
+func buildInfo(now time.Time) map[string]interface{} {
+	info := map[string]interface{}{
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"time":       now,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["module"] = bi.Main.Path
+		info["version"] = bi.Main.Version
+		settings := make(map[string]string)
+		for _, s := range bi.Settings {
+			if strings.HasPrefix(s.Key, "vcs.") {
+				settings[s.Key] = s.Value
+			}
+		}
+		info["vcs"] = settings
+	}
+	return info
+}
@@ -0,0 +1,109 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dhi-oss-usage/internal/db"
+)
+
+func TestWriteBundleRedactsSecrets(t *testing.T) {
+	const (
+		token  = "ghp_diagnosticsTestToken123"
+		secret = "whsec_diagnosticsTestSecret"
+		apiKey = "dhi_diagnosticsTestKey"
+	)
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plant the secrets everywhere free text ends up in the bundle
+	job, err := database.CreateRefreshJob("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.FailRefreshJob(job, "GET https://api.github.com?access_token="+token+": 401"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpsertProject(&db.Project{
+		RepoFullName: "acme/app",
+		GitHubURL:    "https://github.com/acme/app",
+		Description:  "signs webhooks with " + secret,
+		SourceType:   "Dockerfile",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	logs := NewLogRing(10)
+	io.WriteString(logs, "using token "+token+"\nposting webhook signed with "+secret+"\n")
+
+	var buf bytes.Buffer
+	err = WriteBundle(&buf, database, Options{
+		Config: map[string]string{
+			"PORT":           "8080",
+			"GITHUB_TOKEN":   token,
+			"WEBHOOK_SECRET": secret,
+			"API_KEYS":       "other-key, " + apiKey,
+		},
+		Logs:        logs,
+		IncludeData: true,
+	})
+	if err != nil {
+		t.Fatalf("WriteBundle: %v", err)
+	}
+
+	files := untar(t, &buf)
+	for _, name := range []string{"config.json", "refresh_jobs.json", "logs.txt", "projects/part-0001.json", "manifest.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle has no %s", name)
+		}
+	}
+	for name, data := range files {
+		for _, s := range []string{token, secret, apiKey} {
+			if strings.Contains(data, s) {
+				t.Errorf("%s contains secret %q", name, s)
+			}
+		}
+	}
+	if !strings.Contains(files["config.json"], `"PORT": "8080"`) {
+		t.Errorf("config.json lost non-secret values:\n%s", files["config.json"])
+	}
+	if !strings.Contains(files["logs.txt"], "using token "+redacted) {
+		t.Errorf("logs.txt not redacted in place:\n%s", files["logs.txt"])
+	}
+}
+
+// untar returns the contents of a gzipped tar archive by file name
+func untar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
@@ -0,0 +1,47 @@
+package diagnostics
+
+import (
+	"strings"
+	"sync"
+)
+
+// LogRing keeps the most recent log lines in memory so they can be included
+// in a diagnostics bundle. Use it as an extra log output via io.MultiWriter.
+type LogRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewLogRing returns a ring buffer holding up to size lines
+func NewLogRing(size int) *LogRing {
+	return &LogRing{lines: make([]string, size)}
+}
+
+func (r *LogRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % len(r.lines)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered lines, oldest first
+func (r *LogRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	out := make([]string, 0, len(r.lines))
+	out = append(out, r.lines[r.next:]...)
+	return append(out, r.lines[:r.next]...)
+}
@@ -0,0 +1,158 @@
+// Package ecosystem groups GitHub primary languages into the ecosystems used
+// for reporting (JVM, Web, ...). The mapping is applied when data is read, so
+// changing it never requires a refresh.
+package ecosystem
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Other is the ecosystem for languages that aren't mapped, including projects
+// without a primary language
+const Other = "Other"
+
+// defaults is the built-in language→ecosystem mapping, keyed by GitHub language name
+var defaults = map[string]string{
+	"Java":    "JVM",
+	"Kotlin":  "JVM",
+	"Scala":   "JVM",
+	"Groovy":  "JVM",
+	"Clojure": "JVM",
+
+	"JavaScript": "Web",
+	"TypeScript": "Web",
+	"HTML":       "Web",
+	"CSS":        "Web",
+	"Vue":        "Web",
+	"Svelte":     "Web",
+
+	"Python":           "Python",
+	"Jupyter Notebook": "Python",
+
+	"Go":   "Go",
+	"Rust": "Rust",
+
+	"C":   "Native",
+	"C++": "Native",
+
+	"C#":                "DotNet",
+	"F#":                "DotNet",
+	"Visual Basic .NET": "DotNet",
+
+	"Ruby": "Ruby",
+	"PHP":  "PHP",
+
+	"Elixir": "BEAM",
+	"Erlang": "BEAM",
+
+	"Swift":       "Mobile",
+	"Objective-C": "Mobile",
+	"Dart":        "Mobile",
+
+	"Shell":      "Infrastructure",
+	"Dockerfile": "Infrastructure",
+	"HCL":        "Infrastructure",
+	"Makefile":   "Infrastructure",
+	"Nix":        "Infrastructure",
+}
+
+// Mapping maps primary languages to ecosystems. Lookups are case-insensitive.
+type Mapping struct {
+	languages map[string]string // canonical language name -> ecosystem
+	index     map[string]string // lowercased language name -> ecosystem
+}
+
+// Default returns the built-in mapping
+func Default() *Mapping {
+	m, _ := Parse("")
+	return m
+}
+
+// Parse returns the built-in mapping with overrides applied. The spec is a
+// comma-separated list of Language=Ecosystem pairs, e.g. "Kotlin=Android,Zig=Native".
+// An empty ecosystem removes a language from the mapping so it falls into Other.
+func Parse(spec string) (*Mapping, error) {
+	m := &Mapping{languages: make(map[string]string, len(defaults))}
+	for lang, eco := range defaults {
+		m.languages[lang] = eco
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		lang, eco, ok := strings.Cut(pair, "=")
+		lang, eco = strings.TrimSpace(lang), strings.TrimSpace(eco)
+		if !ok || lang == "" {
+			return nil, fmt.Errorf("invalid ecosystem mapping %q: want Language=Ecosystem", pair)
+		}
+		// Drop any existing entry that differs only in case
+		for existing := range m.languages {
+			if strings.EqualFold(existing, lang) {
+				delete(m.languages, existing)
+			}
+		}
+		if eco != "" {
+			m.languages[lang] = eco
+		}
+	}
+
+	m.index = make(map[string]string, len(m.languages))
+	for lang, eco := range m.languages {
+		m.index[strings.ToLower(lang)] = eco
+	}
+	return m, nil
+}
+
+// Of returns the ecosystem for a primary language, or Other if it isn't mapped
+func (m *Mapping) Of(language string) string {
+	if eco, ok := m.index[strings.ToLower(language)]; ok {
+		return eco
+	}
+	return Other
+}
+
+// Mapped reports whether a language has an explicit ecosystem
+func (m *Mapping) Mapped(language string) bool {
+	_, ok := m.index[strings.ToLower(language)]
+	return ok
+}
+
+// Languages returns a copy of the effective language→ecosystem mapping
+func (m *Mapping) Languages() map[string]string {
+	out := make(map[string]string, len(m.languages))
+	for lang, eco := range m.languages {
+		out[lang] = eco
+	}
+	return out
+}
+
+// Count is the number of projects in an ecosystem
+type Count struct {
+	Ecosystem string `json:"ecosystem"`
+	Count     int    `json:"count"`
+}
+
+// Group sums per-language project counts into ecosystems, most common first
+// with ties broken by name
+func (m *Mapping) Group(languageCounts map[string]int) []Count {
+	totals := make(map[string]int)
+	for lang, n := range languageCounts {
+		totals[m.Of(lang)] += n
+	}
+
+	counts := make([]Count, 0, len(totals))
+	for eco, n := range totals {
+		counts = append(counts, Count{Ecosystem: eco, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Ecosystem < counts[j].Ecosystem
+	})
+	return counts
+}
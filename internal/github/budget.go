@@ -0,0 +1,60 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrBudgetExceeded is returned instead of making a request once the client's
+// request budget is spent
+var ErrBudgetExceeded = errors.New("request budget exceeded")
+
+// requestBudget counts requests against an optional hard limit. It's updated
+// concurrently by detail workers.
+type requestBudget struct {
+	limit    int64 // 0 means unlimited
+	used     atomic.Int64
+	exceeded atomic.Bool
+}
+
+// WithRequestBudget makes the client refuse requests with ErrBudgetExceeded once n
+// requests have been sent, retries and rate-limited attempts included. Refused
+// requests don't count. Non-positive values keep requests unlimited.
+func WithRequestBudget(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.budget.limit = int64(n)
+		}
+	}
+}
+
+// take counts a request, or returns ErrBudgetExceeded if the budget is spent
+func (b *requestBudget) take() error {
+	if b.limit == 0 {
+		b.used.Add(1)
+		return nil
+	}
+	for {
+		used := b.used.Load()
+		if used >= b.limit {
+			b.exceeded.Store(true)
+			return fmt.Errorf("%w: %d requests", ErrBudgetExceeded, b.limit)
+		}
+		if b.used.CompareAndSwap(used, used+1) {
+			return nil
+		}
+	}
+}
+
+// RequestsUsed returns how many requests the client has sent
+func (c *Client) RequestsUsed() int {
+	return int(c.budget.used.Load())
+}
+
+// BudgetExceeded reports whether a request was refused because the request budget
+// was spent. Some callers log failed requests and carry on, so this is how a run
+// finds out afterwards.
+func (c *Client) BudgetExceeded() bool {
+	return c.budget.exceeded.Load()
+}
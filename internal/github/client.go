@@ -1,39 +1,173 @@
 package github
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"dhi-oss-usage/internal/metrics"
 )
 
 const (
-	baseURL         = "https://api.github.com"
-	searchRateDelay = 6 * time.Second // GitHub code search: ~10 req/min
+	defaultAPIURL               = "https://api.github.com"
+	defaultWebURL               = "https://github.com"
+	defaultSearchRequestsPerMin = 10   // GitHub code search: ~10 req/min
+	defaultCoreRequestsPerHour  = 5000 // GitHub core API limit for authenticated users
+	defaultDetailConcurrency    = 5
+	defaultRetryMaxAttempts     = 3
+	defaultRetryBaseDelay       = 1 * time.Second
+	retryMultiplier             = 2
 )
 
 type Client struct {
-	token      string
-	httpClient *http.Client
+	token             string
+	httpClient        *http.Client
+	apiURL            string   // REST API root, e.g. https://api.github.com or https://ghe.example.com/api/v3
+	graphQLURL        string   // GraphQL endpoint
+	webURL            string   // root for file links shown to users, e.g. https://github.com
+	searchLimiter     *limiter // paces /search/* requests
+	coreLimiter       *limiter // paces all other requests
+	rateMu            sync.Mutex
+	rateStates        map[string]RateLimitState // last reported budget per bucket
+	secondaryBackoff  time.Duration             // current backoff for secondary rate limits without Retry-After
+	detailConcurrency int
+	retryMaxAttempts  int           // attempts per request for 5xx responses and network errors
+	retryBaseDelay    time.Duration // delay before the first retry, doubled each attempt
+	etags             ETagStore     // optional; enables conditional repo detail requests
+	registries        []string      // registry hosts to search for; nil means DefaultRegistry
+	search            SearchOptions
+	budget            requestBudget
+	conditional       conditionalCounters
+}
+
+// Option configures optional Client behavior
+type Option func(*Client)
+
+// WithConcurrency sets how many repo detail requests may be in flight at once
+func WithConcurrency(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.detailConcurrency = n
+		}
+	}
+}
+
+// WithRateLimits sets the request budgets used to pace code search and core API calls.
+// Non-positive values keep the defaults.
+func WithRateLimits(searchPerMinute, corePerHour int) Option {
+	return func(c *Client) {
+		if searchPerMinute > 0 {
+			c.searchLimiter = newLimiter(searchPerMinute, time.Minute)
+		}
+		if corePerHour > 0 {
+			c.coreLimiter = newLimiter(corePerHour, time.Hour)
+		}
+	}
+}
+
+// WithRetry sets how many times a request is attempted when GitHub returns a 5xx
+// status or the connection fails, and the delay before the first retry
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		if maxAttempts > 0 {
+			c.retryMaxAttempts = maxAttempts
+		}
+		if baseDelay > 0 {
+			c.retryBaseDelay = baseDelay
+		}
+	}
+}
+
+// WithRegistries sets the registry hosts searched for, e.g. a mirror of dhi.io.
+// Empty hosts are ignored; with none left the default registry is kept.
+func WithRegistries(hosts ...string) Option {
+	return func(c *Client) {
+		var regs []string
+		for _, h := range hosts {
+			if h = strings.TrimSpace(h); h != "" {
+				regs = append(regs, h)
+			}
+		}
+		if len(regs) > 0 {
+			c.registries = regs
+		}
+	}
 }
 
-func NewClient(token string) *Client {
-	return &Client{
+// maxSearchPages is how many 100-result pages GitHub returns per code search query
+const maxSearchPages = 10
+
+// SearchOptions narrows what FetchProjects covers, e.g. for a quick smoke run.
+// Zero values mean no limit.
+type SearchOptions struct {
+	Queries  []string // names of the configured queries to run; nil runs them all
+	MaxPages int      // result pages per query, at most GitHub's 10
+	MaxRepos int      // repos found to fetch details for; the rest are dropped
+}
+
+// WithSearchOptions limits the queries, pages and repos the client's searches cover
+func WithSearchOptions(o SearchOptions) Option {
+	return func(c *Client) {
+		c.search = o
+	}
+}
+
+// WithBaseURL points the client at a GitHub Enterprise Server instance. Either the
+// instance root (https://ghe.example.com) or its REST root (https://ghe.example.com/api/v3)
+// may be given; the GraphQL endpoint and file links are derived from it. Empty or
+// api.github.com URLs keep the github.com defaults.
+func WithBaseURL(base string) Option {
+	return func(c *Client) {
+		root := strings.TrimRight(strings.TrimSpace(base), "/")
+		if root == "" || root == defaultAPIURL {
+			return
+		}
+		root = strings.TrimSuffix(root, "/api/v3")
+		c.apiURL = root + "/api/v3"
+		c.graphQLURL = root + "/api/graphql"
+		c.webURL = root
+	}
+}
+
+func NewClient(token string, opts ...Option) *Client {
+	c := &Client{
 		token: token,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		apiURL:            defaultAPIURL,
+		graphQLURL:        defaultAPIURL + "/graphql",
+		webURL:            defaultWebURL,
+		searchLimiter:     newLimiter(defaultSearchRequestsPerMin, time.Minute),
+		coreLimiter:       newLimiter(defaultCoreRequestsPerHour, time.Hour),
+		rateStates:        make(map[string]RateLimitState),
+		detailConcurrency: defaultDetailConcurrency,
+		retryMaxAttempts:  defaultRetryMaxAttempts,
+		retryBaseDelay:    defaultRetryBaseDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // CodeSearchResult represents a single code search hit
 type CodeSearchResult struct {
 	Path       string `json:"path"`
+	HTMLURL    string `json:"html_url"` // blob URL at the commit the search indexed
 	Repository struct {
 		FullName string `json:"full_name"`
 		HTMLURL  string `json:"html_url"`
@@ -49,11 +183,15 @@ type CodeSearchResponse struct {
 
 // RepoDetails represents repository metadata
 type RepoDetails struct {
-	FullName        string `json:"full_name"`
-	HTMLURL         string `json:"html_url"`
-	Description     string `json:"description"`
-	StargazersCount int    `json:"stargazers_count"`
-	Language        string `json:"language"`
+	FullName        string     `json:"full_name"`
+	HTMLURL         string     `json:"html_url"`
+	Description     string     `json:"description"`
+	StargazersCount int        `json:"stargazers_count"`
+	Language        string     `json:"language"`
+	PushedAt        *time.Time `json:"pushed_at"`
+	Archived        bool       `json:"archived"`
+	Fork            bool       `json:"fork"`
+	Topics          []string   `json:"topics"` // returned by default for application/vnd.github+json
 }
 
 // Project combines search result with repo details
@@ -66,10 +204,66 @@ type Project struct {
 	DockerfilePath  string
 	FileURL         string
 	SourceType      string
+	Registry        string     // registry host the repo was found referencing
+	MatchedQueries  []string   // names of every search query that matched the repo
+	PushedAt        *time.Time // last push to the repo; nil if unknown
+	Archived        bool
+	Fork            bool
+	Topics          []string
 }
 
-func (c *Client) doRequest(ctx context.Context, method, endpoint string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, method, baseURL+endpoint, nil)
+// doRequest performs an API request, retrying 5xx responses and network errors
+// with exponential backoff. Rate limits are left to the callers, which know
+// whether waiting is worth it; other failures such as 401, 404 or 422 return at once.
+func (c *Client) doRequest(ctx context.Context, method, endpoint string, reqBody []byte) ([]byte, error) {
+	delay := c.retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		body, err := c.doRequestOnce(ctx, method, endpoint, reqBody)
+		if err == nil || !retryable(ctx, err) || attempt >= c.retryMaxAttempts {
+			return body, err
+		}
+
+		wait := jitter(delay)
+		log.Printf("GitHub %s %s failed: %v (attempt %d/%d), retrying in %s",
+			method, endpoint, err, attempt, c.retryMaxAttempts, wait.Round(time.Millisecond))
+
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		delay *= retryMultiplier
+	}
+}
+
+// jitter randomizes d by ±20% so concurrent retries don't line up
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// doRequestOnce waits for the appropriate rate limiter and performs a single API request.
+// If GitHub last reported the budget as exhausted it first sleeps until the reset.
+// Failed responses are returned as *RateLimitError, *NotFoundError, *ValidationError
+// or *APIError.
+func (c *Client) doRequestOnce(ctx context.Context, method, endpoint string, reqBody []byte) ([]byte, error) {
+	bucket := rateBucket(endpoint)
+	lim := c.coreLimiter
+	if bucket == "search" {
+		lim = c.searchLimiter
+	}
+	if err := c.waitForReset(ctx, bucket); err != nil {
+		return nil, err
+	}
+	if err := lim.Wait(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.budget.take(); err != nil {
+		return nil, err
+	}
+
+	var bodyReader io.Reader
+	if reqBody != nil {
+		bodyReader = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.requestURL(endpoint), bodyReader)
 	if err != nil {
 		return nil, err
 	}
@@ -77,136 +271,267 @@ func (c *Client) doRequest(ctx context.Context, method, endpoint string) ([]byte
 	req.Header.Set("Authorization", "Bearer "+c.token)
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	conditional := c.isConditional(method, endpoint)
+	var cachedBody []byte
+	if conditional {
+		if etag, body := c.cachedETag(endpoint); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+			cachedBody = body
+		}
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		metrics.GitHubRequests.WithLabelValues("error").Inc()
+		return nil, &networkError{err}
 	}
 	defer resp.Body.Close()
+	metrics.GitHubRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	c.recordRateLimit(bucket, resp.Header)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, &networkError{err}
+	}
+
+	if isRateLimited(resp, body) {
+		return nil, c.rateLimitError(resp, body)
 	}
 
-	if resp.StatusCode == 403 {
-		// Rate limited - check headers
-		return nil, fmt.Errorf("rate limited: %s", string(body))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &NotFoundError{Resource: endpoint}
+	}
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return nil, &ValidationError{Resource: endpoint, Message: string(body)}
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cachedBody != nil {
+		// GitHub doesn't charge 304s against the quota, so give the token back
+		lim.refund()
+		c.conditional.hits.Add(1)
+		c.resetSecondaryBackoff()
+		return cachedBody, nil
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, &APIError{Status: resp.StatusCode, Body: string(body)}
+	}
+	c.resetSecondaryBackoff()
+	if conditional {
+		c.conditional.misses.Add(1)
+		c.storeETag(endpoint, resp.Header.Get("ETag"), body)
 	}
 
 	return body, nil
 }
 
+// requestURL returns the absolute URL for an API endpoint. GitHub Enterprise serves
+// GraphQL outside the REST prefix, so "/graphql" is mapped separately.
+func (c *Client) requestURL(endpoint string) string {
+	if endpoint == "/graphql" {
+		return c.graphQLURL
+	}
+	return c.apiURL + endpoint
+}
+
+// DefaultRegistry is the registry host tracked when none is configured
+const DefaultRegistry = "dhi.io"
+
 // SearchQuery represents a single search query configuration
 type SearchQuery struct {
-	Name  string
-	Query string
+	Name     string
+	Query    string
+	Registry string // registry host the query looks for
 }
 
 // GetSearchQueries returns all the search queries we use to find DHI usage
-// These are tuned to find actual DHI registry usage, not false positives like "siddhi.io"
 func GetSearchQueries() []SearchQuery {
-	return []SearchQuery{
-		// FROM dhi.io in actual Dockerfiles (not docs/READMEs)
-		// filename:Dockerfile is a substring match, so catches Dockerfile.dev, app.Dockerfile, etc.
-		{"Dockerfiles", `"FROM dhi.io" filename:Dockerfile`},
-		// image: dhi.io/ - K8s/docker-compose image references with trailing slash
-		// The "image: " prefix distinguishes from URLs like siddhi.io
-		{"YAML/K8s", `"image: dhi.io/" language:YAML`},
-		// dhi.io/ in CI workflows - image references in GitHub Actions
-		{"GitHub Actions", `"dhi.io/" path:.github/workflows`},
+	return SearchQueriesFor(DefaultRegistry)
+}
+
+// SearchQueriesFor returns the search queries for each registry host. With more
+// than one registry, query names are suffixed with the host so they stay unique.
+// The templates are tuned to find actual registry usage, not false positives
+// like "siddhi.io" when tracking dhi.io.
+func SearchQueriesFor(registries ...string) []SearchQuery {
+	var queries []SearchQuery
+	for _, reg := range registries {
+		suffix := ""
+		if len(registries) > 1 {
+			suffix = " (" + reg + ")"
+		}
+		queries = append(queries,
+			// FROM <registry> in actual Dockerfiles (not docs/READMEs)
+			// filename:Dockerfile is a substring match, so catches Dockerfile.dev, app.Dockerfile, etc.
+			SearchQuery{"Dockerfiles" + suffix, fmt.Sprintf(`"FROM %s" filename:Dockerfile`, reg), reg},
+			// image: <registry>/ - K8s/docker-compose image references with trailing slash
+			// The "image: " prefix distinguishes from URLs like siddhi.io
+			SearchQuery{"YAML/K8s" + suffix, fmt.Sprintf(`"image: %s/" language:YAML`, reg), reg},
+			// <registry>/ in CI workflows - image references in GitHub Actions
+			SearchQuery{"GitHub Actions" + suffix, fmt.Sprintf(`"%s/" path:.github/workflows`, reg), reg},
+		)
+	}
+	return queries
+}
+
+// SearchQueries returns the queries used by SearchDHIUsage and FetchAllProjects
+func (c *Client) SearchQueries() []SearchQuery {
+	queries := GetSearchQueries()
+	if c.registries != nil {
+		queries = SearchQueriesFor(c.registries...)
+	}
+	if c.search.Queries == nil {
+		return queries
 	}
+	return slices.DeleteFunc(queries, func(q SearchQuery) bool {
+		return !slices.Contains(c.search.Queries, q.Name)
+	})
 }
 
-// SearchResult holds a repo and the file path where dhi.io was found
+// SearchResult holds a repo and the file path where the registry was found
 type SearchResult struct {
 	RepoFullName string
+	Registry     string // registry host of the first query that matched
 	FilePath     string
 	FileURL      string
-	SourceType   string // e.g., "Dockerfile", "YAML", "GitHub Actions"
+	SourceType   string   // e.g., "Dockerfile", "YAML", "GitHub Actions"
+	Queries      []string // names of every search query that matched this repo
 }
 
 // SearchDHIUsage searches for dhi.io references across multiple file types
-// Returns unique repos found with their file paths
-func (c *Client) SearchDHIUsage(ctx context.Context, progressFn func(queryName string, found int, page int)) (map[string]SearchResult, error) {
+// Returns unique repos found with their file paths, and how each query fared
+func (c *Client) SearchDHIUsage(ctx context.Context, progressFn func(queryName string, found int, page int)) (map[string]SearchResult, map[string]QueryStat, error) {
+	return c.SearchUsage(ctx, c.SearchQueries(), progressFn)
+}
+
+// QueryStat describes one search query's part in a search, to tell which queries
+// are worth their share of the search rate limit
+type QueryStat struct {
+	Repos          int           // distinct repos the query matched
+	UniqueRepos    int           // repos no earlier query of the search had found
+	Pages          int           // result pages fetched
+	Duration       time.Duration // including waits for the rate limit
+	HitGitHubLimit bool          // more results existed past GitHub's 1000-result cap
+}
+
+// SearchUsage runs the given code search queries and returns the unique repos found
+// with their file paths, and stats by query name. On error the stats cover the
+// queries run so far.
+func (c *Client) SearchUsage(ctx context.Context, queries []SearchQuery, progressFn func(queryName string, found int, page int)) (map[string]SearchResult, map[string]QueryStat, error) {
 	repos := make(map[string]SearchResult) // repo full name -> search result
-	queries := GetSearchQueries()
+	stats := make(map[string]QueryStat, len(queries))
 
 	for _, sq := range queries {
-		log.Printf("Starting search: %s", sq.Name)
-		page := 1
-		perPage := 100
-
-		for {
-			select {
-			case <-ctx.Done():
-				return repos, ctx.Err()
-			default:
-			}
+		stat, err := c.searchQuery(ctx, sq, repos, progressFn)
+		stats[sq.Name] = stat
+		if err != nil {
+			return repos, stats, err
+		}
+	}
 
-			query := url.QueryEscape(sq.Query)
-			endpoint := fmt.Sprintf("/search/code?q=%s&per_page=%d&page=%d", query, perPage, page)
+	return repos, stats, nil
+}
 
-			log.Printf("[%s] Searching page %d...", sq.Name, page)
-			body, err := c.doRequest(ctx, "GET", endpoint)
-			if err != nil {
-				// If rate limited, wait and retry
-				if strings.Contains(err.Error(), "rate limited") {
-					log.Printf("Rate limited, waiting 60s...")
-					time.Sleep(60 * time.Second)
-					continue
-				}
-				return repos, err
-			}
+// searchQuery runs one code search query, adding the repos it finds to repos
+func (c *Client) searchQuery(ctx context.Context, sq SearchQuery, repos map[string]SearchResult, progressFn func(queryName string, found int, page int)) (stat QueryStat, err error) {
+	log.Printf("Starting search: %s", sq.Name)
+	started := time.Now()
+	defer func() { stat.Duration = time.Since(started) }()
+	page := 1
+	perPage := 100
 
-			var searchResp CodeSearchResponse
-			if err := json.Unmarshal(body, &searchResp); err != nil {
-				return repos, err
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			return stat, ctx.Err()
+		default:
+		}
 
-			for _, item := range searchResp.Items {
-				if _, exists := repos[item.Repository.FullName]; !exists {
-					fileURL := fmt.Sprintf("https://github.com/%s/blob/HEAD/%s", item.Repository.FullName, item.Path)
-					repos[item.Repository.FullName] = SearchResult{
-						RepoFullName: item.Repository.FullName,
-						FilePath:     item.Path,
-						FileURL:      fileURL,
-						SourceType:   sq.Name,
-					}
-				}
+		query := url.QueryEscape(sq.Query)
+		endpoint := fmt.Sprintf("/search/code?q=%s&per_page=%d&page=%d", query, perPage, page)
+
+		log.Printf("[%s] Searching page %d...", sq.Name, page)
+		body, err := c.doRequest(ctx, "GET", endpoint, nil)
+		if err != nil {
+			// If rate limited, wait until the limit resets and retry
+			if WaitForRateLimit(ctx, err) {
+				continue
+			}
+			// Cancelled while waiting: report that, not the rate limit
+			if ctx.Err() != nil {
+				return stat, ctx.Err()
 			}
+			// A query GitHub rejects, e.g. a malformed custom one, is skipped
+			// rather than failing the queries after it
+			var ve *ValidationError
+			if errors.As(err, &ve) {
+				log.Printf("[%s] Skipping query %q: %v", sq.Name, sq.Query, err)
+				return stat, nil
+			}
+			return stat, err
+		}
 
-			if progressFn != nil {
-				progressFn(sq.Name, len(repos), page)
+		var searchResp CodeSearchResponse
+		if err := json.Unmarshal(body, &searchResp); err != nil {
+			return stat, err
+		}
+		stat.Pages++
+
+		for _, item := range searchResp.Items {
+			existing, exists := repos[item.Repository.FullName]
+			if !exists {
+				// The hit's own URL is pinned to a commit, so it keeps pointing
+				// at the matched lines after the branch moves
+				fileURL := item.HTMLURL
+				if fileURL == "" {
+					fileURL = fmt.Sprintf("%s/%s/blob/HEAD/%s", c.webURL, item.Repository.FullName, item.Path)
+				}
+				repos[item.Repository.FullName] = SearchResult{
+					RepoFullName: item.Repository.FullName,
+					Registry:     sq.Registry,
+					FilePath:     item.Path,
+					FileURL:      fileURL,
+					SourceType:   sq.Name,
+					Queries:      []string{sq.Name},
+				}
+				stat.Repos++
+				stat.UniqueRepos++
+			} else if !slices.Contains(existing.Queries, sq.Name) {
+				existing.Queries = append(existing.Queries, sq.Name)
+				repos[item.Repository.FullName] = existing
+				stat.Repos++
 			}
+		}
 
-			log.Printf("[%s] Page %d: found %d items, total unique repos: %d", sq.Name, page, len(searchResp.Items), len(repos))
+		if progressFn != nil {
+			progressFn(sq.Name, len(repos), page)
+		}
 
-			// Check if we've got all results
-			if len(searchResp.Items) < perPage || page*perPage >= searchResp.TotalCount {
-				break
-			}
+		log.Printf("[%s] Page %d: found %d items, total unique repos: %d", sq.Name, page, len(searchResp.Items), len(repos))
 
-			// GitHub only returns first 1000 results per query
-			if page >= 10 {
-				log.Printf("[%s] Reached GitHub's 1000 result limit", sq.Name)
-				break
-			}
+		// Check if we've got all results
+		if len(searchResp.Items) < perPage || page*perPage >= searchResp.TotalCount {
+			return stat, nil
+		}
 
-			page++
-			// Rate limit delay for code search
-			time.Sleep(searchRateDelay)
+		// GitHub only returns first 1000 results per query
+		if page >= maxSearchPages {
+			log.Printf("[%s] Reached GitHub's 1000 result limit", sq.Name)
+			stat.HitGitHubLimit = true
+			return stat, nil
+		}
+		if c.search.MaxPages > 0 && page >= c.search.MaxPages {
+			log.Printf("[%s] Reached the configured limit of %d pages", sq.Name, c.search.MaxPages)
+			return stat, nil
 		}
 
-		// Delay between different search queries
-		time.Sleep(searchRateDelay)
+		page++
 	}
-
-	return repos, nil
 }
 
 // CommitInfo represents a commit from GitHub API
@@ -237,7 +562,7 @@ func (c *Client) GetFileFirstCommit(ctx context.Context, repoFullName, filePath
 	// First, try to get a small page to see total
 	endpoint := fmt.Sprintf("/repos/%s/commits?path=%s&per_page=1", repoFullName, path)
 	
-	body, err := c.doRequest(ctx, "GET", endpoint)
+	body, err := c.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -263,7 +588,7 @@ func (c *Client) GetFileFirstCommit(ctx context.Context, repoFullName, filePath
 	// Otherwise, need to paginate to get the oldest commit
 	// Get up to 100 commits and take the oldest
 	endpoint = fmt.Sprintf("/repos/%s/commits?path=%s&per_page=100", repoFullName, path)
-	body, err = c.doRequest(ctx, "GET", endpoint)
+	body, err = c.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -285,10 +610,60 @@ func (c *Client) GetFileFirstCommit(ctx context.Context, repoFullName, filePath
 	}, nil
 }
 
+// MaxFileContentSize is the largest file GetFileContent returns content for. The
+// contents API doesn't inline bigger files, and a matched file that large isn't
+// worth diffing.
+const MaxFileContentSize = 1 << 20
+
+// FileContent is a file at the default branch head
+type FileContent struct {
+	SHA     string // git blob SHA, which changes exactly when the content does
+	Size    int
+	Content []byte // nil when Size exceeds MaxFileContentSize
+}
+
+// GetFileContent fetches a file from the repository's default branch
+func (c *Client) GetFileContent(ctx context.Context, repoFullName, filePath string) (*FileContent, error) {
+	segments := strings.Split(filePath, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	endpoint := fmt.Sprintf("/repos/%s/contents/%s", repoFullName, strings.Join(segments, "/"))
+	body, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Type     string `json:"type"`
+		SHA      string `json:"sha"`
+		Size     int    `json:"size"`
+		Encoding string `json:"encoding"`
+		Content  string `json:"content"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Type != "file" {
+		return nil, fmt.Errorf("%s in %s is a %s, not a file", filePath, repoFullName, resp.Type)
+	}
+
+	fc := &FileContent{SHA: resp.SHA, Size: resp.Size}
+	if resp.Size > MaxFileContentSize || resp.Encoding != "base64" {
+		return fc, nil
+	}
+	// The API wraps base64 content at 60 characters
+	fc.Content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(resp.Content, "\n", ""))
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s in %s: %w", filePath, repoFullName, err)
+	}
+	return fc, nil
+}
+
 // GetRepoDetails fetches details for a single repository
 func (c *Client) GetRepoDetails(ctx context.Context, repoFullName string) (*RepoDetails, error) {
 	endpoint := "/repos/" + repoFullName
-	body, err := c.doRequest(ctx, "GET", endpoint)
+	body, err := c.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -301,56 +676,55 @@ func (c *Client) GetRepoDetails(ctx context.Context, repoFullName string) (*Repo
 	return &repo, nil
 }
 
+// QueryDoneStage prefixes the progress status FetchProjects reports as each search
+// query finishes, followed by the query name. current is the number of unique repos
+// found so far and total the number of queries.
+const QueryDoneStage = "query_done:"
+
 // FetchAllProjects searches for DHI usage and fetches details for each repo
 func (c *Client) FetchAllProjects(ctx context.Context, progressFn func(status string, current, total int)) ([]Project, error) {
+	projects, _, err := c.FetchProjects(ctx, c.SearchQueries(), progressFn)
+	return projects, err
+}
+
+// FetchProjects runs the given search queries and fetches details for each repo
+// found. It also returns the search stats by query name, even when it fails.
+func (c *Client) FetchProjects(ctx context.Context, queries []SearchQuery, progressFn func(status string, current, total int)) ([]Project, map[string]QueryStat, error) {
 	// Step 1: Search for all repos across multiple file types
 	if progressFn != nil {
 		progressFn("searching", 0, 0)
 	}
 
-	repos, err := c.SearchDHIUsage(ctx, nil)
+	// SearchUsage reports each page; a query is done once the next one starts
+	var current string
+	queryDone := func(found int) {
+		if current != "" && progressFn != nil {
+			progressFn(QueryDoneStage+current, found, len(queries))
+		}
+	}
+	lastFound := 0
+	repos, stats, err := c.SearchUsage(ctx, queries, func(queryName string, found, page int) {
+		if queryName != current {
+			queryDone(lastFound)
+			current = queryName
+		}
+		lastFound = found
+	})
 	if err != nil {
-		return nil, fmt.Errorf("searching for dhi.io usage: %w", err)
+		return nil, stats, fmt.Errorf("searching for registry usage: %w", err)
 	}
+	queryDone(len(repos))
 
 	log.Printf("Found %d unique repositories", len(repos))
 
-	// Step 2: Fetch details for each repo
-	projects := make([]Project, 0, len(repos))
-	i := 0
-	for repoName, searchResult := range repos {
-		select {
-		case <-ctx.Done():
-			return projects, ctx.Err()
-		default:
-		}
-
-		i++
-		if progressFn != nil {
-			progressFn("fetching_details", i, len(repos))
-		}
-
-		log.Printf("Fetching details for %s (%d/%d)", repoName, i, len(repos))
-
-		details, err := c.GetRepoDetails(ctx, repoName)
-		if err != nil {
-			// Log error but continue with other repos
-			log.Printf("Error fetching %s: %v", repoName, err)
-			// If rate limited, wait
-			if strings.Contains(err.Error(), "rate limited") {
-				log.Printf("Rate limited, waiting 60s...")
-				time.Sleep(60 * time.Second)
-				// Retry
-				details, err = c.GetRepoDetails(ctx, repoName)
-				if err != nil {
-					log.Printf("Retry failed for %s: %v", repoName, err)
-					continue
-				}
-			} else {
-				continue
-			}
-		}
-
+	// Step 2: Fetch details in GraphQL batches; anything GraphQL couldn't resolve
+	// falls back to per-repo REST calls.
+	var (
+		mu       sync.Mutex
+		projects = make([]Project, 0, len(repos))
+		done     int
+	)
+	addProject := func(details *RepoDetails, searchResult SearchResult) {
 		projects = append(projects, Project{
 			RepoFullName:    details.FullName,
 			GitHubURL:       details.HTMLURL,
@@ -360,12 +734,110 @@ func (c *Client) FetchAllProjects(ctx context.Context, progressFn func(status st
 			DockerfilePath:  searchResult.FilePath,
 			FileURL:         searchResult.FileURL,
 			SourceType:      searchResult.SourceType,
+			Registry:        searchResult.Registry,
+			MatchedQueries:  searchResult.Queries,
+			PushedAt:        details.PushedAt,
+			Archived:        details.Archived,
+			Fork:            details.Fork,
+			Topics:          details.Topics,
 		})
+	}
+
+	names := make([]string, 0, len(repos))
+	for name := range repos {
+		names = append(names, name)
+	}
+	if limit := c.search.MaxRepos; limit > 0 && len(names) > limit {
+		// Sort so a capped run covers the same repos each time
+		slices.Sort(names)
+		log.Printf("Fetching details for %d of %d repos (limit)", limit, len(names))
+		names = names[:limit]
+	}
+
+	var fallback []string
+	for start := 0; start < len(names); start += graphQLBatchSize {
+		if err := ctx.Err(); err != nil {
+			return projects, stats, err
+		}
+
+		chunk := names[start:min(start+graphQLBatchSize, len(names))]
+		batch, err := c.FetchReposBatch(ctx, chunk)
+		if err != nil {
+			log.Printf("GraphQL batch failed, falling back to REST for %d repos: %v", len(chunk), err)
+			fallback = append(fallback, chunk...)
+			continue
+		}
+
+		found := make(map[string]*RepoDetails, len(batch))
+		for i := range batch {
+			found[strings.ToLower(batch[i].FullName)] = &batch[i]
+		}
+		for _, name := range chunk {
+			details, ok := found[strings.ToLower(name)]
+			if !ok {
+				fallback = append(fallback, name)
+				continue
+			}
+			done++
+			addProject(details, repos[name])
+		}
+		if progressFn != nil {
+			progressFn("fetching_details", done, len(names))
+		}
+		log.Printf("Fetched details for %d/%d repos via GraphQL", done, len(names))
+	}
 
-		// Small delay to avoid hitting rate limits on repo API
-		// Repo API limit is 5000/hour = ~1.4/sec, so 1s delay is safe
-		time.Sleep(1 * time.Second)
+	if len(fallback) > 0 {
+		log.Printf("Fetching %d repos via REST", len(fallback))
 	}
 
-	return projects, nil
+	// REST fallback uses a bounded pool of workers; doRequest paces them
+	// against the shared core limiter.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.detailConcurrency)
+	for _, repoName := range fallback {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(repoName string, searchResult SearchResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			details, err := c.fetchRepoDetailsWithRetry(ctx, repoName)
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if progressFn != nil {
+				progressFn("fetching_details", done, len(names))
+			}
+			if err != nil {
+				log.Printf("Error fetching %s (%d/%d): %s", repoName, done, len(names), Describe(err))
+				return
+			}
+			log.Printf("Fetched details for %s (%d/%d)", repoName, done, len(names))
+			addProject(details, searchResult)
+		}(repoName, repos[repoName])
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return projects, stats, err
+	}
+	return projects, stats, nil
+}
+
+// fetchRepoDetailsWithRetry fetches repo details, retrying once after a pause if rate limited
+func (c *Client) fetchRepoDetailsWithRetry(ctx context.Context, repoName string) (*RepoDetails, error) {
+	details, err := c.GetRepoDetails(ctx, repoName)
+	if err == nil || !WaitForRateLimit(ctx, err) {
+		return details, err
+	}
+	return c.GetRepoDetails(ctx, repoName)
 }
@@ -0,0 +1,149 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for a test server answering every request with handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewClient("test-token", WithBaseURL(srv.URL))
+}
+
+func TestRateLimitResponses(t *testing.T) {
+	reset := func(d time.Duration) string { return strconv.FormatInt(time.Now().Add(d).Unix(), 10) }
+	tests := []struct {
+		name      string
+		status    int
+		headers   map[string]string
+		body      string
+		secondary bool
+		want      time.Duration // expected wait from now
+	}{
+		{"429 retry-after", http.StatusTooManyRequests, map[string]string{"Retry-After": "30"}, "", false, 30 * time.Second},
+		{"429 without hints", http.StatusTooManyRequests, nil, "", false, defaultRateLimitWait},
+		{"403 retry-after", http.StatusForbidden, map[string]string{"Retry-After": "45"}, "", false, 45 * time.Second},
+		{"403 quota reset", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset(3 * time.Minute)}, "", false, 3 * time.Minute},
+		{"403 reset capped", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset(2 * time.Hour)}, "", false, maxRateLimitWait},
+		{"403 retry-after capped", http.StatusForbidden, map[string]string{"Retry-After": "86400"}, "", false, maxRateLimitWait},
+		{"403 secondary", http.StatusForbidden, nil, `{"message":"You have exceeded a secondary rate limit"}`, true, defaultRateLimitWait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			_, err := c.GetRepoDetails(context.Background(), "acme/app")
+			var rle *RateLimitError
+			if !errors.As(err, &rle) {
+				t.Fatalf("err = %v, want *RateLimitError", err)
+			}
+			if rle.Secondary != tt.secondary {
+				t.Errorf("Secondary = %v, want %v", rle.Secondary, tt.secondary)
+			}
+			// Allow for the request itself and for Unix timestamps dropping sub-second time
+			if got := time.Until(rle.RetryAfter); got < tt.want-2*time.Second || got > tt.want+time.Second {
+				t.Errorf("retry in %s, want about %s", got.Round(time.Second), tt.want)
+			}
+		})
+	}
+}
+
+func TestForbiddenWithoutRateLimitIsAPIError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		http.Error(w, `{"message":"Resource not accessible by integration"}`, http.StatusForbidden)
+	})
+	_, err := c.GetRepoDetails(context.Background(), "acme/app")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("err = %v, want a 403 *APIError", err)
+	}
+}
+
+func TestSecondaryRateLimitBacksOff(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"You have exceeded a secondary rate limit"}`, http.StatusForbidden)
+	})
+	for _, want := range []time.Duration{defaultRateLimitWait, 2 * defaultRateLimitWait, 4 * defaultRateLimitWait} {
+		_, err := c.GetRepoDetails(context.Background(), "acme/app")
+		var rle *RateLimitError
+		if !errors.As(err, &rle) {
+			t.Fatalf("err = %v, want *RateLimitError", err)
+		}
+		if got := time.Until(rle.RetryAfter); got < want-time.Second || got > want {
+			t.Errorf("retry in %s, want %s", got.Round(time.Second), want)
+		}
+	}
+}
+
+func TestWithBaseURL(t *testing.T) {
+	tests := []struct {
+		base                 string
+		api, graphQL, webURL string
+	}{
+		{"", defaultAPIURL, defaultAPIURL + "/graphql", defaultWebURL},
+		{"https://api.github.com/", defaultAPIURL, defaultAPIURL + "/graphql", defaultWebURL},
+		{"https://ghe.example.com", "https://ghe.example.com/api/v3", "https://ghe.example.com/api/graphql", "https://ghe.example.com"},
+		{"https://ghe.example.com/", "https://ghe.example.com/api/v3", "https://ghe.example.com/api/graphql", "https://ghe.example.com"},
+		{"https://ghe.example.com/api/v3", "https://ghe.example.com/api/v3", "https://ghe.example.com/api/graphql", "https://ghe.example.com"},
+		{" https://ghe.example.com/api/v3/ ", "https://ghe.example.com/api/v3", "https://ghe.example.com/api/graphql", "https://ghe.example.com"},
+	}
+	for _, tt := range tests {
+		c := NewClient("", WithBaseURL(tt.base))
+		if c.apiURL != tt.api || c.graphQLURL != tt.graphQL || c.webURL != tt.webURL {
+			t.Errorf("WithBaseURL(%q): api %s, graphql %s, web %s; want %s, %s, %s",
+				tt.base, c.apiURL, c.graphQLURL, c.webURL, tt.api, tt.graphQL, tt.webURL)
+		}
+	}
+}
+
+func TestEnterpriseServer(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("%s: Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/api/v3/search/code":
+			w.Write([]byte(`{"total_count":1,"items":[{"path":"build/Dockerfile","repository":{"full_name":"corp/app"}}]}`))
+		case "/api/v3/repos/corp/app":
+			w.Write([]byte(`{"full_name":"corp/app","stargazers_count":3}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient("test-token", WithBaseURL(srv.URL+"/api/v3"))
+
+	repos, _, err := c.SearchUsage(context.Background(), []SearchQuery{{Name: "Dockerfile", Query: `"dhi.io/" filename:Dockerfile`}}, nil)
+	if err != nil {
+		t.Fatalf("SearchUsage: %v", err)
+	}
+	// Hits without an html_url link to the instance, not github.com
+	if got, want := repos["corp/app"].FileURL, srv.URL+"/corp/app/blob/HEAD/build/Dockerfile"; got != want {
+		t.Errorf("FileURL = %q, want %q", got, want)
+	}
+
+	details, err := c.GetRepoDetails(context.Background(), "corp/app")
+	if err != nil || details.StargazersCount != 3 {
+		t.Fatalf("GetRepoDetails = %+v, %v", details, err)
+	}
+	if want := []string{"/api/v3/search/code", "/api/v3/repos/corp/app"}; !slices.Equal(paths, want) {
+		t.Errorf("requested %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,198 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRateLimitWait = 60 * time.Second // used when GitHub gives no reset hint
+	maxRateLimitWait     = 10 * time.Minute // never wait longer than this for a reset
+)
+
+// APIError is returned when GitHub responds with an unexpected status
+type APIError struct {
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.Status, e.Body)
+}
+
+// networkError wraps a failure to send a request or read its response, which
+// may succeed on a retry
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string { return e.err.Error() }
+func (e *networkError) Unwrap() error { return e.err }
+
+// retryable reports whether a request that failed with err may succeed if sent
+// again: a 5xx response, or a network error while ctx is still live
+func retryable(ctx context.Context, err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500
+	}
+	var netErr *networkError
+	return errors.As(err, &netErr) && ctx.Err() == nil
+}
+
+// NotFoundError is returned for 404 responses, e.g. when a repository was deleted
+// or made private
+type NotFoundError struct {
+	Resource string // the API endpoint that was requested
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("not found: %s", e.Resource)
+}
+
+// ValidationError is returned for 422 responses, e.g. when a search query is
+// malformed or too long. Repeating the request won't help, but other requests may
+// still succeed.
+type ValidationError struct {
+	Resource string // the API endpoint that was requested
+	Message  string // GitHub's response body
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed for %s: %s", e.Resource, e.Message)
+}
+
+// RateLimitError is returned when GitHub rejects a request because a rate limit was hit
+type RateLimitError struct {
+	RetryAfter time.Time // when the request may be retried
+	Secondary  bool      // true for secondary (abuse detection) limits rather than the primary quota
+	Message    string
+}
+
+func (e *RateLimitError) Error() string {
+	kind := "rate limited"
+	if e.Secondary {
+		kind = "secondary rate limited"
+	}
+	return fmt.Sprintf("%s until %s: %s", kind, e.RetryAfter.Format(time.RFC3339), e.Message)
+}
+
+// newRateLimitError builds a RateLimitError from the Retry-After response header,
+// falling back to X-RateLimit-Reset for primary limits and to fallback for secondary
+// limits. The wait is capped at maxRateLimitWait.
+func newRateLimitError(h http.Header, body []byte, secondary bool, fallback time.Duration, now time.Time) *RateLimitError {
+	retryAt := now.Add(fallback)
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil && secs >= 0 {
+		retryAt = now.Add(time.Duration(secs) * time.Second)
+	} else if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil && !secondary {
+		// X-RateLimit-Reset describes the primary quota, so it says nothing about secondary limits
+		retryAt = time.Unix(reset, 0)
+	}
+
+	if retryAt.Before(now) {
+		retryAt = now
+	}
+	if limit := now.Add(maxRateLimitWait); retryAt.After(limit) {
+		retryAt = limit
+	}
+	return &RateLimitError{RetryAfter: retryAt, Secondary: secondary, Message: string(body)}
+}
+
+// isSecondaryRateLimit reports whether a response body is GitHub's secondary
+// rate limit (abuse detection) message
+func isSecondaryRateLimit(body []byte) bool {
+	msg := strings.ToLower(string(body))
+	return strings.Contains(msg, "secondary rate limit") || strings.Contains(msg, "abuse detection")
+}
+
+// isRateLimited reports whether a 403/429 response is a rate limit rather than
+// e.g. an authorization failure
+func isRateLimited(resp *http.Response, body []byte) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	return resp.Header.Get("Retry-After") != "" ||
+		resp.Header.Get("X-RateLimit-Remaining") == "0" ||
+		isSecondaryRateLimit(body)
+}
+
+// rateLimitError builds the error for a rate-limited response. Secondary limits
+// without a Retry-After header back off exponentially across consecutive hits.
+func (c *Client) rateLimitError(resp *http.Response, body []byte) *RateLimitError {
+	secondary := isSecondaryRateLimit(body)
+	fallback := defaultRateLimitWait
+	if secondary && resp.Header.Get("Retry-After") == "" {
+		c.rateMu.Lock()
+		if c.secondaryBackoff == 0 {
+			c.secondaryBackoff = defaultRateLimitWait
+		} else {
+			c.secondaryBackoff = min(c.secondaryBackoff*2, maxRateLimitWait)
+		}
+		fallback = c.secondaryBackoff
+		c.rateMu.Unlock()
+	}
+	return newRateLimitError(resp.Header, body, secondary, fallback, time.Now())
+}
+
+// resetSecondaryBackoff clears the secondary rate limit backoff after a successful request
+func (c *Client) resetSecondaryBackoff() {
+	c.rateMu.Lock()
+	c.secondaryBackoff = 0
+	c.rateMu.Unlock()
+}
+
+// WaitForRateLimit sleeps until a rate-limited request may be retried.
+// It returns false without sleeping if err is not a RateLimitError, and
+// false if ctx is cancelled before the wait is over.
+func WaitForRateLimit(ctx context.Context, err error) bool {
+	var rle *RateLimitError
+	if !errors.As(err, &rle) {
+		return false
+	}
+
+	wait := time.Until(rle.RetryAfter)
+	if rle.Secondary {
+		log.Printf("Secondary rate limit hit, waiting %s...", wait.Round(time.Second))
+	} else {
+		log.Printf("Rate limited, waiting %s...", wait.Round(time.Second))
+	}
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+
+	return sleep(ctx, wait) == nil
+}
+
+// Describe summarizes a GitHub error for humans, e.g. in a refresh job's error message,
+// distinguishing throttling and missing repositories from other API failures
+func Describe(err error) string {
+	var rle *RateLimitError
+	var nf *NotFoundError
+	var ve *ValidationError
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &rle):
+		kind := "rate limit"
+		if rle.Secondary {
+			kind = "secondary rate limit"
+		}
+		return fmt.Sprintf("throttled by GitHub %s (retry after %s): %v", kind, rle.RetryAfter.Format(time.RFC3339), err)
+	case errors.As(err, &nf):
+		return fmt.Sprintf("repository or resource no longer exists (deleted, renamed or private): %v", err)
+	case errors.As(err, &ve):
+		return fmt.Sprintf("GitHub rejected the request as invalid: %v", err)
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("GitHub API returned status %d: %v", apiErr.Status, err)
+	default:
+		return err.Error()
+	}
+}
@@ -0,0 +1,44 @@
+package github
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimitError(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	unix := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		secondary bool
+		want      time.Duration // after now
+	}{
+		{"retry-after", map[string]string{"Retry-After": "30"}, false, 30 * time.Second},
+		{"retry-after wins over reset", map[string]string{"Retry-After": "30", "X-RateLimit-Reset": unix(5 * time.Minute)}, false, 30 * time.Second},
+		{"reset", map[string]string{"X-RateLimit-Reset": unix(2 * time.Minute)}, false, 2 * time.Minute},
+		{"reset ignored for secondary", map[string]string{"X-RateLimit-Reset": unix(2 * time.Minute)}, true, defaultRateLimitWait},
+		{"no hint", nil, false, defaultRateLimitWait},
+		{"malformed retry-after", map[string]string{"Retry-After": "soon"}, false, defaultRateLimitWait},
+		{"reset in the past", map[string]string{"X-RateLimit-Reset": unix(-time.Minute)}, false, 0},
+		{"retry-after capped", map[string]string{"Retry-After": "3600"}, false, maxRateLimitWait},
+		{"reset capped", map[string]string{"X-RateLimit-Reset": unix(time.Hour)}, false, maxRateLimitWait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			err := newRateLimitError(h, []byte("slow down"), tt.secondary, defaultRateLimitWait, now)
+			if got := err.RetryAfter.Sub(now); got != tt.want {
+				t.Errorf("RetryAfter is %s after now, want %s", got, tt.want)
+			}
+			if err.Secondary != tt.secondary || err.Message != "slow down" {
+				t.Errorf("got %+v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package github
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// ETagStore persists ETags and the response bodies they belong to, so unchanged
+// resources can be re-fetched with conditional requests
+type ETagStore interface {
+	GetETag(endpoint string) (etag string, body []byte, err error) // etag is "" if none is stored
+	PutETag(endpoint, etag string, body []byte) error
+}
+
+// WithETagStore enables conditional requests for repo detail fetches. A 304 Not
+// Modified reuses the stored body and doesn't count against the rate limit.
+func WithETagStore(s ETagStore) Option {
+	return func(c *Client) {
+		c.etags = s
+	}
+}
+
+// ConditionalStats counts conditional repo detail requests since the client was created
+type ConditionalStats struct {
+	Hits   int64 `json:"hits"`   // 304 responses served from the ETag store
+	Misses int64 `json:"misses"` // full responses, because the repo changed or had no ETag yet
+}
+
+// conditionalCounters are updated concurrently by detail workers
+type conditionalCounters struct {
+	hits, misses atomic.Int64
+}
+
+// ConditionalStats returns the ETag hit/miss counters
+func (c *Client) ConditionalStats() ConditionalStats {
+	return ConditionalStats{Hits: c.conditional.hits.Load(), Misses: c.conditional.misses.Load()}
+}
+
+// isConditional reports whether a request should use the ETag store. Only
+// single-repo GETs (/repos/{owner}/{name}) are cached.
+func (c *Client) isConditional(method, endpoint string) bool {
+	if c.etags == nil || method != "GET" {
+		return false
+	}
+	rest, ok := strings.CutPrefix(endpoint, "/repos/")
+	return ok && strings.Count(rest, "/") == 1 && !strings.ContainsAny(rest, "?#")
+}
+
+// cachedETag returns the stored ETag and body for endpoint, or "" if there is none
+func (c *Client) cachedETag(endpoint string) (string, []byte) {
+	etag, body, err := c.etags.GetETag(endpoint)
+	if err != nil {
+		log.Printf("Error reading ETag for %s: %v", endpoint, err)
+		return "", nil
+	}
+	return etag, body
+}
+
+// storeETag saves a fresh response for later conditional requests
+func (c *Client) storeETag(endpoint, etag string, body []byte) {
+	if etag == "" {
+		return
+	}
+	if err := c.etags.PutETag(endpoint, etag, body); err != nil {
+		log.Printf("Error storing ETag for %s: %v", endpoint, err)
+	}
+}
@@ -0,0 +1,123 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// graphQLBatchSize is the max number of repositories queried in one GraphQL request
+const graphQLBatchSize = 50
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLRepo struct {
+	NameWithOwner   string `json:"nameWithOwner"`
+	URL             string `json:"url"`
+	Description     string `json:"description"`
+	StargazerCount  int    `json:"stargazerCount"`
+	PrimaryLanguage *struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	PushedAt   *time.Time `json:"pushedAt"`
+	IsArchived bool       `json:"isArchived"`
+	IsFork     bool       `json:"isFork"`
+	Topics     struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+}
+
+type graphQLResponse struct {
+	Data   map[string]*graphQLRepo `json:"data"`
+	Errors []struct {
+		Type    string   `json:"type"`
+		Message string   `json:"message"`
+		Path    []string `json:"path"`
+	} `json:"errors"`
+}
+
+// FetchReposBatch fetches metadata for up to 50 repositories in a single GraphQL request.
+// Repositories that could not be resolved (deleted, private, renamed) are omitted from the
+// result; an error is returned only when the request as a whole fails.
+func (c *Client) FetchReposBatch(ctx context.Context, repoNames []string) ([]RepoDetails, error) {
+	if len(repoNames) == 0 {
+		return nil, nil
+	}
+	if len(repoNames) > graphQLBatchSize {
+		return nil, fmt.Errorf("too many repos in batch: %d (max %d)", len(repoNames), graphQLBatchSize)
+	}
+
+	// Each repo gets an aliased field (r0, r1, ...) with its owner/name passed as variables
+	var params, fields []string
+	vars := make(map[string]interface{}, len(repoNames)*2)
+	for i, name := range repoNames {
+		owner, repo, ok := strings.Cut(name, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid repo name: %s", name)
+		}
+		params = append(params, fmt.Sprintf("$o%d: String!, $n%d: String!", i, i))
+		fields = append(fields, fmt.Sprintf("r%d: repository(owner: $o%d, name: $n%d) { ...repoFields }", i, i, i))
+		vars[fmt.Sprintf("o%d", i)] = owner
+		vars[fmt.Sprintf("n%d", i)] = repo
+	}
+	query := fmt.Sprintf(`query(%s) {
+%s
+}
+fragment repoFields on Repository { nameWithOwner url description stargazerCount primaryLanguage { name } pushedAt isArchived isFork repositoryTopics(first: 20) { nodes { topic { name } } } }`,
+		strings.Join(params, ", "), strings.Join(fields, "\n"))
+
+	payload, err := json.Marshal(graphQLRequest{Query: query, Variables: vars})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.doRequest(ctx, "POST", "/graphql", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp graphQLResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf("graphql error: %s", resp.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("graphql response had no data")
+	}
+
+	repos := make([]RepoDetails, 0, len(repoNames))
+	for i := range repoNames {
+		r := resp.Data[fmt.Sprintf("r%d", i)]
+		if r == nil {
+			continue
+		}
+		details := RepoDetails{
+			FullName:        r.NameWithOwner,
+			HTMLURL:         r.URL,
+			Description:     r.Description,
+			StargazersCount: r.StargazerCount,
+			PushedAt:        r.PushedAt,
+			Archived:        r.IsArchived,
+			Fork:            r.IsFork,
+		}
+		if r.PrimaryLanguage != nil {
+			details.Language = r.PrimaryLanguage.Name
+		}
+		for _, n := range r.Topics.Nodes {
+			details.Topics = append(details.Topics, n.Topic.Name)
+		}
+		repos = append(repos, details)
+	}
+	return repos, nil
+}
@@ -0,0 +1,16 @@
+package github
+
+import "context"
+
+// GitHubClient is the subset of GitHub operations used by the API layer.
+// *Client implements it against the real API; mock.MockClient implements it for tests.
+type GitHubClient interface {
+	FetchAllProjects(ctx context.Context, progressFn func(status string, current, total int)) ([]Project, error)
+	FetchProjects(ctx context.Context, queries []SearchQuery, progressFn func(status string, current, total int)) ([]Project, map[string]QueryStat, error)
+	SearchDHIUsage(ctx context.Context, progressFn func(queryName string, found int, page int)) (map[string]SearchResult, map[string]QueryStat, error)
+	GetRepoDetails(ctx context.Context, repoFullName string) (*RepoDetails, error)
+	GetFileFirstCommit(ctx context.Context, repoFullName, filePath string) (*AdoptionInfo, error)
+	GetFileContent(ctx context.Context, repoFullName, filePath string) (*FileContent, error)
+}
+
+var _ GitHubClient = (*Client)(nil)
@@ -0,0 +1,29 @@
+package github
+
+import "regexp"
+
+// ParseImages returns the images pulled from registry by the FROM instructions
+// in Dockerfile content, as "name:tag" or just "name" when untagged, in order of
+// first use and without duplicates. A digest is dropped in favor of the tag.
+func ParseImages(content, registry string) []string {
+	re := regexp.MustCompile(`(?im)^\s*FROM\s+(?:--platform=\S+\s+)?` + regexp.QuoteMeta(registry) + `/([^:@\s]+)(?::([^@\s]+))?`)
+	var images []string
+	seen := make(map[string]bool)
+	for _, m := range re.FindAllStringSubmatch(content, -1) {
+		image := m[1]
+		if m[2] != "" {
+			image += ":" + m[2]
+		}
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// ParseDHIImages returns the DefaultRegistry images used by FROM instructions in
+// Dockerfile content, as ParseImages does
+func ParseDHIImages(content string) []string {
+	return ParseImages(content, DefaultRegistry)
+}
@@ -0,0 +1,131 @@
+// Package mock provides an in-memory GitHubClient for tests.
+package mock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"dhi-oss-usage/internal/github"
+)
+
+// Call records a single method invocation on MockClient
+type Call struct {
+	Method string
+	Args   []string
+}
+
+// MockClient returns pre-loaded responses and records every call made to it.
+// Set Err to make every method fail with that error.
+type MockClient struct {
+	mu sync.Mutex
+
+	Projects      []github.Project
+	SearchResults map[string]github.SearchResult
+	QueryStats    map[string]github.QueryStat     // returned by searches, keyed by query name
+	Repos         map[string]*github.RepoDetails  // keyed by repo full name
+	Adoptions     map[string]*github.AdoptionInfo // keyed by repo full name
+	Files         map[string]*github.FileContent  // keyed by repo full name
+	Err           error
+
+	calls []Call
+}
+
+var _ github.GitHubClient = (*MockClient)(nil)
+
+// Calls returns a copy of the calls recorded so far
+func (m *MockClient) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+func (m *MockClient) record(method string, args ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, Call{Method: method, Args: args})
+}
+
+func (m *MockClient) FetchAllProjects(ctx context.Context, progressFn func(status string, current, total int)) ([]github.Project, error) {
+	m.record("FetchAllProjects")
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if progressFn != nil {
+		progressFn("fetching_details", len(m.Projects), len(m.Projects))
+	}
+	return append([]github.Project(nil), m.Projects...), nil
+}
+
+// FetchProjects ignores the queries and returns Projects like FetchAllProjects,
+// with QueryStats
+func (m *MockClient) FetchProjects(ctx context.Context, queries []github.SearchQuery, progressFn func(status string, current, total int)) ([]github.Project, map[string]github.QueryStat, error) {
+	names := make([]string, len(queries))
+	for i, q := range queries {
+		names[i] = q.Name
+	}
+	m.record("FetchProjects", names...)
+	if m.Err != nil {
+		return nil, nil, m.Err
+	}
+	if progressFn != nil {
+		progressFn("fetching_details", len(m.Projects), len(m.Projects))
+	}
+	return append([]github.Project(nil), m.Projects...), m.queryStats(), nil
+}
+
+func (m *MockClient) SearchDHIUsage(ctx context.Context, progressFn func(queryName string, found int, page int)) (map[string]github.SearchResult, map[string]github.QueryStat, error) {
+	m.record("SearchDHIUsage")
+	if m.Err != nil {
+		return nil, nil, m.Err
+	}
+	results := make(map[string]github.SearchResult, len(m.SearchResults))
+	for k, v := range m.SearchResults {
+		results[k] = v
+	}
+	return results, m.queryStats(), nil
+}
+
+func (m *MockClient) queryStats() map[string]github.QueryStat {
+	stats := make(map[string]github.QueryStat, len(m.QueryStats))
+	for k, v := range m.QueryStats {
+		stats[k] = v
+	}
+	return stats
+}
+
+func (m *MockClient) GetRepoDetails(ctx context.Context, repoFullName string) (*github.RepoDetails, error) {
+	m.record("GetRepoDetails", repoFullName)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	details, ok := m.Repos[repoFullName]
+	if !ok {
+		return nil, &github.NotFoundError{Resource: "/repos/" + repoFullName}
+	}
+	return details, nil
+}
+
+func (m *MockClient) GetFileFirstCommit(ctx context.Context, repoFullName, filePath string) (*github.AdoptionInfo, error) {
+	m.record("GetFileFirstCommit", repoFullName, filePath)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	info, ok := m.Adoptions[repoFullName]
+	if !ok {
+		return nil, fmt.Errorf("no commits found for file %s", filePath)
+	}
+	return info, nil
+}
+
+func (m *MockClient) GetFileContent(ctx context.Context, repoFullName, filePath string) (*github.FileContent, error) {
+	m.record("GetFileContent", repoFullName, filePath)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	fc, ok := m.Files[repoFullName]
+	if !ok {
+		return nil, &github.NotFoundError{Resource: "/repos/" + repoFullName + "/contents/" + filePath}
+	}
+	return fc, nil
+}
@@ -0,0 +1,156 @@
+package github
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dhi-oss-usage/internal/metrics"
+)
+
+// limiter is a token bucket allowing bursts up to its full budget and refilling
+// continuously. It only blocks once the budget is actually spent, and is safe for
+// concurrent use so parallel workers share the same budget.
+type limiter struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64 // may go negative while callers are queued for a token
+	rate     float64 // tokens per second
+	last     time.Time
+}
+
+// newLimiter returns a limiter allowing n requests per period
+func newLimiter(n int, per time.Duration) *limiter {
+	if n <= 0 {
+		return &limiter{}
+	}
+	return &limiter{
+		capacity: float64(n),
+		tokens:   float64(n),
+		rate:     float64(n) / per.Seconds(),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a request slot is available or ctx is done
+func (l *limiter) Wait(ctx context.Context) error {
+	if l.rate == 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	return sleep(ctx, delay)
+}
+
+// refund returns a token for a request that didn't count against the budget
+func (l *limiter) refund() {
+	if l.rate == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.tokens = min(l.capacity, l.tokens+1)
+	l.mu.Unlock()
+}
+
+// RateLimitState is the rate-limit budget GitHub last reported for a resource
+type RateLimitState struct {
+	Resource  string    `json:"resource"` // as reported by X-RateLimit-Resource, e.g. "core", "code_search"
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// rateBucket maps an endpoint to the budget it draws from
+func rateBucket(endpoint string) string {
+	switch {
+	case strings.HasPrefix(endpoint, "/search/"):
+		return "search"
+	case endpoint == "/graphql":
+		return "graphql"
+	default:
+		return "core"
+	}
+}
+
+// recordRateLimit stores the X-RateLimit-* headers of a response for bucket
+func (c *Client) recordRateLimit(bucket string, h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	state := RateLimitState{
+		Resource:  h.Get("X-RateLimit-Resource"),
+		Remaining: remaining,
+		UpdatedAt: time.Now(),
+	}
+	state.Limit, _ = strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		state.Reset = time.Unix(reset, 0)
+	}
+
+	c.rateMu.Lock()
+	c.rateStates[bucket] = state
+	c.rateMu.Unlock()
+	metrics.GitHubRateLimitRemaining.WithLabelValues(bucket).Set(float64(remaining))
+}
+
+// RateLimitStatus returns the last reported rate-limit state for each budget
+// ("core", "search", "graphql") that has been used so far
+func (c *Client) RateLimitStatus() map[string]RateLimitState {
+	c.rateMu.Lock()
+	defer c.rateMu.Unlock()
+
+	out := make(map[string]RateLimitState, len(c.rateStates))
+	for k, v := range c.rateStates {
+		out[k] = v
+	}
+	return out
+}
+
+// waitForReset sleeps until the budget resets if GitHub reported it exhausted
+func (c *Client) waitForReset(ctx context.Context, bucket string) error {
+	c.rateMu.Lock()
+	state, ok := c.rateStates[bucket]
+	c.rateMu.Unlock()
+	if !ok || state.Remaining > 0 {
+		return nil
+	}
+
+	wait := min(time.Until(state.Reset), maxRateLimitWait)
+	if wait <= 0 {
+		return nil
+	}
+	log.Printf("GitHub %s budget exhausted, waiting %s for reset", bucket, wait.Round(time.Second))
+	return sleep(ctx, wait)
+}
+
+// sleep pauses for d, returning ctx.Err() as soon as ctx is cancelled so a
+// refresh or shutdown never waits out a long rate-limit pause
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,61 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSleep(t *testing.T) {
+	if err := sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("sleep = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if err := sleep(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleep = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cancelled sleep returned after %s", d)
+	}
+}
+
+func TestCancelAbortsRateLimitedSearch(t *testing.T) {
+	requests := make(chan struct{}, 10)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.SearchUsage(ctx, []SearchQuery{{Name: "Dockerfile", Query: "dhi.io"}}, nil)
+		done <- err
+	}()
+
+	// Cancel once the search is waiting out the minute GitHub asked for
+	<-requests
+	time.Sleep(10 * time.Millisecond)
+	cancelled := time.Now()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("SearchUsage = %v, want context.Canceled", err)
+		}
+		if d := time.Since(cancelled); d > 100*time.Millisecond {
+			t.Errorf("search returned %s after cancel", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("search still sleeping 5s after cancel")
+	}
+	if n := len(requests); n != 0 {
+		t.Errorf("%d requests after the rate-limited one", n)
+	}
+}
@@ -0,0 +1,164 @@
+// Package health grades how credible a project is as an adoption reference,
+// from repository signals stored at refresh time. A grade depends only on the
+// stored signals and the rules, so it can always be recomputed from a project row.
+package health
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Factor names
+const (
+	RecentPush  = "recent_push"  // pushed within RecentPushWindow of the refresh that stored it
+	NotArchived = "not_archived" // repository isn't archived
+	NotFork     = "not_fork"     // repository isn't a fork
+)
+
+// RecentPushWindow is how recent the last push must be for RecentPush to pass
+const RecentPushWindow = 90 * 24 * time.Hour
+
+// Grades lists the possible grades, best first
+var Grades = []string{"A", "B", "C", "D"}
+
+// factors lists the factor names in the order they are reported
+var factors = []string{RecentPush, NotArchived, NotFork}
+
+// defaults is the built-in weight of each factor
+var defaults = map[string]int{
+	RecentPush:  40,
+	NotArchived: 40,
+	NotFork:     20,
+}
+
+// Signals are the stored repository fields a grade is computed from
+type Signals struct {
+	PushedAt *time.Time // nil if unknown, which fails RecentPush
+	Archived bool
+	Fork     bool
+	SeenAt   time.Time // when the signals were fetched; RecentPush is measured from here
+}
+
+// Factor is one rule and whether a project passed it
+type Factor struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Weight int    `json:"weight"`
+}
+
+// Result is a grade with the factors that produced it
+type Result struct {
+	Grade   string   `json:"grade"`
+	Score   int      `json:"score"` // percentage of the total weight passed
+	Factors []Factor `json:"factors"`
+}
+
+// Rules holds the weight of each factor
+type Rules struct {
+	weights map[string]int
+}
+
+// Default returns the built-in rules
+func Default() *Rules {
+	r, _ := Parse("")
+	return r
+}
+
+// Parse returns the built-in rules with weight overrides applied. The spec is a
+// comma-separated list of factor=weight pairs, e.g. "recent_push=60,not_fork=0".
+// A weight of 0 leaves the factor out of the grade.
+func Parse(spec string) (*Rules, error) {
+	r := &Rules{weights: make(map[string]int, len(defaults))}
+	for f, w := range defaults {
+		r.weights[f] = w
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, weight, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if _, known := defaults[name]; !ok || !known {
+			return nil, fmt.Errorf("invalid health rule %q: want factor=weight with factor one of %s", pair, strings.Join(factors, ", "))
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid health rule %q: weight must be a non-negative integer", pair)
+		}
+		r.weights[name] = w
+	}
+
+	total := 0
+	for _, w := range r.weights {
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("invalid health rules %q: at least one factor needs a weight", spec)
+	}
+	return r, nil
+}
+
+// Weights returns a copy of the effective factor weights
+func (r *Rules) Weights() map[string]int {
+	out := make(map[string]int, len(r.weights))
+	for f, w := range r.weights {
+		out[f] = w
+	}
+	return out
+}
+
+// Grade computes the grade for a project's signals. Factors with no weight are omitted.
+func (r *Rules) Grade(s Signals) Result {
+	passed := map[string]bool{
+		RecentPush:  s.PushedAt != nil && s.SeenAt.Sub(*s.PushedAt) <= RecentPushWindow,
+		NotArchived: !s.Archived,
+		NotFork:     !s.Fork,
+	}
+
+	var res Result
+	total, earned := 0, 0
+	for _, name := range factors {
+		w := r.weights[name]
+		if w == 0 {
+			continue
+		}
+		res.Factors = append(res.Factors, Factor{Name: name, Passed: passed[name], Weight: w})
+		total += w
+		if passed[name] {
+			earned += w
+		}
+	}
+	res.Score = earned * 100 / total
+
+	switch {
+	case res.Score >= 90:
+		res.Grade = "A"
+	case res.Score >= 70:
+		res.Grade = "B"
+	case res.Score >= 50:
+		res.Grade = "C"
+	default:
+		res.Grade = "D"
+	}
+	return res
+}
+
+// Count is the number of projects with a grade
+type Count struct {
+	Grade string `json:"grade"`
+	Count int    `json:"count"`
+}
+
+// Distribution orders per-grade counts best grade first, including grades with no
+// projects. Ungraded projects (stored before grading existed) are left out.
+func Distribution(gradeCounts map[string]int) []Count {
+	counts := make([]Count, len(Grades))
+	for i, g := range Grades {
+		counts[i] = Count{Grade: g, Count: gradeCounts[g]}
+	}
+	return counts
+}
@@ -0,0 +1,87 @@
+// Package metrics holds the Prometheus metrics of the tracker and serves them in
+// the exposition format. The metrics live in their own registry, so the handler
+// only shows these and not the Go runtime defaults.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Refresh job results, for RefreshJobs
+const (
+	ResultCompleted = "completed"
+	ResultFailed    = "failed"
+	ResultCancelled = "cancelled"
+)
+
+// Cache lookup results, for CacheRequests
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+var (
+	// ProjectsTotal is the number of tracked projects, set after each refresh
+	ProjectsTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dhi_projects_total",
+		Help: "Tracked projects, excluding soft-deleted ones.",
+	})
+	// TotalStars is the sum of stars of tracked projects, set after each refresh
+	TotalStars = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dhi_total_stars",
+		Help: "Stars summed over tracked projects.",
+	})
+	// RefreshDuration observes how long refresh discovery took, whatever the result
+	RefreshDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dhi_refresh_duration_seconds",
+		Help:    "Time from a refresh job starting until its projects were saved or it failed.",
+		Buckets: []float64{10, 30, 60, 120, 300, 600},
+	})
+	// GitHubRequests counts GitHub API responses by HTTP status; "error" when no response arrived
+	GitHubRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhi_github_api_requests_total",
+		Help: "GitHub API requests by response status.",
+	}, []string{"status"})
+	// RefreshJobs counts finished refresh jobs by result: completed, failed or cancelled
+	RefreshJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhi_refresh_jobs_total",
+		Help: "Finished refresh jobs by result.",
+	}, []string{"result"})
+	// LastRefreshSuccess is when the last refresh job completed, as a Unix time
+	LastRefreshSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dhi_last_successful_refresh_timestamp_seconds",
+		Help: "Unix time the last refresh job completed; 0 until one has.",
+	})
+	// GitHubRateLimitRemaining is the budget GitHub last reported left, by resource
+	GitHubRateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dhi_github_rate_limit_remaining",
+		Help: "GitHub API requests left in the current window, by rate-limit resource.",
+	}, []string{"resource"})
+	// HTTPRequestDuration observes API response times by route pattern, method and status
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhi_http_request_duration_seconds",
+		Help:    "API request durations by route, method and response status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	// CacheRequests counts API query cache lookups by result: hit or miss
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhi_api_cache_requests_total",
+		Help: "API query cache lookups by result.",
+	}, []string{"result"})
+)
+
+// Registry holds every metric above
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(ProjectsTotal, TotalStars, RefreshDuration, GitHubRequests, RefreshJobs, LastRefreshSuccess,
+		GitHubRateLimitRemaining, HTTPRequestDuration, CacheRequests)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
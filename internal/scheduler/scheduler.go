@@ -0,0 +1,133 @@
+// Package scheduler triggers refreshes on a cron schedule. The next run time is
+// persisted, so a run missed while the server was down is caught up on start.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultSchedule is the refresh schedule used when none is configured: every 6 hours
+const DefaultSchedule = "0 */6 * * *"
+
+// Refresher starts a refresh, reporting whether one was started. *api.API implements it.
+type Refresher interface {
+	TriggerRefresh(source string) bool
+}
+
+// Store persists the next scheduled run across restarts
+type Store interface {
+	// GetNextScheduledRefresh returns the stored next run and the schedule it was
+	// computed from, or a nil time if none is stored
+	GetNextScheduledRefresh() (*time.Time, string, error)
+	SetNextScheduledRefresh(next time.Time, schedule string) error
+}
+
+// Scheduler calls a Refresher on a cron schedule
+type Scheduler struct {
+	spec      string
+	schedule  cron.Schedule
+	refresher Refresher
+	store     Store
+
+	mu   sync.Mutex
+	cron *cron.Cron // nil until started
+}
+
+// New returns a scheduler for a standard five-field cron expression
+func New(spec string, refresher Refresher, store Store) (*Scheduler, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh schedule %q: %w", spec, err)
+	}
+	return &Scheduler{spec: spec, schedule: schedule, refresher: refresher, store: store}, nil
+}
+
+// Start runs the schedule until ctx is done or Stop is called. If the stored next
+// run for the same schedule has already passed, a refresh is triggered immediately.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	if s.cron != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.cron = cron.New()
+	s.cron.Schedule(s.schedule, cron.FuncJob(s.run))
+	c := s.cron
+	s.mu.Unlock()
+
+	missed := s.missedRun()
+	c.Start()
+	s.persistNext()
+	log.Printf("Scheduler started: refresh at '%s'", s.spec)
+
+	if missed != nil {
+		log.Printf("Scheduled refresh due at %s was missed, triggering now", missed.Format(time.RFC3339))
+		s.refresher.TriggerRefresh("scheduled")
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+}
+
+// Stop stops the schedule. A refresh that is already running is not interrupted.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	c := s.cron
+	s.cron = nil
+	s.mu.Unlock()
+	if c != nil {
+		c.Stop()
+		log.Printf("Scheduler stopped")
+	}
+}
+
+// Next returns the next scheduled refresh, or nil if the scheduler isn't running
+func (s *Scheduler) Next() *time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cron == nil {
+		return nil
+	}
+	entries := s.cron.Entries()
+	if len(entries) == 0 {
+		return nil
+	}
+	next := entries[0].Next
+	return &next
+}
+
+func (s *Scheduler) run() {
+	log.Printf("Scheduled refresh triggered (schedule: %s)", s.spec)
+	s.refresher.TriggerRefresh("scheduled")
+	s.persistNext()
+}
+
+// missedRun returns the stored next run if it is in the past and was computed
+// from the current schedule
+func (s *Scheduler) missedRun() *time.Time {
+	next, spec, err := s.store.GetNextScheduledRefresh()
+	if err != nil {
+		log.Printf("Error reading next scheduled refresh: %v", err)
+		return nil
+	}
+	if next == nil || spec != s.spec || next.After(time.Now()) {
+		return nil
+	}
+	return next
+}
+
+// persistNext stores the schedule's next run after now
+func (s *Scheduler) persistNext() {
+	next := s.schedule.Next(time.Now())
+	if err := s.store.SetNextScheduledRefresh(next, s.spec); err != nil {
+		log.Printf("Error storing next scheduled refresh: %v", err)
+	}
+}
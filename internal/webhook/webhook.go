@@ -0,0 +1,112 @@
+// Package webhook delivers JSON events to an operator-configured URL.
+//
+// Deliveries are POSTs signed like GitHub's webhooks: with a secret set, the
+// X-Hub-Signature-256 header carries "sha256=" and the hex HMAC-SHA256 of the
+// body, so receivers can reuse their GitHub verification code.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// MaxRetries is how many times a failed delivery is retried
+const MaxRetries = 3
+
+// defaultBackoff is the wait before the first retry; each further retry waits twice as long
+const defaultBackoff = time.Second
+
+// SignatureHeader carries the body's HMAC when a secret is set
+const SignatureHeader = "X-Hub-Signature-256"
+
+// Sender posts events to a URL
+type Sender struct {
+	URL     string
+	Secret  string        // signs deliveries when set
+	Client  *http.Client  // nil uses a client with a 10 second timeout
+	Backoff time.Duration // wait before the first retry; 0 means one second
+}
+
+// Attempt is the outcome of one try at delivering an event
+type Attempt struct {
+	Number     int // 1 for the first try
+	StatusCode int // 0 if there was no response
+	Err        error
+	Duration   time.Duration
+}
+
+// Sign returns the SignatureHeader value for body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts body, retrying network errors, 429s and 5xx responses up to
+// MaxRetries times with exponential backoff. It calls record, if not nil, after
+// each attempt and returns the error of the last one.
+func (s *Sender) Send(ctx context.Context, body []byte, record func(Attempt)) error {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	backoff := s.Backoff
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+
+	for n := 1; ; n++ {
+		a := s.attempt(ctx, client, body)
+		a.Number = n
+		if record != nil {
+			record(a)
+		}
+		if a.Err == nil || !retryable(a) || n > MaxRetries {
+			return a.Err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (s *Sender) attempt(ctx context.Context, client *http.Client, body []byte) Attempt {
+	started := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return Attempt{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Attempt{Err: err, Duration: time.Since(started)}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	a := Attempt{StatusCode: resp.StatusCode, Duration: time.Since(started)}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Err = fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return a
+}
+
+// retryable reports whether a failed attempt may succeed if repeated: the
+// request never got a response, or the receiver is overloaded or broken
+func retryable(a Attempt) bool {
+	return a.StatusCode == 0 || a.StatusCode == http.StatusTooManyRequests || a.StatusCode >= 500
+}
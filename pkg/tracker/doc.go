@@ -0,0 +1,29 @@
+// Package tracker runs the discovery and enrichment pipeline behind the DHI
+// usage tracker without the HTTP server: search GitHub for the configured
+// queries, save the projects found, diff them against what was tracked before,
+// soft-delete repos that no longer exist, mark projects that stopped matching
+// as inactive, look up adoption dates and record a snapshot.
+//
+// A Tracker talks to GitHub through a Source and persists through a Store, so
+// it can run against any search term and any database. It never logs and has
+// no HTTP dependencies; callers observe a run through Options.OnEvent.
+//
+//	t := tracker.New(store, source, []tracker.Query{
+//		{Name: "Dockerfiles", Query: `"FROM registry.example.com" filename:Dockerfile`},
+//	}, tracker.Options{
+//		OnEvent: func(e tracker.Event) { log.Printf("%s %s %v", e.Kind, e.Repo, e.Err) },
+//	})
+//	res, err := t.Run(ctx)
+//
+// # Stability
+//
+// Tracker, Options, Source, Store, Query, Project, Adoption, PendingAdoption,
+// Scope, Event, EventKind, Result, Diff and ErrSnapshotSkipped are the public
+// API. Within a major version, fields and constants may be added but existing
+// ones are not removed or changed in meaning; new methods are never added to
+// Source or Store, so existing implementations keep compiling. Optional stages
+// and capabilities are added as separate interfaces instead, like BatchStore, and
+// FileSource and FileStore for ScopeFileChanges along with File, WatchedFile,
+// FileChange, RelevantLines, LineDiff and ErrDeferred. The order of events within
+// a stage is not guaranteed.
+package tracker
@@ -0,0 +1,82 @@
+package tracker_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dhi-oss-usage/pkg/tracker"
+)
+
+// staticSource finds the same projects on every run
+type staticSource []tracker.Project
+
+func (s staticSource) Discover(ctx context.Context, queries []tracker.Query, progress func(string, int, int)) ([]tracker.Project, error) {
+	return s, nil
+}
+
+func (s staticSource) Exists(ctx context.Context, repoFullName string) (bool, error) {
+	return false, nil
+}
+
+func (s staticSource) FirstAdoption(ctx context.Context, repoFullName, filePath string) (*tracker.Adoption, error) {
+	return &tracker.Adoption{Date: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+// mapStore keeps projects in a map keyed by repo name
+type mapStore map[string]tracker.Project
+
+func (s mapStore) TrackedRepos() ([]string, error) {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (s mapStore) SaveProject(p tracker.Project) error {
+	s[p.RepoFullName] = p
+	return nil
+}
+
+func (s mapStore) SoftDelete(repoFullName string) error {
+	delete(s, repoFullName)
+	return nil
+}
+
+func (s mapStore) RecordDiff(added, removed []string) error             { return nil }
+func (s mapStore) RecordQueryMatches(matches map[string][]string) error { return nil }
+func (s mapStore) MarkInactive(before time.Time) (int, error)           { return 0, nil }
+func (s mapStore) PendingAdoptions() ([]tracker.PendingAdoption, error) { return nil, nil }
+func (s mapStore) SaveAdoption(id int64, a tracker.Adoption) error      { return nil }
+func (s mapStore) RecordSnapshot() error                                { return tracker.ErrSnapshotSkipped }
+
+func Example() {
+	store := mapStore{"acme/old": {RepoFullName: "acme/old"}}
+	source := staticSource{{RepoFullName: "acme/app", Stars: 42}}
+
+	t := tracker.New(store, source, []tracker.Query{
+		{Name: "Dockerfiles", Query: `"FROM registry.example.com" filename:Dockerfile`},
+	}, tracker.Options{
+		OnEvent: func(e tracker.Event) {
+			if e.Kind == tracker.EventSoftDeleted {
+				fmt.Println("gone:", e.Repo)
+			}
+		},
+	})
+	res, err := t.Run(context.Background())
+	if err != nil {
+		fmt.Println("refresh failed:", err)
+		return
+	}
+	fmt.Println("found:", len(res.Projects), "added:", res.Added, "removed:", res.Removed)
+	// Output:
+	// gone: acme/old
+	// found: 1 added: [acme/app] removed: [acme/old]
+}
+
+func ExampleDiff() {
+	added, removed := tracker.Diff([]string{"acme/a", "acme/b"}, []tracker.Project{{RepoFullName: "acme/b"}, {RepoFullName: "acme/c"}})
+	fmt.Println(added, removed)
+	// Output: [acme/c] [acme/a]
+}
@@ -0,0 +1,172 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrDeferred may be returned by FileSource.FileContent to end the file changes
+// stage early, e.g. to keep API budget for core work. Files not yet checked are
+// checked on a later run.
+var ErrDeferred = errors.New("deferred to a later run")
+
+// File is the content of a watched file at the time of a run
+type File struct {
+	Hash    string // changes exactly when the content does
+	Content []byte
+	// Skipped is set instead of Content when the file can't be diffed: SkippedBinary or SkippedTooLarge
+	Skipped string
+}
+
+// Reasons a file's content isn't diffed
+const (
+	SkippedBinary   = "binary"
+	SkippedTooLarge = "too_large"
+)
+
+// WatchedFile is a stored project whose matched file is tracked for changes
+type WatchedFile struct {
+	ID           int64 // Store-defined identifier
+	RepoFullName string
+	FilePath     string
+	Match        string   // lines containing Match are the relevant ones, e.g. the registry host
+	Hash         string   // hash of the last version seen; "" if the file hasn't been seen yet
+	Lines        []string // relevant lines of the last version that could be read
+}
+
+// FileChange is a change to the relevant lines of a watched file
+type FileChange struct {
+	OldHash string
+	NewHash string
+	Diff    string // unified-style diff of the relevant lines, without headers
+	Skipped string // set instead of Diff when the new version couldn't be read
+}
+
+// FileSource is implemented by Sources that can read files, for ScopeFileChanges
+type FileSource interface {
+	FileContent(ctx context.Context, repoFullName, filePath string) (*File, error)
+}
+
+// FileStore is implemented by Stores that keep file versions, for ScopeFileChanges
+type FileStore interface {
+	// WatchedFiles returns the files whose changes are tracked
+	WatchedFiles() ([]WatchedFile, error)
+	// SaveFile stores the latest hash and relevant lines of a file and, if the
+	// relevant lines changed, the change
+	SaveFile(f WatchedFile, change *FileChange) error
+}
+
+// fileChanges checks watched files for changes to their relevant lines. It does
+// nothing unless the Source and Store implement FileSource and FileStore.
+func (t *Tracker) fileChanges(ctx context.Context, res *Result) error {
+	source, ok := t.source.(FileSource)
+	if !ok {
+		return nil
+	}
+	store, ok := t.store.(FileStore)
+	if !ok {
+		return nil
+	}
+
+	watched, err := store.WatchedFiles()
+	if err != nil {
+		t.emitError("file_changes", "", err)
+		return nil
+	}
+
+	for i, w := range watched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t.emit(Event{Kind: EventProgress, Stage: "file_changes", Current: i + 1, Total: len(watched), Repo: w.RepoFullName})
+
+		f, err := source.FileContent(ctx, w.RepoFullName, w.FilePath)
+		if errors.Is(err, ErrDeferred) {
+			return nil
+		}
+		if err != nil {
+			t.emitError("file_changes", w.RepoFullName, err)
+			continue
+		}
+		if f.Hash == w.Hash {
+			continue
+		}
+
+		updated := w
+		updated.Hash = f.Hash
+		var change *FileChange
+		switch {
+		case f.Skipped != "":
+			// Keep the last readable lines to diff against once the file is readable again
+			if w.Hash != "" {
+				change = &FileChange{OldHash: w.Hash, NewHash: f.Hash, Skipped: f.Skipped}
+			}
+		default:
+			updated.Lines = RelevantLines(f.Content, w.Match)
+			// The first version seen is the baseline, not a change
+			if w.Hash != "" {
+				if diff := LineDiff(w.Lines, updated.Lines); diff != "" {
+					change = &FileChange{OldHash: w.Hash, NewHash: f.Hash, Diff: diff}
+				}
+			}
+		}
+
+		if err := store.SaveFile(updated, change); err != nil {
+			t.emitError("file_changes", w.RepoFullName, err)
+			continue
+		}
+		if change != nil {
+			res.FileChanges++
+			t.emit(Event{Kind: EventFileChange, Repo: w.RepoFullName, FileChange: change})
+		}
+	}
+	return nil
+}
+
+// RelevantLines returns the trimmed lines of content that contain match
+func RelevantLines(content []byte, match string) []string {
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.Contains(line, match) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+// LineDiff returns the lines removed from old ("-") and added in new ("+"), in
+// order, using a longest common subsequence. It returns "" if they are equal.
+func LineDiff(old, new []string) string {
+	// lcs[i][j] is the LCS length of old[i:] and new[j:]
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			i++
+			j++
+		case j < len(new) && (i == len(old) || lcs[i][j+1] >= lcs[i+1][j]):
+			b.WriteString("+" + new[j] + "\n")
+			j++
+		default:
+			b.WriteString("-" + old[i] + "\n")
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,363 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Query is a named GitHub code search query
+type Query struct {
+	Name     string
+	Query    string
+	Registry string // registry host the query looks for; recorded on the projects it finds
+}
+
+// Project is a repository found by a search, with its metadata
+type Project struct {
+	RepoFullName    string
+	GitHubURL       string
+	Stars           int
+	Description     string
+	PrimaryLanguage string
+	FilePath        string // file the first matching query found
+	FileURL         string
+	SourceType      string     // name of the first query that matched
+	Registry        string     // registry host of the first query that matched
+	MatchedQueries  []string   // names of every query that matched
+	PushedAt        *time.Time // last push to the repo; nil if unknown
+	Archived        bool
+	Fork            bool
+	Topics          []string // GitHub repo topics
+}
+
+// Adoption is when a project first added the tracked reference
+type Adoption struct {
+	Date      time.Time
+	CommitSHA string
+	CommitURL string
+}
+
+// PendingAdoption is a stored project whose adoption date is still unknown
+type PendingAdoption struct {
+	ID           int64 // Store-defined identifier, passed back to SaveAdoption
+	RepoFullName string
+	FilePath     string
+}
+
+// Source looks things up on GitHub. Implementations handle their own rate limiting
+// and retries.
+type Source interface {
+	// Discover runs the queries and returns every unique project found, with metadata.
+	// progress may be nil.
+	Discover(ctx context.Context, queries []Query, progress func(stage string, current, total int)) ([]Project, error)
+	// Exists reports whether a repository can still be found. It returns false, nil
+	// only when the repository is definitely gone (deleted, renamed or private).
+	Exists(ctx context.Context, repoFullName string) (bool, error)
+	// FirstAdoption returns the first commit that added filePath to the repository
+	FirstAdoption(ctx context.Context, repoFullName, filePath string) (*Adoption, error)
+}
+
+// Store persists the results of a run. A Store is typically bound to a single run,
+// e.g. so diffs and query matches can be attributed to a job.
+type Store interface {
+	// TrackedRepos returns the repos tracked before this run
+	TrackedRepos() ([]string, error)
+	SaveProject(p Project) error
+	RecordDiff(added, removed []string) error
+	// RecordQueryMatches stores the query names that matched each repo
+	RecordQueryMatches(matches map[string][]string) error
+	SoftDelete(repoFullName string) error
+	// MarkInactive flags projects not saved since before and returns how many were flagged
+	MarkInactive(before time.Time) (int, error)
+	PendingAdoptions() ([]PendingAdoption, error)
+	SaveAdoption(id int64, a Adoption) error
+	// RecordSnapshot may return ErrSnapshotSkipped when it decided not to record one
+	RecordSnapshot() error
+}
+
+// BatchStore is implemented by stores that can save all projects found by a run
+// at once, e.g. in one transaction. Discovery then calls SaveProjects instead of
+// SaveProject per project, and fails if it returns an error, since the store
+// then saved none of them.
+type BatchStore interface {
+	SaveProjects(projects []Project) error
+}
+
+// ErrSnapshotSkipped may be returned by Store.RecordSnapshot when no snapshot was
+// needed, e.g. because nothing changed since the last one. It isn't reported as
+// an error, and Result.Snapshot stays false.
+var ErrSnapshotSkipped = errors.New("snapshot skipped")
+
+// Scope selects the stages RunPartial performs
+type Scope uint
+
+const (
+	// ScopeDiscover searches, saves projects, records the diff and query matches,
+	// soft-deletes repos that are gone and marks unseen projects inactive
+	ScopeDiscover Scope = 1 << iota
+	// ScopeAdoptions looks up adoption dates for projects that don't have one
+	ScopeAdoptions
+	// ScopeSnapshot records a snapshot of the current totals
+	ScopeSnapshot
+	// ScopeFileChanges diffs the relevant lines of watched files against the last
+	// version seen. It needs a FileSource and FileStore and isn't part of ScopeAll.
+	ScopeFileChanges
+
+	ScopeAll = ScopeDiscover | ScopeAdoptions | ScopeSnapshot
+)
+
+// EventKind identifies what an Event reports
+type EventKind string
+
+const (
+	EventProgress    EventKind = "progress"     // Stage, Current and Total are set
+	EventDiscovered  EventKind = "discovered"   // Count projects were found
+	EventDiff        EventKind = "diff"         // Added and Removed are set
+	EventSoftDeleted EventKind = "soft_deleted" // Repo no longer exists
+	EventInactive    EventKind = "inactive"     // Count projects were marked inactive
+	EventAdoption    EventKind = "adoption"     // Repo's adoption date was saved
+	EventSnapshot    EventKind = "snapshot"     // a snapshot was recorded
+	EventFileChange  EventKind = "file_change"  // Repo's watched file changed
+	EventError       EventKind = "error"        // a non-fatal error; Err is set, Repo when it concerns one repo
+)
+
+// Event reports progress or an outcome during a run
+type Event struct {
+	Kind    EventKind
+	Stage   string // EventProgress and EventError: the stage, e.g. "searching", "adoptions"
+	Current int
+	Total   int
+	Count   int
+	Repo    string
+	Added   []string
+	Removed []string
+	// Adoption is set for EventAdoption
+	Adoption *Adoption
+	// FileChange is set for EventFileChange
+	FileChange *FileChange
+	Err        error
+}
+
+// Options configures a Tracker
+type Options struct {
+	// OnEvent is called synchronously for every event; it may be nil
+	OnEvent func(Event)
+	// Now returns the current time; defaults to time.Now
+	Now func() time.Time
+}
+
+// Result summarizes a run
+type Result struct {
+	Projects    []Project
+	Added       []string
+	Removed     []string
+	SoftDeleted []string
+	Inactive    int
+	Adoptions   int
+	FileChanges int
+	Snapshot    bool
+}
+
+// Tracker runs the pipeline for a set of queries
+type Tracker struct {
+	store   Store
+	source  Source
+	queries []Query
+	opts    Options
+}
+
+// New returns a Tracker that searches source with queries and saves to store
+func New(store Store, source Source, queries []Query, opts Options) *Tracker {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Tracker{store: store, source: source, queries: queries, opts: opts}
+}
+
+// Run performs every stage of the pipeline
+func (t *Tracker) Run(ctx context.Context) (*Result, error) {
+	return t.RunPartial(ctx, ScopeAll)
+}
+
+// RunPartial performs the stages in scope, in pipeline order. It returns an error
+// only if discovery fails or ctx is cancelled; other failures are reported as
+// EventError and the run continues.
+func (t *Tracker) RunPartial(ctx context.Context, scope Scope) (*Result, error) {
+	res := &Result{}
+	if scope&ScopeDiscover != 0 {
+		if err := t.discover(ctx, res); err != nil {
+			return res, err
+		}
+	}
+	if scope&ScopeAdoptions != 0 {
+		if err := t.adoptions(ctx, res); err != nil {
+			return res, err
+		}
+	}
+	if scope&ScopeFileChanges != 0 {
+		if err := t.fileChanges(ctx, res); err != nil {
+			return res, err
+		}
+	}
+	if scope&ScopeSnapshot != 0 {
+		switch err := t.store.RecordSnapshot(); {
+		case errors.Is(err, ErrSnapshotSkipped):
+		case err != nil:
+			t.emitError("snapshot", "", err)
+		default:
+			res.Snapshot = true
+			t.emit(Event{Kind: EventSnapshot})
+		}
+	}
+	return res, nil
+}
+
+func (t *Tracker) emit(e Event) {
+	if t.opts.OnEvent != nil {
+		t.opts.OnEvent(e)
+	}
+}
+
+func (t *Tracker) emitError(stage, repo string, err error) {
+	t.emit(Event{Kind: EventError, Stage: stage, Repo: repo, Err: err})
+}
+
+func (t *Tracker) discover(ctx context.Context, res *Result) error {
+	startedAt := t.opts.Now()
+	projects, err := t.source.Discover(ctx, t.queries, func(stage string, current, total int) {
+		t.emit(Event{Kind: EventProgress, Stage: stage, Current: current, Total: total})
+	})
+	if err != nil {
+		return err
+	}
+	res.Projects = projects
+	t.emit(Event{Kind: EventDiscovered, Count: len(projects)})
+
+	// Remember what was tracked before this run so we can diff against it
+	previous, prevErr := t.store.TrackedRepos()
+	if prevErr != nil {
+		t.emitError("diff", "", prevErr)
+	}
+
+	batch, isBatch := t.store.(BatchStore)
+	if isBatch {
+		if err := batch.SaveProjects(projects); err != nil {
+			return fmt.Errorf("saving projects: %w", err)
+		}
+	}
+	matches := make(map[string][]string, len(projects))
+	for _, p := range projects {
+		if !isBatch {
+			if err := t.store.SaveProject(p); err != nil {
+				t.emitError("save", p.RepoFullName, err)
+			}
+		}
+		if len(p.MatchedQueries) > 0 {
+			matches[p.RepoFullName] = p.MatchedQueries
+		}
+	}
+
+	if prevErr == nil {
+		res.Added, res.Removed = Diff(previous, projects)
+		if err := t.store.RecordDiff(res.Added, res.Removed); err != nil {
+			t.emitError("diff", "", err)
+		} else {
+			t.emit(Event{Kind: EventDiff, Added: res.Added, Removed: res.Removed})
+		}
+		if err := t.pruneMissing(ctx, res); err != nil {
+			return err
+		}
+	}
+
+	if len(matches) > 0 {
+		if err := t.store.RecordQueryMatches(matches); err != nil {
+			t.emitError("query_matches", "", err)
+		}
+	}
+
+	// Anything this run didn't see no longer matches the queries
+	n, err := t.store.MarkInactive(startedAt)
+	if err != nil {
+		t.emitError("inactive", "", err)
+	} else {
+		res.Inactive = n
+		t.emit(Event{Kind: EventInactive, Count: n})
+	}
+	return nil
+}
+
+// pruneMissing soft-deletes removed repos that no longer exist on GitHub
+func (t *Tracker) pruneMissing(ctx context.Context, res *Result) error {
+	for _, name := range res.Removed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		exists, err := t.source.Exists(ctx, name)
+		if err != nil {
+			t.emitError("prune", name, err)
+			continue
+		}
+		if exists {
+			continue
+		}
+		if err := t.store.SoftDelete(name); err != nil {
+			t.emitError("prune", name, err)
+			continue
+		}
+		res.SoftDeleted = append(res.SoftDeleted, name)
+		t.emit(Event{Kind: EventSoftDeleted, Repo: name})
+	}
+	return nil
+}
+
+// adoptions looks up adoption dates for projects that don't have one
+func (t *Tracker) adoptions(ctx context.Context, res *Result) error {
+	pending, err := t.store.PendingAdoptions()
+	if err != nil {
+		t.emitError("adoptions", "", err)
+		return nil
+	}
+
+	for i, p := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t.emit(Event{Kind: EventProgress, Stage: "adoptions", Current: i + 1, Total: len(pending), Repo: p.RepoFullName})
+
+		adoption, err := t.source.FirstAdoption(ctx, p.RepoFullName, p.FilePath)
+		if err != nil {
+			t.emitError("adoptions", p.RepoFullName, err)
+			continue
+		}
+		if err := t.store.SaveAdoption(p.ID, *adoption); err != nil {
+			t.emitError("adoptions", p.RepoFullName, err)
+			continue
+		}
+		res.Adoptions++
+		t.emit(Event{Kind: EventAdoption, Repo: p.RepoFullName, Adoption: adoption})
+	}
+	return nil
+}
+
+// Diff compares the previously tracked repos with the ones found by a run
+func Diff(previous []string, found []Project) (added, removed []string) {
+	prevSet := make(map[string]bool, len(previous))
+	for _, name := range previous {
+		prevSet[name] = true
+	}
+	foundSet := make(map[string]bool, len(found))
+	for _, p := range found {
+		foundSet[p.RepoFullName] = true
+		if !prevSet[p.RepoFullName] {
+			added = append(added, p.RepoFullName)
+		}
+	}
+	for _, name := range previous {
+		if !foundSet[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,286 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeSource serves projects from memory. Repos in gone no longer exist.
+type fakeSource struct {
+	projects   []Project
+	gone       map[string]bool
+	adoptions  map[string]*Adoption
+	discoverFn func(ctx context.Context) error // runs before Discover returns; may be nil
+	err        error
+}
+
+func (s *fakeSource) Discover(ctx context.Context, queries []Query, progress func(stage string, current, total int)) ([]Project, error) {
+	if s.discoverFn != nil {
+		if err := s.discoverFn(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if progress != nil {
+		progress("searching", len(queries), len(queries))
+	}
+	return s.projects, nil
+}
+
+func (s *fakeSource) Exists(ctx context.Context, repoFullName string) (bool, error) {
+	return !s.gone[repoFullName], nil
+}
+
+func (s *fakeSource) FirstAdoption(ctx context.Context, repoFullName, filePath string) (*Adoption, error) {
+	a, ok := s.adoptions[repoFullName]
+	if !ok {
+		return nil, errors.New("no commits found")
+	}
+	return a, nil
+}
+
+// fakeStore keeps everything a run saves in memory
+type fakeStore struct {
+	tracked     []string
+	saved       map[string]Project
+	added       []string
+	removed     []string
+	matches     map[string][]string
+	softDeleted []string
+	inactiveAt  time.Time
+	pending     []PendingAdoption
+	adoptions   map[int64]Adoption
+	snapshots   int
+	snapshotErr error
+}
+
+func newFakeStore(tracked ...string) *fakeStore {
+	return &fakeStore{tracked: tracked, saved: make(map[string]Project), adoptions: make(map[int64]Adoption)}
+}
+
+func (s *fakeStore) TrackedRepos() ([]string, error) { return s.tracked, nil }
+
+func (s *fakeStore) SaveProject(p Project) error {
+	s.saved[p.RepoFullName] = p
+	return nil
+}
+
+func (s *fakeStore) RecordDiff(added, removed []string) error {
+	s.added, s.removed = added, removed
+	return nil
+}
+
+func (s *fakeStore) RecordQueryMatches(matches map[string][]string) error {
+	s.matches = matches
+	return nil
+}
+
+func (s *fakeStore) SoftDelete(repoFullName string) error {
+	s.softDeleted = append(s.softDeleted, repoFullName)
+	return nil
+}
+
+func (s *fakeStore) MarkInactive(before time.Time) (int, error) {
+	s.inactiveAt = before
+	n := 0
+	for _, name := range s.tracked {
+		if _, ok := s.saved[name]; !ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *fakeStore) PendingAdoptions() ([]PendingAdoption, error) { return s.pending, nil }
+
+func (s *fakeStore) SaveAdoption(id int64, a Adoption) error {
+	s.adoptions[id] = a
+	return nil
+}
+
+func (s *fakeStore) RecordSnapshot() error {
+	if s.snapshotErr != nil {
+		return s.snapshotErr
+	}
+	s.snapshots++
+	return nil
+}
+
+// batchStore adds BatchStore to fakeStore
+type batchStore struct {
+	*fakeStore
+	err error
+}
+
+func (s *batchStore) SaveProjects(projects []Project) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, p := range projects {
+		s.saved[p.RepoFullName] = p
+	}
+	return nil
+}
+
+var testQueries = []Query{{Name: "Dockerfile", Query: `"dhi.io/" filename:Dockerfile`}}
+
+func TestRun(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	adopted := &Adoption{Date: now.AddDate(0, -1, 0), CommitURL: "https://github.com/acme/new/commit/abc123"}
+	source := &fakeSource{
+		projects: []Project{
+			{RepoFullName: "acme/kept", MatchedQueries: []string{"Dockerfile"}},
+			{RepoFullName: "acme/new", MatchedQueries: []string{"Dockerfile"}},
+		},
+		gone:      map[string]bool{"acme/gone": true},
+		adoptions: map[string]*Adoption{"acme/new": adopted},
+	}
+	store := newFakeStore("acme/kept", "acme/gone", "acme/unmatched")
+	store.pending = []PendingAdoption{{ID: 7, RepoFullName: "acme/new", FilePath: "Dockerfile"}, {ID: 8, RepoFullName: "acme/unknown"}}
+
+	var kinds []EventKind
+	res, err := New(store, source, testQueries, Options{
+		Now:     func() time.Time { return now },
+		OnEvent: func(e Event) { kinds = append(kinds, e.Kind) },
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if !reflect.DeepEqual(res.Added, []string{"acme/new"}) {
+		t.Errorf("Added = %v", res.Added)
+	}
+	if !reflect.DeepEqual(res.Removed, []string{"acme/gone", "acme/unmatched"}) {
+		t.Errorf("Removed = %v", res.Removed)
+	}
+	if !reflect.DeepEqual(res.SoftDeleted, []string{"acme/gone"}) || !reflect.DeepEqual(store.softDeleted, []string{"acme/gone"}) {
+		t.Errorf("SoftDeleted = %v, store soft-deleted %v; want only the repo that's gone", res.SoftDeleted, store.softDeleted)
+	}
+	if len(store.saved) != 2 || len(store.matches) != 2 {
+		t.Errorf("saved %d projects and %d query matches, want 2 of each", len(store.saved), len(store.matches))
+	}
+	if !store.inactiveAt.Equal(now) || res.Inactive != 2 {
+		t.Errorf("MarkInactive(%v) flagged %d, want before %v and 2", store.inactiveAt, res.Inactive, now)
+	}
+	if res.Adoptions != 1 || store.adoptions[7] != *adopted {
+		t.Errorf("Adoptions = %d, saved %v; want project 7 adopted", res.Adoptions, store.adoptions)
+	}
+	if !res.Snapshot || store.snapshots != 1 {
+		t.Errorf("Snapshot = %v with %d recorded, want one", res.Snapshot, store.snapshots)
+	}
+
+	seen := make(map[EventKind]int)
+	for _, k := range kinds {
+		seen[k]++
+	}
+	for _, k := range []EventKind{EventProgress, EventDiscovered, EventDiff, EventSoftDeleted, EventInactive, EventAdoption, EventSnapshot} {
+		if seen[k] == 0 {
+			t.Errorf("no %s event", k)
+		}
+	}
+	if seen[EventError] != 1 {
+		t.Errorf("%d error events, want 1 for the adoption that wasn't found", seen[EventError])
+	}
+}
+
+func TestRunDiscoverError(t *testing.T) {
+	store := newFakeStore("acme/kept")
+	_, err := New(store, &fakeSource{err: errors.New("rate limited")}, testQueries, Options{}).Run(context.Background())
+	if err == nil || err.Error() != "rate limited" {
+		t.Fatalf("err = %v, want the discovery error", err)
+	}
+	if len(store.saved) != 0 || store.removed != nil || store.snapshots != 0 {
+		t.Errorf("store changed after failed discovery: %+v", store)
+	}
+}
+
+func TestRunBatchStore(t *testing.T) {
+	source := &fakeSource{projects: []Project{{RepoFullName: "acme/a"}, {RepoFullName: "acme/b"}}}
+
+	store := &batchStore{fakeStore: newFakeStore()}
+	if _, err := New(store, source, testQueries, Options{}).RunPartial(context.Background(), ScopeDiscover); err != nil {
+		t.Fatalf("RunPartial: %v", err)
+	}
+	if len(store.saved) != 2 {
+		t.Errorf("saved %d projects, want 2", len(store.saved))
+	}
+
+	failing := &batchStore{fakeStore: newFakeStore(), err: errors.New("disk full")}
+	_, err := New(failing, source, testQueries, Options{}).RunPartial(context.Background(), ScopeDiscover)
+	if err == nil {
+		t.Fatal("RunPartial succeeded although SaveProjects failed")
+	}
+	if failing.added != nil || failing.removed != nil {
+		t.Errorf("diff recorded after SaveProjects failed: +%v -%v", failing.added, failing.removed)
+	}
+}
+
+func TestRunSnapshotSkipped(t *testing.T) {
+	store := newFakeStore()
+	store.snapshotErr = ErrSnapshotSkipped
+	var errs []error
+	res, err := New(store, &fakeSource{}, testQueries, Options{
+		OnEvent: func(e Event) {
+			if e.Kind == EventError {
+				errs = append(errs, e.Err)
+			}
+		},
+	}).RunPartial(context.Background(), ScopeSnapshot)
+	if err != nil {
+		t.Fatalf("RunPartial: %v", err)
+	}
+	if res.Snapshot || len(errs) != 0 {
+		t.Errorf("Snapshot = %v, errors %v; a skipped snapshot is neither", res.Snapshot, errs)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := newFakeStore("acme/a", "acme/b")
+	source := &fakeSource{discoverFn: func(context.Context) error {
+		cancel()
+		return nil
+	}}
+	_, err := New(store, source, testQueries, Options{}).Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(store.softDeleted) != 0 || store.snapshots != 0 {
+		t.Errorf("cancelled run kept going: soft-deleted %v, %d snapshots", store.softDeleted, store.snapshots)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name           string
+		previous       []string
+		found          []string
+		added, removed []string
+	}{
+		{"empty", nil, nil, nil, nil},
+		{"first run", nil, []string{"a/x", "a/y"}, []string{"a/x", "a/y"}, nil},
+		{"unchanged", []string{"a/x"}, []string{"a/x"}, nil, nil},
+		{"both", []string{"a/x", "a/y"}, []string{"a/y", "a/z"}, []string{"a/z"}, []string{"a/x"}},
+		{"all gone", []string{"a/x"}, nil, nil, []string{"a/x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := make([]Project, len(tt.found))
+			for i, name := range tt.found {
+				found[i] = Project{RepoFullName: name}
+			}
+			added, removed := Diff(tt.previous, found)
+			sort.Strings(added)
+			sort.Strings(removed)
+			if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("Diff = +%v -%v, want +%v -%v", added, removed, tt.added, tt.removed)
+			}
+		})
+	}
+}